@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// adminToken authorizes the admin endpoints, as a bearer token. They are
+// disabled if ADMIN_TOKEN is unset.
+var adminToken = os.Getenv("ADMIN_TOKEN")
+
+// requireAdmin wraps an admin handler, checking the bearer token.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			http.Error(w, "Admin API not configured", http.StatusServiceUnavailable)
+			return
+		}
+		if !isAdmin(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// isAdmin tells if the request carries the admin token, as a bearer token.
+// strings.CutPrefix needs Go 1.20, the service builds with 1.19.
+func isAdmin(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if adminToken == "" || !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := auth[len("Bearer "):]
+	return subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcmd"
+	"github.com/icza/screp/rep/repcore"
+)
+
+// framesPerSecond is the game speed of "Fastest" in frames per real second.
+const framesPerSecond = 23.81
+
+func frameToSeconds(f repcore.Frame) float64 {
+	return float64(f) / framesPerSecond
+}
+
+func secondsToFrames(s float64) repcore.Frame {
+	return repcore.Frame(s * framesPerSecond)
+}
+
+// isMeaningful tells if the command changes the game state, as opposed to
+// selection changes, hotkey management, chat and the like.
+func isMeaningful(cmd repcmd.Cmd) bool {
+	base := cmd.BaseCmd()
+	if base == nil || base.Type == nil {
+		return false
+	}
+	switch base.Type.ID {
+	case repcmd.TypeIDBuild, repcmd.TypeIDTrain, repcmd.TypeIDUnitMorph, repcmd.TypeIDBuildingMorph,
+		repcmd.TypeIDRightClick, repcmd.TypeIDRightClick121,
+		repcmd.TypeIDTargetedOrder, repcmd.TypeIDTargetedOrder121,
+		repcmd.TypeIDStop, repcmd.TypeIDHoldPosition, repcmd.TypeIDReturnCargo,
+		repcmd.TypeIDTech, repcmd.TypeIDUpgrade, repcmd.TypeIDCancelTrain,
+		repcmd.TypeIDUnload, repcmd.TypeIDUnload121, repcmd.TypeIDUnloadAll,
+		repcmd.TypeIDSiege, repcmd.TypeIDUnsiege, repcmd.TypeIDBurrow, repcmd.TypeIDUnburrow,
+		repcmd.TypeIDCloack, repcmd.TypeIDDecloack, repcmd.TypeIDStim,
+		repcmd.TypeIDLiftOff, repcmd.TypeIDTrainFighter,
+		repcmd.TypeIDMergeArchon, repcmd.TypeIDMergeDarkArchon:
+		return true
+	}
+	return false
+}
+
+// round rounds x to the given number of decimals.
+func round(x float64, decimals int) float64 {
+	p := math.Pow(10, float64(decimals))
+	return math.Round(x*p) / p
+}
+
+// formatClock formats the game time of the frame as m:ss.
+func formatClock(f repcore.Frame) string {
+	secs := int(frameToSeconds(f))
+	return fmt.Sprintf("%d:%02d", secs/60, secs%60)
+}
+
+func sortFrames(frames []repcore.Frame) {
+	sort.Slice(frames, func(i, j int) bool { return frames[i] < frames[j] })
+}
+
+// tileSize is the size of a map tile in pixels, the unit of command positions.
+const tileSize = 32
+
+// cmdPos returns the map position targeted by the command, if it has one.
+func cmdPos(cmd repcmd.Cmd) (repcore.Point, bool) {
+	switch c := cmd.(type) {
+	case *repcmd.BuildCmd:
+		return c.Pos, true
+	case *repcmd.TargetedOrderCmd:
+		return c.Pos, true
+	case *repcmd.RightClickCmd:
+		return c.Pos, true
+	case *repcmd.LandCmd:
+		return c.Pos, true
+	}
+	return repcore.Point{}, false
+}
+
+// dist returns the distance of two points in pixels.
+func dist(a, b repcore.Point) float64 {
+	return math.Hypot(float64(a.X)-float64(b.X), float64(a.Y)-float64(b.Y))
+}
+
+// startLocations maps player IDs to their start location on the map.
+func startLocations(rp *rep.Replay) map[int]repcore.Point {
+	locs := map[int]repcore.Point{}
+	if rp.MapData == nil {
+		return locs
+	}
+	for pid, p := range rp.Header.Players {
+		for _, sl := range rp.MapData.StartLocations {
+			if uint16(sl.SlotID) == p.SlotID {
+				locs[pid] = sl.Point
+			}
+		}
+	}
+	return locs
+}
+
+// playerBase is a main or expansion of a player, Frame is when it was founded.
+type playerBase struct {
+	pos   repcore.Point
+	frame repcore.Frame
+}
+
+// playerBases returns the bases of every player: the start location and the
+// positions of town hall build commands.
+func playerBases(rp *rep.Replay) [][]playerBase {
+	bases := make([][]playerBase, len(rp.Header.Players))
+	for pid, s := range startLocations(rp) {
+		bases[pid] = append(bases[pid], playerBase{pos: s})
+	}
+	for _, cmd := range rp.Commands {
+		c, ok := cmd.(*repcmd.BuildCmd)
+		if ok && isTownHall(c.Unit) && int(c.PlayerID) < len(bases) {
+			bases[c.PlayerID] = append(bases[c.PlayerID], playerBase{pos: c.Pos, frame: c.Frame})
+		}
+	}
+	return bases
+}
+
+// nearestBase returns the distance to the nearest of the bases founded by frame f.
+func nearestBase(bases []playerBase, pos repcore.Point, f repcore.Frame) float64 {
+	nearest := math.Inf(1)
+	for _, b := range bases {
+		if b.frame <= f {
+			nearest = math.Min(nearest, dist(pos, b.pos))
+		}
+	}
+	return nearest
+}
+
+// isTownHall tells if the unit is a resource depot.
+func isTownHall(u *repcmd.Unit) bool {
+	return u != nil && (u.ID == repcmd.UnitIDCommandCenter || u.ID == repcmd.UnitIDNexus || u.ID == repcmd.UnitIDHatchery)
+}
+
+// isEnemy tells if two players are on different teams.
+func isEnemy(rp *rep.Replay, a, b int) bool {
+	if a == b || a >= len(rp.Header.Players) || b >= len(rp.Header.Players) {
+		return false
+	}
+	return rp.Header.Players[a].Team != rp.Header.Players[b].Team
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcmd"
+	"github.com/icza/screp/rep/repcore"
+)
+
+// Anomaly kinds
+const (
+	AnomalyBurstAPM = "sustained_burst_apm"
+	AnomalyPeriodic = "periodic_input"
+)
+
+// Anomaly flags a physically implausible action pattern of a player, a hint
+// of bot or macro usage for human review.
+type Anomaly struct {
+	PlayerID       int    `json:"playerId"`
+	Kind           string `json:"kind"`
+	Description    string `json:"description"`
+	EvidenceFrames []int  `json:"evidenceFrames"`
+}
+
+// Thresholds of the anomaly heuristics
+const (
+	burstWindowSeconds = 10
+	burstMinAPM        = 600
+	burstMinWindows    = 3 // Consecutive windows, i.e. sustained for 30s
+
+	periodicMinRun    = 30 // Consecutive intervals
+	periodicMaxJitter = 1  // Frames
+	periodicMinMean   = 2  // Frames, to skip same-frame command groups
+)
+
+// detectAnomalies runs the action-rate heuristics on the meaningful commands
+// of every player. The periodicity test skips repeats of the previous
+// command on the same selection: a held hotkey, e.g. to queue units, repeats
+// at the keyboard's autorepeat rate, as periodic as a bot.
+func detectAnomalies(rp *rep.Replay) []Anomaly {
+	frames := map[int][]repcore.Frame{}
+	distinct := map[int][]repcore.Frame{} // Without repeats
+	last := map[int]string{}              // Previous command on the selection
+	for _, cmd := range rp.Commands {
+		base := cmd.BaseCmd()
+		if base == nil {
+			continue
+		}
+		pid := int(base.PlayerID)
+		switch cmd.(type) {
+		case *repcmd.SelectCmd, *repcmd.HotkeyCmd:
+			delete(last, pid)
+		}
+		if !isMeaningful(cmd) {
+			continue
+		}
+		frames[pid] = append(frames[pid], base.Frame)
+		if key := base.Type.Name + " " + cmd.Params(false); key != last[pid] {
+			distinct[pid] = append(distinct[pid], base.Frame)
+			last[pid] = key
+		}
+	}
+
+	var anomalies []Anomaly
+	for pid := range rp.Header.Players {
+		anomalies = append(anomalies, burstAnomalies(pid, frames[pid])...)
+		anomalies = append(anomalies, periodicAnomalies(pid, distinct[pid])...)
+	}
+	return anomalies
+}
+
+// burstAnomalies flags runs of consecutive windows above burstMinAPM.
+func burstAnomalies(pid int, frames []repcore.Frame) []Anomaly {
+	window := secondsToFrames(burstWindowSeconds)
+	minCount := burstMinAPM * burstWindowSeconds / 60
+	counts := map[int]int{}
+	last := 0
+	for _, f := range frames {
+		w := int(f / window)
+		counts[w]++
+		last = w
+	}
+
+	var anomalies []Anomaly
+	runStart := -1
+	for w := 0; w <= last+1; w++ {
+		if counts[w] > minCount {
+			if runStart < 0 {
+				runStart = w
+			}
+			continue
+		}
+		if runStart >= 0 && w-runStart >= burstMinWindows {
+			var evidence []int
+			peak := 0
+			for i := runStart; i < w; i++ {
+				evidence = append(evidence, i*int(window))
+				if counts[i] > peak {
+					peak = counts[i]
+				}
+			}
+			anomalies = append(anomalies, Anomaly{
+				PlayerID: pid,
+				Kind:     AnomalyBurstAPM,
+				Description: fmt.Sprintf("%d s above %d APM of meaningful commands (peak %d APM)",
+					(w-runStart)*burstWindowSeconds, burstMinAPM, peak*60/burstWindowSeconds),
+				EvidenceFrames: evidence,
+			})
+		}
+		runStart = -1
+	}
+	return anomalies
+}
+
+// periodicAnomalies flags long runs of inputs with (nearly) identical
+// intervals, which humans can't produce.
+func periodicAnomalies(pid int, frames []repcore.Frame) []Anomaly {
+	var anomalies []Anomaly
+	flush := func(start, end int) { // Intervals [start, end) i.e. frames [start, end]
+		if end-start < periodicMinRun {
+			return
+		}
+		mean := float64(frames[end]-frames[start]) / float64(end-start)
+		if mean < periodicMinMean {
+			return
+		}
+		anomalies = append(anomalies, Anomaly{
+			PlayerID: pid,
+			Kind:     AnomalyPeriodic,
+			Description: fmt.Sprintf("%d consecutive commands %.1f frames apart (±%d)",
+				end-start+1, mean, periodicMaxJitter),
+			EvidenceFrames: []int{int(frames[start]), int(frames[end])},
+		})
+	}
+
+	start := 0
+	lo, hi := repcore.Frame(-1), repcore.Frame(-1)
+	for i := 1; i < len(frames); i++ {
+		d := frames[i] - frames[i-1]
+		if lo < 0 || d < lo {
+			lo = d
+		}
+		if d > hi {
+			hi = d
+		}
+		if hi-lo > periodicMaxJitter {
+			flush(start, i-1)
+			start, lo, hi = i-1, d, d
+		}
+	}
+	if len(frames) > 1 {
+		flush(start, len(frames)-1)
+	}
+	return anomalies
+}
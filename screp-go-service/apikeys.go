@@ -0,0 +1,398 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// APIKey is a tenant's key. Quota and rate limit apply per key; zero means
+// unlimited.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	DailyQuota int        `json:"dailyQuota"` // Requests per UTC day
+	RateLimit  int        `json:"rateLimit"`  // Requests per minute
+	CreatedAt  time.Time  `json:"createdAt"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	Usage      *KeyUsage  `json:"usage,omitempty"`
+}
+
+// KeyUsage is the usage of a key since the service started.
+type KeyUsage struct {
+	Requests   int64      `json:"requests"`
+	Today      int        `json:"today"` // Requests of the current UTC day, counting against the quota
+	Rejected   int64      `json:"rejected"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// CreatedKey is the response to creating a key, the only time the secret
+// is shown.
+type CreatedKey struct {
+	APIKey
+	Secret string `json:"secret"`
+}
+
+// KeyRequest creates or updates a key; for updates nil fields are unchanged.
+type KeyRequest struct {
+	Name       *string `json:"name"`
+	DailyQuota *int    `json:"dailyQuota"`
+	RateLimit  *int    `json:"rateLimit"`
+}
+
+// apiKey is a key with its secret hash and live usage.
+type apiKey struct {
+	APIKey
+	SecretHash string `json:"secretHash"`
+
+	usage   KeyUsage
+	day     string // UTC date of usage.Today
+	limiter *rateLimiter
+}
+
+// keyStore holds the API keys, persisted to API_KEYS_FILE if set. Only the
+// hashes of the secrets are kept. Usage lives in memory.
+type keyStore struct {
+	mu     sync.Mutex
+	path   string
+	keys   map[string]*apiKey // By ID
+	byHash map[string]*apiKey
+}
+
+var apiKeys = loadKeyStore(os.Getenv("API_KEYS_FILE"))
+
+// requireAPIKey rejects requests without an API key if REQUIRE_API_KEY is
+// true. Otherwise keys are optional, and only requests with one are limited.
+var requireAPIKey, _ = strconv.ParseBool(os.Getenv("REQUIRE_API_KEY"))
+
+func loadKeyStore(path string) *keyStore {
+	s := &keyStore{path: path, keys: map[string]*apiKey{}, byHash: map[string]*apiKey{}}
+	if path == "" {
+		return s
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s
+	}
+	var keys []*apiKey
+	if err == nil {
+		err = json.Unmarshal(data, &keys)
+	}
+	if err != nil {
+		log.Fatalf("Error loading API keys: %v", err)
+	}
+	for _, k := range keys {
+		k.Usage = nil
+		s.index(k)
+	}
+	return s
+}
+
+func (s *keyStore) index(k *apiKey) {
+	k.setLimiter()
+	s.keys[k.ID] = k
+	s.byHash[k.SecretHash] = k
+}
+
+// setLimiter sets a new, full rate limiter for the key's rate limit.
+func (k *apiKey) setLimiter() {
+	if k.RateLimit > 0 {
+		k.limiter = newRateLimiter(float64(k.RateLimit)/60, float64(k.RateLimit))
+	} else {
+		k.limiter = nil
+	}
+}
+
+// save persists the keys. Must be called with s.mu held.
+func (s *keyStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	keys := make([]*apiKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// view returns a copy of the key with its usage.
+func (k *apiKey) view() APIKey {
+	v := k.APIKey
+	u := k.usage
+	if k.day != time.Now().UTC().Format("2006-01-02") {
+		u.Today = 0
+	}
+	v.Usage = &u
+	return v
+}
+
+func (s *keyStore) create(req KeyRequest) (CreatedKey, error) {
+	secret := "srk_" + newID()
+	k := &apiKey{APIKey: APIKey{ID: newShareID(), CreatedAt: time.Now().UTC()}, SecretHash: hashSecret(secret)}
+	k.apply(req)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.index(k)
+	if err := s.save(); err != nil {
+		delete(s.keys, k.ID)
+		delete(s.byHash, k.SecretHash)
+		return CreatedKey{}, err
+	}
+	return CreatedKey{APIKey: k.view(), Secret: secret}, nil
+}
+
+func (k *apiKey) apply(req KeyRequest) {
+	if req.Name != nil {
+		k.Name = *req.Name
+	}
+	if req.DailyQuota != nil {
+		k.DailyQuota = *req.DailyQuota
+	}
+	if req.RateLimit != nil {
+		k.RateLimit = *req.RateLimit
+	}
+}
+
+// update applies fn to the key and persists the change, which is undone if
+// that fails. The rate limiter only starts over if the rate limit changed, so
+// updates don't refill it.
+func (s *keyStore) update(id string, fn func(k *apiKey)) (APIKey, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return APIKey{}, false, nil
+	}
+	old := k.APIKey
+	fn(k)
+	if err := s.save(); err != nil {
+		k.APIKey = old
+		return APIKey{}, true, err
+	}
+	if k.RateLimit != old.RateLimit {
+		k.setLimiter()
+	}
+	return k.view(), true, nil
+}
+
+func (s *keyStore) list() []APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k.view())
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys
+}
+
+func (s *keyStore) get(id string) (APIKey, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return APIKey{}, false
+	}
+	return k.view(), true
+}
+
+// requestSecret returns the API key of the request: the X-API-Key header,
+// or a bearer token other than the admin token.
+func requestSecret(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") && !isAdmin(r) {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// lookup returns the ID of the valid key with the given secret.
+func (s *keyStore) lookup(secret string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.byHash[hashSecret(secret)]
+	if !ok || k.RevokedAt != nil {
+		return "", false
+	}
+	return k.ID, true
+}
+
+// use counts a request of the key against its rate limit and quota. If it's
+// rejected, it returns the reason and when to retry.
+func (s *keyStore) use(id string) (reason string, retryAfter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := s.keys[id]
+	now := time.Now().UTC()
+	if day := now.Format("2006-01-02"); k.day != day {
+		k.day, k.usage.Today = day, 0
+	}
+	switch {
+	case k.DailyQuota > 0 && k.usage.Today >= k.DailyQuota:
+		reason = "Daily quota exceeded"
+		retryAfter = now.Truncate(24 * time.Hour).Add(24 * time.Hour).Sub(now)
+	case k.limiter != nil:
+		if ok, wait := k.limiter.allow(); !ok {
+			reason, retryAfter = "Rate limit exceeded", wait
+		}
+	}
+	if reason != "" {
+		k.usage.Rejected++
+		return reason, retryAfter
+	}
+	k.usage.Requests++
+	k.usage.Today++
+	k.usage.LastUsedAt = &now
+	return "", 0
+}
+
+// openPaths don't need an API key: health checks, docs and public share links.
+var openPaths = []string{"/health", "/health/dependencies", "/docs", "/openapi.json", "/schemas/", "/s/", "/admin/"}
+
+func isOpenPath(p string) bool {
+	for _, o := range openPaths {
+		if p == o || strings.HasSuffix(o, "/") && strings.HasPrefix(p, o) {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKeyMiddleware authenticates requests with API keys and enforces their
+// rate limits and quotas. The admin token passes, admin endpoints check it
+// themselves.
+func apiKeyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isAdmin(r) || isOpenPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		secret := requestSecret(r)
+		if secret == "" {
+			if requireAPIKey {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "Missing API key", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		id, ok := apiKeys.lookup(secret)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if reason, retry := apiKeys.use(id); reason != "" {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+			http.Error(w, reason, http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decodeKeyRequest decodes and validates the body of a key request.
+func decodeKeyRequest(w http.ResponseWriter, r *http.Request) (KeyRequest, bool) {
+	var req KeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return req, false
+	}
+	if req.DailyQuota != nil && *req.DailyQuota < 0 || req.RateLimit != nil && *req.RateLimit < 0 {
+		http.Error(w, "Quota and rate limit must be 0 (unlimited) or more", http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+func createKeyHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeKeyRequest(w, r)
+	if !ok {
+		return
+	}
+	k, err := apiKeys.create(req)
+	if err != nil {
+		log.Printf("Error saving API keys: %v", err)
+		http.Error(w, "Failed to save API keys", http.StatusInternalServerError)
+		return
+	}
+	setAuditResource(r, k.ID)
+	writeJSONStatus(w, http.StatusCreated, k)
+}
+
+func listKeysHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, apiKeys.list())
+}
+
+func getKeyHandler(w http.ResponseWriter, r *http.Request) {
+	k, ok := apiKeys.get(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, k)
+}
+
+// writeKeyUpdate responds to a key update.
+func writeKeyUpdate(w http.ResponseWriter, k APIKey, found bool, err error) {
+	switch {
+	case !found:
+		http.Error(w, "Key not found", http.StatusNotFound)
+	case err != nil:
+		log.Printf("Error saving API keys: %v", err)
+		http.Error(w, "Failed to save API keys", http.StatusInternalServerError)
+	default:
+		writeJSON(w, k)
+	}
+}
+
+// updateKeyHandler changes the name, quota or rate limit of a key.
+func updateKeyHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeKeyRequest(w, r)
+	if !ok {
+		return
+	}
+	k, found, err := apiKeys.update(mux.Vars(r)["id"], func(k *apiKey) { k.apply(req) })
+	writeKeyUpdate(w, k, found, err)
+}
+
+// revokeKeyHandler revokes a key. Revoked keys stay listed for their usage.
+func revokeKeyHandler(w http.ResponseWriter, r *http.Request) {
+	k, found, err := apiKeys.update(mux.Vars(r)["id"], func(k *apiKey) {
+		if k.RevokedAt == nil {
+			now := time.Now().UTC()
+			k.RevokedAt = &now
+		}
+	})
+	writeKeyUpdate(w, k, found, err)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func intPtr(n int) *int { return &n }
+
+func TestKeyDailyQuota(t *testing.T) {
+	s := loadKeyStore("")
+	k, err := s.create(KeyRequest{DailyQuota: intPtr(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if reason, _ := s.use(k.ID); reason != "" {
+			t.Fatalf("request %d rejected: %s", i, reason)
+		}
+	}
+	reason, retry := s.use(k.ID)
+	if reason != "Daily quota exceeded" || retry <= 0 {
+		t.Fatalf("over quota: got %q, retry %v", reason, retry)
+	}
+
+	// The quota starts over with the UTC day
+	s.keys[k.ID].day = "2000-01-01"
+	if reason, _ := s.use(k.ID); reason != "" {
+		t.Errorf("next day rejected: %s", reason)
+	}
+	if v, _ := s.get(k.ID); v.Usage.Today != 1 || v.Usage.Requests != 3 || v.Usage.Rejected != 1 {
+		t.Errorf("got usage %+v", *v.Usage)
+	}
+}
+
+func TestKeyRateLimitUpdate(t *testing.T) {
+	s := loadKeyStore("")
+	k, err := s.create(KeyRequest{RateLimit: intPtr(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason, _ := s.use(k.ID); reason != "" {
+		t.Fatalf("first request rejected: %s", reason)
+	}
+	if reason, retry := s.use(k.ID); reason != "Rate limit exceeded" || retry <= 0 {
+		t.Fatalf("over limit: got %q, retry %v", reason, retry)
+	}
+
+	// Renaming doesn't refill the limiter, changing the limit does
+	name := "renamed"
+	if _, _, err := s.update(k.ID, func(k *apiKey) { k.apply(KeyRequest{Name: &name}) }); err != nil {
+		t.Fatal(err)
+	}
+	if reason, _ := s.use(k.ID); reason == "" {
+		t.Error("rename refilled the rate limiter")
+	}
+	if _, _, err := s.update(k.ID, func(k *apiKey) { k.apply(KeyRequest{RateLimit: intPtr(2)}) }); err != nil {
+		t.Fatal(err)
+	}
+	if reason, _ := s.use(k.ID); reason != "" {
+		t.Errorf("after raising the limit: %s", reason)
+	}
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	saved := apiKeys
+	defer func() { apiKeys = saved }()
+	apiKeys = loadKeyStore("")
+	k, err := apiKeys.create(KeyRequest{DailyQuota: intPtr(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := apiKeyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		header, value string
+		status        int
+	}{
+		{"X-API-Key", k.Secret, http.StatusOK},
+		{"X-API-Key", k.Secret, http.StatusTooManyRequests},
+		{"Authorization", "Bearer " + k.Secret, http.StatusTooManyRequests},
+		{"X-API-Key", "srk_unknown", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/parse", nil)
+		req.Header.Set(tt.header, tt.value)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: got %d, want %d", tt.header, rec.Code, tt.status)
+		}
+		if tt.status == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: no Retry-After", tt.header)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// APM smoothing methods
+const (
+	SmoothingNone    = "none"
+	SmoothingRolling = "rolling"
+	SmoothingEMA     = "ema"
+)
+
+// Defaults and limits of the APM series options, in seconds
+const (
+	defaultAPMStep   = 10
+	maxAPMStep       = 60
+	defaultAPMWindow = 60
+	maxAPMWindow     = 600
+)
+
+// APMSeries is the APM over time of every player. Values[i] is the APM of
+// the step starting at i*Step seconds, smoothed over Window seconds.
+type APMSeries struct {
+	Step      int         `json:"step"` // Seconds per value
+	Smoothing string      `json:"smoothing"`
+	Window    int         `json:"window,omitempty"` // Seconds, 0 without smoothing
+	Players   []PlayerAPM `json:"players"`
+}
+
+type PlayerAPM struct {
+	PlayerID int       `json:"playerId"`
+	Name     string    `json:"name"`
+	Values   []float64 `json:"values"`
+}
+
+// apmOptions configure the series. The window is rounded to whole steps.
+type apmOptions struct {
+	step, window int
+	smoothing    string
+}
+
+// parseAPMOptions reads the step, smoothing and window query parameters.
+func parseAPMOptions(q url.Values) (apmOptions, error) {
+	o := apmOptions{step: defaultAPMStep, window: defaultAPMWindow, smoothing: SmoothingRolling}
+	if s := q.Get("step"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxAPMStep {
+			return o, errors.New("step must be 1 to " + strconv.Itoa(maxAPMStep) + " seconds")
+		}
+		o.step = n
+	}
+	if s := q.Get("smoothing"); s != "" {
+		if s != SmoothingNone && s != SmoothingRolling && s != SmoothingEMA {
+			return o, errors.New("smoothing must be none, rolling or ema")
+		}
+		o.smoothing = s
+	}
+	if s := q.Get("window"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxAPMWindow {
+			return o, errors.New("window must be 1 to " + strconv.Itoa(maxAPMWindow) + " seconds")
+		}
+		o.window = n
+	}
+	if o.smoothing == SmoothingNone {
+		o.window = 0
+	} else if o.window < o.step {
+		o.window = o.step
+	} else {
+		o.window -= o.window % o.step
+	}
+	return o, nil
+}
+
+// key identifies the options in ETags.
+func (o apmOptions) key() string {
+	return o.smoothing + "-" + strconv.Itoa(o.window) + "-" + strconv.Itoa(o.step)
+}
+
+// apmSeries counts the actions of the result per step and smooths them.
+// The rolling mean is trailing, over the last window seconds, and over the
+// steps so far at the start. The EMA weighs the steps with
+// alpha = 2/(window/step + 1), like a rolling mean of the same window,
+// starting at the first value. Both are causal, so a spike shows up when it
+// happens and fades out afterwards.
+func apmSeries(res *ReplayResult, o apmOptions) APMSeries {
+	s := APMSeries{Step: o.step, Smoothing: o.smoothing, Window: o.window, Players: make([]PlayerAPM, len(res.Players))}
+	steps := int(res.DurationSeconds)/o.step + 1
+	counts := make([][]float64, len(res.Players))
+	for i, p := range res.Players {
+		counts[i] = make([]float64, steps)
+		s.Players[i] = PlayerAPM{PlayerID: p.ID, Name: p.Name, Values: make([]float64, steps)}
+	}
+	for _, a := range res.Actions {
+		if k := int(a.Time) / o.step; a.PlayerID < len(counts) && k < steps {
+			counts[a.PlayerID][k]++
+		}
+	}
+
+	perMinute := 60 / float64(o.step)
+	n := o.window / o.step
+	for i, c := range counts {
+		values := s.Players[i].Values
+		sum, ema := 0.0, 0.0
+		for k, v := range c {
+			apm := v * perMinute
+			switch o.smoothing {
+			case SmoothingRolling:
+				sum += apm
+				if k >= n {
+					sum -= c[k-n] * perMinute
+				}
+				values[k] = sum / float64(minInt(k+1, n))
+			case SmoothingEMA:
+				if k == 0 {
+					ema = apm
+				} else {
+					alpha := 2 / (float64(n) + 1)
+					ema += alpha * (apm - ema)
+				}
+				values[k] = ema
+			default:
+				values[k] = apm
+			}
+		}
+		for k := range values {
+			values[k] = round(values[k], 1)
+		}
+	}
+	return s
+}
+
+// apmHandler parses an uploaded replay and returns its APM series.
+func apmHandler(w http.ResponseWriter, r *http.Request) {
+	o, err := parseAPMOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid options: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	file, _, err := r.FormFile("replay")
+	if err != nil {
+		http.Error(w, "Missing replay file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	res, err := parseReplay(file)
+	if err != nil {
+		http.Error(w, "Parse error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer res.release()
+	writeJSON(w, apmSeries(res, o))
+}
+
+// jobAPMHandler returns the APM series of a parsed job, or of the replay
+// named by the replay query parameter of a batch job, with long-lived cache
+// headers like the overlay summary.
+func jobAPMHandler(w http.ResponseWriter, r *http.Request) {
+	o, err := parseAPMOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid options: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	job, res, item, ok := jobReplayResult(w, r)
+	if !ok {
+		return
+	}
+
+	etag := `"` + job.ID + item + "-" + o.key() + `"`
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, apmSeries(res, o))
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ArchiveIndex is index.json of a job's results archive.
+type ArchiveIndex struct {
+	JobID     string         `json:"jobId"`
+	CreatedAt time.Time      `json:"createdAt"`
+	Clock     string         `json:"clock"`  // Of the times in all files
+	Report    string         `json:"report"` // Format of the reports, html or pdf
+	Replays   []ArchiveEntry `json:"replays"`
+}
+
+// ArchiveEntry lists the files of one replay of the archive, in Dir. Replays
+// that failed to parse have no files, only the error.
+type ArchiveEntry struct {
+	Name       string   `json:"name,omitempty"` // Name in the batch
+	ReplayHash string   `json:"replayHash,omitempty"`
+	Dir        string   `json:"dir,omitempty"`
+	Files      []string `json:"files"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// archiveDir returns the directory of the i-th replay in the archive: the
+// position, for ordering and uniqueness, and a slug of the file name.
+func archiveDir(i int, name string) string {
+	slug := nameSlug(strings.TrimSuffix(path.Base(name), path.Ext(name)))
+	if slug == "" {
+		slug = "replay"
+	}
+	return fmt.Sprintf("%03d-%s", i+1, slug)
+}
+
+// writeArchive writes the zip of the results of the job's replays: per
+// replay result.json, features.csv and the report, all in the clock, and
+// index.json.
+func writeArchive(w io.Writer, job Job, clock, format string) error {
+	type replay struct {
+		name, hash, err string
+		res             *ReplayResult
+	}
+	var replays []replay
+	if job.Result != nil {
+		replays = append(replays, replay{hash: job.ReplayHash, res: job.Result})
+	}
+	for _, item := range job.Batch {
+		replays = append(replays, replay{item.Name, item.ReplayHash, item.Error, item.Result})
+	}
+
+	zw := zip.NewWriter(w)
+	create := func(name string) (io.Writer, error) {
+		return zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: job.UpdatedAt})
+	}
+	index := ArchiveIndex{JobID: job.ID, CreatedAt: time.Now().UTC(), Clock: clock, Report: format, Replays: []ArchiveEntry{}}
+	for i, rp := range replays {
+		e := ArchiveEntry{Name: rp.name, ReplayHash: rp.hash, Files: []string{}, Error: rp.err}
+		if rp.res == nil {
+			if e.Error == "" {
+				e.Error = "no result"
+			}
+			index.Replays = append(index.Replays, e)
+			continue
+		}
+		e.Dir = archiveDir(i, rp.name)
+		res := rp.res.inClock(clock)
+		files := []struct {
+			name  string
+			write func(io.Writer) error
+		}{
+			{"result.json", func(w io.Writer) error {
+				enc := json.NewEncoder(w)
+				enc.SetIndent("", "  ")
+				return enc.Encode(res)
+			}},
+			{"features.csv", func(w io.Writer) error {
+				return writeDatasetCSV(w, replayDatasetRows(job.ID, rp.name, res))
+			}},
+			{"report." + format, func(w io.Writer) error {
+				if format == "pdf" {
+					_, err := w.Write(reportPDF(res))
+					return err
+				}
+				return reportTemplate.Execute(w, newReportData(res))
+			}},
+		}
+		for _, f := range files {
+			fw, err := create(e.Dir + "/" + f.name)
+			if err == nil {
+				err = f.write(fw)
+			}
+			if err != nil {
+				return err
+			}
+			e.Files = append(e.Files, f.name)
+		}
+		index.Replays = append(index.Replays, e)
+	}
+
+	fw, err := create("index.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(fw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(index); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// jobArchiveHandler downloads the results of all replays of a job as a zip,
+// e.g. to archive the analyses of a tournament's batch. The report format
+// and the clock of the results are selected like for the single endpoints.
+func jobArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	format, ok := reportFormat(w, r)
+	if !ok {
+		return
+	}
+	clock, ok := clockParam(w, r)
+	if !ok {
+		return
+	}
+	job, ok := jobs.get(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if job.Status != JobDone {
+		w.Header().Set("Cache-Control", "no-store")
+		http.Error(w, "Job "+job.Status, http.StatusConflict)
+		return
+	}
+	if job.Result == nil && len(job.Batch) == 0 {
+		http.Error(w, "Job has no replay results", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+job.ID+`.zip"`)
+	if err := writeArchive(w, job, clock, format); err != nil {
+		log.Printf("Error writing archive: %v", err)
+	}
+}
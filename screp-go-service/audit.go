@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// AuditEntry records an API action: who did what, when and from where.
+type AuditEntry struct {
+	Time         time.Time `json:"time"`
+	Actor        string    `json:"actor"`           // "admin", "key:<id>" or "anonymous"
+	Owner        string    `json:"owner,omitempty"` // X-Owner-ID, as claimed by the client
+	Action       string    `json:"action"`          // Method and route, e.g. "DELETE /jobs/{id}"
+	Path         string    `json:"path"`
+	Resource     string    `json:"resource,omitempty"` // ID of the job, share or replay acted upon
+	Status       int       `json:"status"`
+	RemoteAddr   string    `json:"remoteAddr"`
+	ForwardedFor string    `json:"forwardedFor,omitempty"`
+}
+
+// Limits of the audit log
+const (
+	auditMemoryEntries = 10000 // Kept in memory without AUDIT_LOG_FILE
+	defaultAuditLimit  = 100
+	maxAuditLimit      = 1000
+)
+
+// auditLog is an append-only log of API actions: a JSON lines file if
+// AUDIT_LOG_FILE is set, or else the most recent entries in memory.
+type auditLog struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	memory []AuditEntry
+}
+
+var audits = newAuditLog(os.Getenv("AUDIT_LOG_FILE"))
+
+func newAuditLog(path string) *auditLog {
+	a := &auditLog{path: path}
+	if path == "" {
+		return a
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		log.Fatalf("Error opening audit log: %v", err)
+	}
+	a.file = f
+	return a
+}
+
+func (a *auditLog) append(e AuditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		if len(a.memory) == auditMemoryEntries {
+			a.memory = append(a.memory[:0], a.memory[1:]...)
+		}
+		a.memory = append(a.memory, e)
+		return
+	}
+	data, _ := json.Marshal(e)
+	if _, err := a.file.Write(append(data, '\n')); err != nil {
+		log.Printf("Error writing audit log: %v", err)
+	}
+}
+
+// auditQuery filters the audit log; zero fields match everything.
+type auditQuery struct {
+	actor, owner, action, resource string
+	since, until                   time.Time
+	limit                          int
+}
+
+func (q *auditQuery) match(e *AuditEntry) bool {
+	return (q.actor == "" || e.Actor == q.actor) &&
+		(q.owner == "" || e.Owner == q.owner) &&
+		(q.action == "" || strings.Contains(e.Action, q.action)) &&
+		(q.resource == "" || e.Resource == q.resource) &&
+		(q.since.IsZero() || !e.Time.Before(q.since)) &&
+		(q.until.IsZero() || e.Time.Before(q.until))
+}
+
+// query returns the most recent matching entries, newest first.
+func (a *auditLog) query(q auditQuery) ([]AuditEntry, error) {
+	var all []AuditEntry
+	add := func(e AuditEntry) {
+		if !q.match(&e) {
+			return
+		}
+		all = append(all, e)
+		if len(all) > 2*q.limit { // Only the last limit ones are needed
+			all = append(all[:0], all[len(all)-q.limit:]...)
+		}
+	}
+
+	if a.path == "" {
+		a.mu.Lock()
+		for _, e := range a.memory {
+			add(e)
+		}
+		a.mu.Unlock()
+	} else {
+		f, err := os.Open(a.path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		sc.Buffer(nil, 1<<20)
+		for sc.Scan() {
+			var e AuditEntry
+			if err := json.Unmarshal(sc.Bytes(), &e); err == nil {
+				add(e)
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(all) > q.limit {
+		all = all[len(all)-q.limit:]
+	}
+	res := make([]AuditEntry, len(all))
+	for i, e := range all {
+		res[len(all)-1-i] = e
+	}
+	return res, nil
+}
+
+// requestActor identifies who made the request.
+func requestActor(r *http.Request) string {
+	if isAdmin(r) {
+		return "admin"
+	}
+	if secret := requestSecret(r); secret != "" {
+		if id, ok := apiKeys.lookup(secret); ok {
+			return "key:" + id
+		}
+		return "invalid-key"
+	}
+	return "anonymous"
+}
+
+type auditKey struct{}
+
+// setAuditResource records the resource a request acted upon, when it's not
+// in the path, e.g. the ID of a created job.
+func setAuditResource(r *http.Request, resource string) {
+	if e, ok := r.Context().Value(auditKey{}).(*AuditEntry); ok {
+		e.Resource = resource
+	}
+}
+
+// auditMiddleware records the actions: requests other than reads, and
+// downloads of stored replays.
+func auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tmpl, _ := mux.CurrentRoute(r).GetPathTemplate()
+		if (r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions) && tmpl != "/replays/{hash}" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		e := &AuditEntry{
+			Time:         time.Now().UTC(),
+			Actor:        requestActor(r),
+			Owner:        r.Header.Get(ownerHeader),
+			Action:       r.Method + " " + tmpl,
+			Path:         r.URL.Path,
+			RemoteAddr:   r.RemoteAddr,
+			ForwardedFor: r.Header.Get("X-Forwarded-For"),
+		}
+		vars := mux.Vars(r)
+		for _, v := range []string{"id", "hash", "owner"} {
+			if vars[v] != "" {
+				e.Resource = vars[v]
+			}
+		}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), auditKey{}, e)))
+		e.Status = sw.status
+		audits.append(*e)
+	})
+}
+
+// statusWriter records the status code of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if !sw.wroteHeader {
+		sw.status, sw.wroteHeader = status, true
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+// Flush implements http.Flusher for streamed responses.
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// auditHandler queries the audit log: actor, owner, action (substring),
+// resource, since and until (RFC 3339) and limit.
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	qv := r.URL.Query()
+	q := auditQuery{actor: qv.Get("actor"), owner: qv.Get("owner"), action: qv.Get("action"), resource: qv.Get("resource"), limit: defaultAuditLimit}
+	for name, t := range map[string]*time.Time{"since": &q.since, "until": &q.until} {
+		if v := qv.Get(name); v != "" {
+			var err error
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(w, "Invalid "+name+", must be RFC 3339", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if v := qv.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxAuditLimit {
+			http.Error(w, "Invalid limit, must be 1 to "+strconv.Itoa(maxAuditLimit), http.StatusBadRequest)
+			return
+		}
+		q.limit = n
+	}
+
+	entries, err := audits.query(q)
+	if err != nil {
+		log.Printf("Error reading audit log: %v", err)
+		http.Error(w, "Failed to read audit log", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, entries)
+}
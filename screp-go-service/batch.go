@@ -0,0 +1,220 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxBatchReplays caps the number of replays in one batch.
+const maxBatchReplays = 500
+
+// maxBatchSize caps the total size of the replays of a batch, uncompressed,
+// which are held in memory until parsed.
+const maxBatchSize = 256 << 20
+
+// parseSlots limits the number of replays parsed concurrently across all
+// requests. Size is PARSE_CONCURRENCY, default the number of CPUs.
+var parseSlots = make(chan struct{}, parseConcurrency())
+
+func parseConcurrency() int {
+	if n, err := strconv.Atoi(os.Getenv("PARSE_CONCURRENCY")); err == nil && n > 0 {
+		return n
+	}
+	return runtime.NumCPU()
+}
+
+type BatchItem struct {
+	Name       string        `json:"name"`
+	ReplayHash string        `json:"replayHash,omitempty"`
+	Result     *ReplayResult `json:"result,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Warnings   []string      `json:"warnings,omitempty"` // Degraded dependencies, e.g. replay_not_stored
+}
+
+type batchFile struct {
+	name string
+	data []byte
+}
+
+// batchCollector returns a function adding replays to files, up to
+// maxBatchReplays and maxBatchSize.
+func batchCollector(files *[]batchFile) func(name string, r io.Reader) error {
+	total := 0
+	return func(name string, r io.Reader) error {
+		if len(*files) == maxBatchReplays {
+			return fmt.Errorf("too many replays, max %d", maxBatchReplays)
+		}
+		data, err := io.ReadAll(io.LimitReader(r, maxReplaySize+1))
+		if err != nil {
+			return err
+		}
+		if len(data) > maxReplaySize {
+			return fmt.Errorf("%s: replay too large", name)
+		}
+		if total += len(data); total > maxBatchSize {
+			return fmt.Errorf("replays too large, max %d MiB in total", maxBatchSize>>20)
+		}
+		*files = append(*files, batchFile{name: name, data: data})
+		return nil
+	}
+}
+
+// readBatchFiles collects the replays of a batch upload: every "replay" file
+// and the .rep entries of every "archive" zip file.
+func readBatchFiles(form *multipart.Form) ([]batchFile, error) {
+	var files []batchFile
+	add := batchCollector(&files)
+
+	for _, fh := range form.File["replay"] {
+		f, err := fh.Open()
+		if err != nil {
+			return nil, err
+		}
+		err = add(fh.Filename, f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, fh := range form.File["archive"] {
+		f, err := fh.Open()
+		if err != nil {
+			return nil, err
+		}
+		err = readArchive(f, fh.Size, fh.Filename, add)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// readArchive calls add with the .rep entries of a zip archive.
+func readArchive(ra io.ReaderAt, size int64, name string, add func(name string, r io.Reader) error) error {
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() || !strings.EqualFold(path.Ext(zf.Name), ".rep") {
+			continue
+		}
+		// Checked before inflating, the reader stops at the real size
+		if zf.UncompressedSize64 > maxReplaySize {
+			return fmt.Errorf("%s: replay too large", zf.Name)
+		}
+		rc, err := zf.Open()
+		if err == nil {
+			err = add(zf.Name, rc)
+			rc.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseBatch parses and stores the files on a worker pool.
+func parseBatch(files []batchFile) []BatchItem {
+	items := make([]BatchItem, len(files))
+	runPool(len(files), func(i int) {
+		items[i].Name = files[i].name
+		hash, warning := storeReplay(files[i].data)
+		items[i].ReplayHash = hash
+		if warning != "" {
+			items[i].Warnings = []string{warning}
+		}
+		res, err := parseReplay(bytes.NewReader(files[i].data))
+		if err != nil {
+			items[i].Error = err.Error()
+		} else {
+			items[i].Result = res
+		}
+	})
+	return items
+}
+
+// runPool calls fn for 0..n-1 on a worker pool. Parsing itself is bounded by
+// parseSlots, so the pool never exceeds the global concurrency limit.
+func runPool(n int, fn func(i int)) {
+	workers := cap(parseSlots)
+	if workers > n {
+		workers = n
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
+
+// parseBatchHandler parses all replays of a multipart upload ("replay" files
+// and/or "archive" zip files). With ?async=true it responds 202 right away and
+// the results are available via GET /jobs/{id}.
+func parseBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(64 << 20); err != nil {
+		http.Error(w, "Invalid multipart form", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	clock, ok := clockParam(w, r)
+	if !ok {
+		return
+	}
+	files, err := readBatchFiles(r.MultipartForm)
+	if err != nil {
+		http.Error(w, "Invalid batch: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(files) == 0 {
+		http.Error(w, "Missing replay files", http.StatusBadRequest)
+		return
+	}
+
+	job := jobs.create(JobProcessing, r.Header.Get(ownerHeader))
+	setAuditResource(r, job.ID)
+	run := func() Job {
+		items := parseBatch(files)
+		job, ok := jobs.update(job.ID, func(j *Job) bool {
+			j.Status, j.Batch = JobDone, items
+			return true
+		})
+		if !ok {
+			releaseReplays([]*Job{{Batch: items}}) // Deleted meanwhile
+		}
+		return job
+	}
+
+	if async, _ := strconv.ParseBool(r.URL.Query().Get("async")); async {
+		go run()
+		writeJSONStatus(w, http.StatusAccepted, job)
+		return
+	}
+	writeJSON(w, run().inClock(clock))
+}
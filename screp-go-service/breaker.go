@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned instead of calling a dependency that failed
+// repeatedly, until its cooldown has passed.
+var errCircuitOpen = errors.New("circuit open")
+
+// breakerThreshold is how many consecutive failures open a circuit:
+// BREAKER_THRESHOLD, default 5.
+var breakerThreshold = breakerEnvInt("BREAKER_THRESHOLD", 5)
+
+// breakerCooldown is how long an open circuit fails fast before a single
+// trial call is let through: BREAKER_COOLDOWN seconds, default 30.
+var breakerCooldown = time.Duration(breakerEnvInt("BREAKER_COOLDOWN", 30)) * time.Second
+
+func breakerEnvInt(name string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(name)); err == nil && n > 0 {
+		return n
+	}
+	return def
+}
+
+// Circuit states
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// circuitBreaker stops calling a failing dependency for a while, so requests
+// using it fail fast or skip it instead of each waiting for a timeout.
+type circuitBreaker struct {
+	name      string
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool // A half-open trial call is in progress
+	lastError string
+}
+
+// breakers are all circuit breakers, reported by the health check.
+var (
+	breakersMu sync.Mutex
+	breakers   []*circuitBreaker
+)
+
+func newCircuitBreaker(name string) *circuitBreaker {
+	b := &circuitBreaker{name: name}
+	breakersMu.Lock()
+	breakers = append(breakers, b)
+	breakersMu.Unlock()
+	return b
+}
+
+// call calls fn unless the circuit is open. Context cancellation by the
+// caller doesn't count as a failure of the dependency.
+func (b *circuitBreaker) call(ctx context.Context, fn func() error) error {
+	if b == nil {
+		return fn()
+	}
+	b.mu.Lock()
+	if b.failures >= breakerThreshold {
+		if b.trial || time.Now().Before(b.openUntil) {
+			b.mu.Unlock()
+			return errCircuitOpen
+		}
+		b.trial = true
+	}
+	b.mu.Unlock()
+
+	err := fn()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	switch {
+	case err == nil || errors.Is(err, os.ErrNotExist):
+		b.failures = 0
+	case ctx.Err() == context.Canceled:
+	default:
+		b.failures++
+		b.lastError = err.Error()
+		if b.failures >= breakerThreshold {
+			b.openUntil = time.Now().Add(breakerCooldown)
+		}
+	}
+	return err
+}
+
+// CircuitStatus is the state of a circuit breaker.
+type CircuitStatus struct {
+	Name      string     `json:"name"`
+	State     string     `json:"state"`
+	Failures  int        `json:"failures"`
+	LastError string     `json:"lastError,omitempty"`
+	RetryAt   *time.Time `json:"retryAt,omitempty"` // When an open circuit lets a trial call through
+}
+
+func (b *circuitBreaker) status() CircuitStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := CircuitStatus{Name: b.name, State: CircuitClosed, Failures: b.failures, LastError: b.lastError}
+	if b.failures >= breakerThreshold {
+		st.State = CircuitHalfOpen
+		if until := b.openUntil.UTC(); time.Now().Before(until) {
+			st.State, st.RetryAt = CircuitOpen, &until
+		}
+	}
+	return st
+}
+
+// isOpen tells if calls currently fail fast.
+func (b *circuitBreaker) isOpen() bool {
+	return b.status().State == CircuitOpen
+}
+
+// unavailable responds 503 for a dependency whose circuit is open, with a
+// Retry-After of the cooldown.
+func unavailable(w http.ResponseWriter, msg string) {
+	w.Header().Del("ETag")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", strconv.Itoa(int(breakerCooldown.Seconds())))
+	http.Error(w, msg, http.StatusServiceUnavailable)
+}
+
+// circuitStatuses returns the states of all circuit breakers.
+func circuitStatuses() []CircuitStatus {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	sts := make([]CircuitStatus, len(breakers))
+	for i, b := range breakers {
+		sts[i] = b.status()
+	}
+	return sts
+}
+
+// breakerBackend guards a blob backend with a circuit breaker.
+type breakerBackend struct {
+	blobBackend
+	breaker *circuitBreaker
+}
+
+func (b breakerBackend) put(ctx context.Context, key string, data []byte) error {
+	return b.breaker.call(ctx, func() error { return b.blobBackend.put(ctx, key, data) })
+}
+
+func (b breakerBackend) get(ctx context.Context, key string) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	err := b.breaker.call(ctx, func() error {
+		var err error
+		rc, err = b.blobBackend.get(ctx, key)
+		return err
+	})
+	return rc, err
+}
+
+func (b breakerBackend) delete(ctx context.Context, key string) error {
+	return b.breaker.call(ctx, func() error { return b.blobBackend.delete(ctx, key) })
+}
+
+func (b breakerBackend) list(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := b.breaker.call(ctx, func() error {
+		var err error
+		keys, err = b.blobBackend.list(ctx, prefix)
+		return err
+	})
+	return keys, err
+}
+
+// DependencyHealth reports the circuit breakers of the service's
+// dependencies. Status is "degraded" while any circuit isn't closed.
+type DependencyHealth struct {
+	Status   string          `json:"status"`
+	Circuits []CircuitStatus `json:"circuits"`
+}
+
+// dependencyHealthHandler reports the state of the dependencies. It responds
+// 200 even when degraded, since parsing works without them.
+func dependencyHealthHandler(w http.ResponseWriter, r *http.Request) {
+	h := DependencyHealth{Status: "ok", Circuits: circuitStatuses()}
+	for _, c := range h.Circuits {
+		if c.State != CircuitClosed {
+			h.Status = "degraded"
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, h)
+}
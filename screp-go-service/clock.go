@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Clock conventions of the times in parse results, selected with the clock
+// query parameter.
+const (
+	// ClockReal is real seconds of a game played on "Fastest", as shown by
+	// the Remastered in-game timer. The default.
+	ClockReal = "real"
+	// ClockGame is game seconds, as shown by the in-game timer before
+	// Remastered, which counts seconds of "Normal" speed (67 ms per frame
+	// instead of 42 ms), about 1.6 times the real time.
+	ClockGame = "game"
+)
+
+// gameClockScale converts real seconds to game seconds.
+const gameClockScale = 0.067 * framesPerSecond
+
+// clockParam returns the clock selected by the request, ClockReal if none.
+// If it's invalid, it responds with an error and returns false.
+func clockParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	switch c := r.URL.Query().Get("clock"); c {
+	case "", ClockReal:
+		return ClockReal, true
+	case ClockGame:
+		return c, true
+	}
+	http.Error(w, "Invalid clock, must be real or game", http.StatusBadRequest)
+	return "", false
+}
+
+// clockRoutes are the routes taking the clock parameter. The others respond
+// in real seconds only.
+var clockRoutes = map[string]bool{
+	"/parse":             true,
+	"/parse/batch":       true,
+	"/fetch":             true,
+	"/jobs/{id}":         true,
+	"/jobs/{id}/archive": true,
+	"/seek/{id}":         true,
+	"/seek/{id}/actions": true,
+	"/s/{id}":            true,
+}
+
+// clockMiddleware rejects other clocks than ClockReal on the routes not
+// taking the clock parameter, rather than answering in real seconds to a
+// request for game seconds.
+func clockMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c := r.URL.Query().Get("clock"); c != "" && c != ClockReal {
+			if tmpl, _ := mux.CurrentRoute(r).GetPathTemplate(); !clockRoutes[tmpl] {
+				http.Error(w, "Clock not supported by this endpoint, times are real seconds", http.StatusBadRequest)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Times in descriptions: clock times as formatted by formatClock and
+// durations in whole seconds, e.g. "30 s".
+var (
+	clockTextRe   = regexp.MustCompile(`\b(\d+):([0-5]\d)\b`)
+	secondsTextRe = regexp.MustCompile(`\b(\d+) s\b`)
+)
+
+// scaleText multiplies the times in a description by k.
+func scaleText(s string, k float64) string {
+	s = clockTextRe.ReplaceAllStringFunc(s, func(m string) string {
+		var min, sec int
+		fmt.Sscanf(m, "%d:%d", &min, &sec)
+		secs := int(float64(min*60+sec) * k)
+		return fmt.Sprintf("%d:%02d", secs/60, secs%60)
+	})
+	return secondsTextRe.ReplaceAllStringFunc(s, func(m string) string {
+		n, _ := strconv.Atoi(strings.TrimSuffix(m, " s"))
+		return strconv.Itoa(int(math.Round(float64(n)*k))) + " s"
+	})
+}
+
+// inClock returns the result with its times in the clock: res itself for
+// ClockReal, the clock of all stored results, a converted copy otherwise.
+// Times and durations in seconds are converted, also those in descriptions;
+// frames, per-minute rates such as APM and the per-minute positioning curve
+// stay as they are.
+func (res *ReplayResult) inClock(clock string) *ReplayResult {
+	if res == nil || clock != ClockGame {
+		return res
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		return res
+	}
+	out := &ReplayResult{}
+	if err := json.Unmarshal(data, out); err != nil {
+		return res
+	}
+	out.Clock = clock
+	out.scaleTimes(gameClockScale)
+	return out
+}
+
+// inClock returns the job with its result and batch results in the clock.
+func (j Job) inClock(clock string) Job {
+	j.Result = j.Result.inClock(clock)
+	if j.Batch != nil {
+		batch := make([]BatchItem, len(j.Batch))
+		for i, item := range j.Batch {
+			item.Result = item.Result.inClock(clock)
+			batch[i] = item
+		}
+		j.Batch = batch
+	}
+	return j
+}
+
+// scaleTimes multiplies all times and durations of the result by k.
+func (res *ReplayResult) scaleTimes(k float64) {
+	s := func(v *float64) { *v = round(*v*k, 2) }
+	sp := func(v *float64) {
+		if v != nil {
+			s(v)
+		}
+	}
+
+	res.DurationSeconds = float32(round(float64(res.DurationSeconds)*k, 2))
+	for i := range res.Actions {
+		s(&res.Actions[i].Time)
+	}
+	for i := range res.BuildOrders {
+		for j := range res.BuildOrders[i].Sequence {
+			s(&res.BuildOrders[i].Sequence[j].Time)
+		}
+	}
+	for i := range res.Anomalies {
+		a := &res.Anomalies[i]
+		a.Description = scaleText(a.Description, k)
+	}
+	for i := range res.Suspicions {
+		sus := &res.Suspicions[i]
+		s(&sus.Time)
+		sus.Reason = scaleText(sus.Reason, k)
+	}
+	for i := range res.Events {
+		e := &res.Events[i]
+		s(&e.Time)
+		e.Description = scaleText(e.Description, k)
+	}
+	for i := range res.Highlights {
+		s(&res.Highlights[i].Time)
+	}
+	for i := range res.Engagements {
+		e := &res.Engagements[i]
+		e.Name = scaleText(e.Name, k)
+		s(&e.Time)
+		s(&e.EndTime)
+		s(&e.DurationSeconds)
+		for j := range e.Paths {
+			for k := range e.Paths[j].Waypoints {
+				s(&e.Paths[j].Waypoints[k].Time)
+			}
+		}
+	}
+
+	for i := range res.Players {
+		p := &res.Players[i]
+		for j := range p.Production {
+			f := &p.Production[j]
+			s(&f.BusySeconds)
+			s(&f.AvailableSeconds)
+			for k := range f.Timeline {
+				s(&f.Timeline[k].Time)
+			}
+		}
+		for j := range p.WorkerPulls {
+			w := &p.WorkerPulls[j]
+			s(&w.Time)
+			s(&w.EndTime)
+			s(&w.DurationSeconds)
+		}
+		s(&p.StaticDefense.FirstTime)
+		for j := range p.StaticDefense.Structures {
+			s(&p.StaticDefense.Structures[j].Time)
+		}
+		for j := range p.Upgrades {
+			u := &p.Upgrades[j]
+			s(&u.ExpectedTime)
+			sp(u.ActualTime)
+			sp(u.DeltaSeconds)
+		}
+		for j := range p.Spells.Casts {
+			s(&p.Spells.Casts[j].Time)
+		}
+		m := &p.Milestones
+		for _, v := range []*float64{m.FirstGas, m.FirstExpansion, m.FirstTechBuilding, m.FirstArmyUnit, m.FirstUpgrade} {
+			sp(v)
+		}
+		sp(p.Scouting.AvgLatency)
+		for j := range p.Scouting.Scouts {
+			sc := &p.Scouting.Scouts[j]
+			s(&sc.Time)
+			sp(sc.ReactionTime)
+			sp(sc.Latency)
+		}
+		if t := p.RaceStats.Terran; t != nil {
+			sp(t.FirstComsat)
+			for j := range t.Scans {
+				s(&t.Scans[j].Time)
+			}
+			for j := range t.SupplyDepots {
+				s(&t.SupplyDepots[j])
+			}
+		}
+		if pr := p.RaceStats.Protoss; pr != nil {
+			for j := range pr.Pylons {
+				s(&pr.Pylons[j])
+			}
+			for j := range pr.ProbeCuts {
+				c := &pr.ProbeCuts[j]
+				s(&c.Time)
+				s(&c.EndTime)
+				s(&c.DurationSeconds)
+			}
+			s(&pr.ProbeCutSeconds)
+		}
+		if z := p.RaceStats.Zerg; z != nil {
+			for j := range z.Overlords {
+				s(&z.Overlords[j])
+			}
+			for j := range z.CreepSources {
+				s(&z.CreepSources[j].Time)
+			}
+		}
+	}
+}
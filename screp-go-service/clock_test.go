@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestScaleText(t *testing.T) {
+	tests := []struct {
+		in   string
+		k    float64
+		want string
+	}{
+		{"Nuke at 2:15", 2, "Nuke at 4:30"},
+		{"Gap of 30 s", 1.5, "Gap of 45 s"},
+		{"0:59 to 1:00", 2, "1:58 to 2:00"},
+		{"5 commands at 2:15 within 10 s", 2, "5 commands at 4:30 within 20 s"},
+		{"Score 12:345, 3 scouts", 2, "Score 12:345, 3 scouts"}, // No clock or duration
+		{"Attack at 1:05", 1, "Attack at 1:05"},
+	}
+	for _, tt := range tests {
+		if got := scaleText(tt.in, tt.k); got != tt.want {
+			t.Errorf("scaleText(%q, %v) = %q, want %q", tt.in, tt.k, got, tt.want)
+		}
+	}
+}
+
+func TestClockParam(t *testing.T) {
+	for clock, want := range map[string]bool{"": true, ClockReal: true, ClockGame: true, "fast": false} {
+		rec := httptest.NewRecorder()
+		_, ok := clockParam(rec, httptest.NewRequest(http.MethodPost, "/parse?clock="+clock, nil))
+		if ok != want {
+			t.Errorf("clock %q: got %v, want %v", clock, ok, want)
+		}
+	}
+}
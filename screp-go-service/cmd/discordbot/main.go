@@ -0,0 +1,282 @@
+// Command discordbot is a Discord bot that analyzes replays posted to Discord.
+//
+// It serves the Discord interactions endpoint (HTTP, no gateway connection)
+// and handles two commands:
+//
+//   - the slash command /analyze with a replay attachment option
+//   - the message context menu command "Analyze replay" on a message with a .rep attachment
+//
+// Replays are parsed by the replay parser service and answered with a summary embed.
+//
+// Environment: DISCORD_PUBLIC_KEY, DISCORD_APPLICATION_ID, PARSER_URL, PORT.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MachMarketing/replay-mastery-forge/screp-go-service/pkg/client"
+)
+
+// Discord interaction and response types
+const (
+	interactionPing               = 1
+	interactionApplicationCommand = 2
+
+	responsePong                     = 1
+	responseDeferredChannelMessage   = 5
+	responseChannelMessageWithSource = 4
+)
+
+const discordAPI = "https://discord.com/api/v10"
+
+// maxReplaySize caps downloaded attachments.
+const maxReplaySize = 32 << 20
+
+type attachment struct {
+	Filename string `json:"filename"`
+	URL      string `json:"url"`
+	Size     int64  `json:"size"`
+}
+
+type interaction struct {
+	Type  int    `json:"type"`
+	Token string `json:"token"`
+	Data  struct {
+		Name     string `json:"name"`
+		TargetID string `json:"target_id"`
+		Options  []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"options"`
+		Resolved struct {
+			Attachments map[string]attachment `json:"attachments"`
+			Messages    map[string]struct {
+				Attachments []attachment `json:"attachments"`
+			} `json:"messages"`
+		} `json:"resolved"`
+	} `json:"data"`
+}
+
+type embedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+type embed struct {
+	Title       string       `json:"title"`
+	Description string       `json:"description,omitempty"`
+	Color       int          `json:"color,omitempty"`
+	Fields      []embedField `json:"fields,omitempty"`
+}
+
+type bot struct {
+	publicKey ed25519.PublicKey
+	appID     string
+	parser    *client.Client
+}
+
+func main() {
+	key, err := hex.DecodeString(os.Getenv("DISCORD_PUBLIC_KEY"))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		log.Fatal("DISCORD_PUBLIC_KEY must be set to the application's hex public key")
+	}
+	parserURL := os.Getenv("PARSER_URL")
+	if parserURL == "" {
+		parserURL = "http://localhost:8080"
+	}
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8081"
+	}
+
+	parser := client.New(parserURL)
+	parser.APIKey = os.Getenv("PARSER_API_KEY")
+
+	b := &bot{
+		publicKey: key,
+		appID:     os.Getenv("DISCORD_APPLICATION_ID"),
+		parser:    parser,
+	}
+
+	http.HandleFunc("/interactions", b.interactionsHandler)
+	log.Printf("Discord bot starting on port %s", port)
+	log.Fatal(http.ListenAndServe(":"+port, nil))
+}
+
+func (b *bot) interactionsHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	// Discord requires verifying the signature of every interaction
+	sig, err := hex.DecodeString(r.Header.Get("X-Signature-Ed25519"))
+	msg := append([]byte(r.Header.Get("X-Signature-Timestamp")), body...)
+	if err != nil || !ed25519.Verify(b.publicKey, msg, sig) {
+		http.Error(w, "Invalid request signature", http.StatusUnauthorized)
+		return
+	}
+
+	var in interaction
+	if err := json.Unmarshal(body, &in); err != nil {
+		http.Error(w, "Invalid interaction", http.StatusBadRequest)
+		return
+	}
+
+	switch in.Type {
+	case interactionPing:
+		respond(w, map[string]interface{}{"type": responsePong})
+	case interactionApplicationCommand:
+		att, ok := replayAttachment(&in)
+		if !ok {
+			respond(w, map[string]interface{}{
+				"type": responseChannelMessageWithSource,
+				"data": map[string]interface{}{"content": "Please attach a `.rep` replay file.", "flags": 64},
+			})
+			return
+		}
+		// Parsing may take longer than the 3 seconds Discord waits for a response
+		respond(w, map[string]interface{}{"type": responseDeferredChannelMessage})
+		go b.analyze(in.Token, att)
+	default:
+		http.Error(w, "Unsupported interaction type", http.StatusBadRequest)
+	}
+}
+
+// replayAttachment returns the replay attached to the command.
+func replayAttachment(in *interaction) (attachment, bool) {
+	var candidates []attachment
+	for _, opt := range in.Data.Options {
+		if a, ok := in.Data.Resolved.Attachments[opt.Value]; ok {
+			candidates = append(candidates, a)
+		}
+	}
+	if m, ok := in.Data.Resolved.Messages[in.Data.TargetID]; ok {
+		candidates = append(candidates, m.Attachments...)
+	}
+	for _, a := range candidates {
+		if strings.HasSuffix(strings.ToLower(a.Filename), ".rep") && a.Size <= maxReplaySize {
+			return a, true
+		}
+	}
+	return attachment{}, false
+}
+
+func respond(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// analyze parses the attached replay and edits the deferred response.
+func (b *bot) analyze(token string, att attachment) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	msg := map[string]interface{}{}
+	res, err := b.parseAttachment(ctx, att)
+	if err != nil {
+		log.Printf("Error analyzing %s: %v", att.Filename, err)
+		msg["content"] = "Failed to analyze `" + att.Filename + "`: " + err.Error()
+	} else {
+		msg["embeds"] = []embed{summaryEmbed(res)}
+	}
+
+	body, _ := json.Marshal(msg)
+	u := fmt.Sprintf("%s/webhooks/%s/%s/messages/@original", discordAPI, b.appID, token)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, u, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Error creating follow-up: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("Error sending follow-up: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Discord rejected follow-up: %s", resp.Status)
+	}
+}
+
+func (b *bot) parseAttachment(ctx context.Context, att attachment) (*client.ReplayResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, att.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("attachment download: %s", resp.Status)
+	}
+	return b.parser.Parse(ctx, att.Filename, io.LimitReader(resp.Body, maxReplaySize))
+}
+
+// buildSteps is the number of build order steps shown per player.
+const buildSteps = 8
+
+func summaryEmbed(res *client.ReplayResult) embed {
+	names := make([]string, len(res.Players))
+	races := make([]string, len(res.Players))
+	for i, p := range res.Players {
+		names[i] = p.Name
+		if p.Race != "" {
+			races[i] = p.Race[:1]
+		}
+	}
+
+	mins, secs := int(res.DurationSeconds)/60, int(res.DurationSeconds)%60
+	e := embed{
+		Title:       strings.Join(names, " vs "),
+		Description: fmt.Sprintf("**%s** on %s, %d:%02d", strings.Join(races, "v"), res.MapName, mins, secs),
+		Color:       0x1f8b4c,
+	}
+
+	for _, p := range res.Players {
+		title := p.Name
+		if res.WinnerTeam != 0 && p.Team == res.WinnerTeam {
+			title += " 🏆"
+		}
+		e.Fields = append(e.Fields, embedField{
+			Name:   title,
+			Value:  fmt.Sprintf("%s\nAPM %d / EAPM %d", p.Race, p.APM, p.EAPM),
+			Inline: true,
+		})
+	}
+
+	for _, bo := range res.BuildOrders {
+		if len(bo.Sequence) == 0 || bo.PlayerID >= len(res.Players) {
+			continue
+		}
+		var steps []string
+		for i, cmd := range bo.Sequence {
+			if i == buildSteps {
+				break
+			}
+			t := int(cmd.Time)
+			steps = append(steps, fmt.Sprintf("`%d:%02d` %s", t/60, t%60, cmd.Target))
+		}
+		e.Fields = append(e.Fields, embedField{
+			Name:  res.Players[bo.PlayerID].Name + " build",
+			Value: strings.Join(steps, "\n"),
+		})
+	}
+	return e
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
+)
+
+// datasetVersion is bumped with changes of the dataset layout (the label
+// and metadata columns); feature changes bump featureVersion.
+const datasetVersion = "v1"
+
+// maxDatasetJobs caps the jobs of one dataset export.
+const maxDatasetJobs = 1000
+
+// Label confidences. The winner is inferred by screp as the largest team
+// remaining when the replay ended, which is reliable in 1v1s but may be
+// wrong in team games where players of the winning team left early.
+const (
+	LabelHigh    = "high"
+	LabelMedium  = "medium"
+	LabelUnknown = "unknown"
+)
+
+// DatasetRequest selects the finished jobs exported, like OpeningsRequest.
+type DatasetRequest struct {
+	JobIDs []string `json:"jobIds"`
+	Format string   `json:"format,omitempty"` // csv (default) or parquet
+}
+
+// DatasetColumn describes a column of the dataset.
+type DatasetColumn struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"` // string, int, double or boolean
+	Description string `json:"description"`
+}
+
+// DatasetManifest is manifest.json of a dataset export.
+type DatasetManifest struct {
+	Version        string            `json:"version"`
+	FeatureVersion string            `json:"featureVersion"`
+	Analyzer       map[string]string `json:"analyzer"` // Module versions
+	CreatedAt      time.Time         `json:"createdAt"`
+	Format         string            `json:"format"`
+	File           string            `json:"file"`
+	Rows           int               `json:"rows"`
+	Replays        int               `json:"replays"`
+	Columns        []DatasetColumn   `json:"columns"`
+	Labels         DatasetLabels     `json:"labels"`
+	MissingJobs    []string          `json:"missingJobs,omitempty"` // Unknown, expired or unfinished jobs
+}
+
+// DatasetLabels describes how the labels were inferred and how many rows
+// have which confidence.
+type DatasetLabels struct {
+	Method     string         `json:"method"`
+	Confidence map[string]int `json:"confidence"` // Rows by label confidence
+}
+
+// datasetRow is one player of one replay.
+type datasetRow struct {
+	jobID, replay    string
+	row              FeatureRow
+	race, confidence string
+	mapName, mapHash string
+}
+
+// labelConfidence rates the inferred winner of the result.
+func labelConfidence(res *ReplayResult) string {
+	if res.WinnerTeam == 0 {
+		return LabelUnknown
+	}
+	teams := map[int]int{}
+	for _, p := range res.Players {
+		teams[p.Team]++
+	}
+	if len(teams) == 2 && len(res.Players) == 2 {
+		return LabelHigh
+	}
+	return LabelMedium
+}
+
+// datasetColumns are the columns before the features.
+var datasetColumns = []DatasetColumn{
+	{"job_id", "string", "Job the replay was parsed in"},
+	{"replay", "string", "File name in the batch, empty for single replays"},
+	{"player_id", "int", "Player ID in the replay"},
+	{"player", "string", "Player name"},
+	{"race", "string", "Terran, Protoss or Zerg"},
+	{"matchup", "string", "Matchup against the first opponent, e.g. TvZ"},
+	{"map", "string", "Canonical map name"},
+	{"map_hash", "string", "Content hash of the map, stable across renames and versions of the file"},
+	{"won", "boolean", "Label: the player's team won; null if the winner is unknown"},
+	{"label_confidence", "string", "Confidence of the won label: high, medium or unknown"},
+}
+
+// analyzerVersions returns the versions of the service and the replay
+// parser, as far as the build info tells.
+func analyzerVersions() map[string]string {
+	versions := map[string]string{"schema": schemaVersion}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return versions
+	}
+	versions["service"] = info.Main.Version
+	for _, dep := range info.Deps {
+		if dep.Path == "github.com/icza/screp" {
+			versions["screp"] = dep.Version
+		}
+	}
+	return versions
+}
+
+// replayDatasetRows returns the rows of the players of one replay.
+func replayDatasetRows(jobID, name string, res *ReplayResult) []datasetRow {
+	var rows []datasetRow
+	conf := labelConfidence(res)
+	for i, fr := range featureRows(name, res) {
+		rows = append(rows, datasetRow{jobID: jobID, replay: name, row: fr,
+			race: res.Players[i].Race, confidence: conf, mapName: res.CanonicalMapName, mapHash: res.MapHash})
+	}
+	return rows
+}
+
+// datasetRows collects the rows of the jobs' replays.
+func datasetRows(ids []string) (rows []datasetRow, replays int, missing []string) {
+	add := func(jobID, name string, res *ReplayResult) {
+		replays++
+		rows = append(rows, replayDatasetRows(jobID, name, res)...)
+	}
+	for _, id := range ids {
+		job, ok := jobs.get(id)
+		if !ok || job.Status != JobDone {
+			missing = append(missing, id)
+			continue
+		}
+		if job.Result != nil {
+			add(job.ID, "", job.Result)
+		}
+		for _, item := range job.Batch {
+			if item.Result != nil {
+				add(job.ID, item.Name, item.Result)
+			}
+		}
+	}
+	return rows, replays, missing
+}
+
+func (r datasetRow) strings() []string {
+	won := ""
+	if r.row.Won != nil {
+		won = strconv.FormatBool(*r.row.Won)
+	}
+	return []string{r.jobID, r.replay, strconv.Itoa(r.row.PlayerID), r.row.Player, r.race, r.row.Matchup, r.mapName, r.mapHash, won, r.confidence}
+}
+
+func writeDatasetCSV(w io.Writer, rows []datasetRow) error {
+	cw := csv.NewWriter(w)
+	header := []string{}
+	for _, c := range datasetColumns {
+		header = append(header, c.Name)
+	}
+	cw.Write(append(header, featureNames()...))
+	for _, r := range rows {
+		rec := r.strings()
+		for _, v := range r.row.Values {
+			rec = append(rec, strconv.FormatFloat(v, 'f', -1, 64))
+		}
+		cw.Write(rec)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeDatasetParquet(w io.Writer, rows []datasetRow) error {
+	var cols []*parquetColumn
+	for _, c := range datasetColumns {
+		pc := &parquetColumn{name: c.Name, typ: parquetByteArray}
+		switch c.Type {
+		case "int":
+			pc.typ = parquetInt32
+		case "boolean":
+			pc.typ, pc.optional = parquetBoolean, true
+		}
+		cols = append(cols, pc)
+	}
+	for _, name := range featureNames() {
+		cols = append(cols, &parquetColumn{name: name, typ: parquetDouble})
+	}
+	for _, r := range rows {
+		for i, s := range r.strings() {
+			switch cols[i].typ {
+			case parquetInt32:
+				cols[i].add(int32(r.row.PlayerID))
+			case parquetBoolean:
+				if r.row.Won != nil {
+					cols[i].add(*r.row.Won)
+				} else {
+					cols[i].add(nil)
+				}
+			default:
+				cols[i].add(s)
+			}
+		}
+		for j, v := range r.row.Values {
+			cols[len(datasetColumns)+j].add(v)
+		}
+	}
+	return writeParquet(w, cols, len(rows), "screp-go-service dataset "+datasetVersion)
+}
+
+// datasetHandler exports the feature vectors of the jobs' replays with
+// winner labels as a zip of the data file and manifest.json.
+func datasetHandler(w http.ResponseWriter, r *http.Request) {
+	var req DatasetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.JobIDs) == 0 || len(req.JobIDs) > maxDatasetJobs {
+		http.Error(w, "jobIds must list 1 to "+strconv.Itoa(maxDatasetJobs)+" jobs", http.StatusBadRequest)
+		return
+	}
+	if req.Format == "" {
+		req.Format = "csv"
+	}
+	write := writeDatasetCSV
+	switch req.Format {
+	case "csv":
+	case "parquet":
+		write = writeDatasetParquet
+	default:
+		http.Error(w, "Invalid format, must be csv or parquet", http.StatusBadRequest)
+		return
+	}
+
+	rows, replays, missing := datasetRows(req.JobIDs)
+	m := DatasetManifest{
+		Version:        datasetVersion,
+		FeatureVersion: featureVersion,
+		Analyzer:       analyzerVersions(),
+		CreatedAt:      time.Now().UTC(),
+		Format:         req.Format,
+		File:           "dataset." + req.Format,
+		Rows:           len(rows),
+		Replays:        replays,
+		Columns:        append([]DatasetColumn{}, datasetColumns...),
+		Labels: DatasetLabels{
+			Method:     "Winner inferred by screp as the largest team remaining at the end of the replay. high: 1v1 with a winner; medium: team games, where early leavers can mislead the inference; unknown: no winner detected",
+			Confidence: map[string]int{LabelHigh: 0, LabelMedium: 0, LabelUnknown: 0},
+		},
+		MissingJobs: missing,
+	}
+	for _, ft := range features {
+		m.Columns = append(m.Columns, DatasetColumn{ft.Name, "double", ft.Description})
+	}
+	for _, row := range rows {
+		m.Labels.Confidence[row.confidence]++
+	}
+
+	var data bytes.Buffer
+	if err := write(&data, rows); err != nil {
+		log.Printf("Error writing dataset: %v", err)
+		http.Error(w, "Dataset error", http.StatusInternalServerError)
+		return
+	}
+	manifest, _ := json.MarshalIndent(m, "", "  ")
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="dataset.zip"`)
+	zw := zip.NewWriter(w)
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{{m.File, data.Bytes()}, {"manifest.json", manifest}} {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: m.CreatedAt})
+		if err == nil {
+			_, err = fw.Write(f.data)
+		}
+		if err != nil {
+			log.Printf("Error writing dataset: %v", err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("Error writing dataset: %v", err)
+	}
+}
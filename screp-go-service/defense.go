@@ -0,0 +1,74 @@
+package main
+
+import (
+	"github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcmd"
+)
+
+// StaticDefense sums up a player's static defense: cannons, turrets,
+// bunkers, sunken and spore colonies.
+type StaticDefense struct {
+	Count      int                `json:"count"`
+	Minerals   int                `json:"minerals"` // Total investment
+	FirstTime  float64            `json:"firstTime,omitempty"`
+	Structures []DefenseStructure `json:"structures"`
+}
+
+// DefenseStructure is one static defense building, at the time its build
+// (or morph, for colonies) was ordered.
+type DefenseStructure struct {
+	Unit  string  `json:"unit"`
+	Frame int     `json:"frame"`
+	Time  float64 `json:"time"`
+	X     int     `json:"x,omitempty"` // Unknown for colony morphs
+	Y     int     `json:"y,omitempty"`
+}
+
+// defenseCosts are the mineral costs of the static defense buildings. The
+// colonies include the creep colony they're morphed from.
+var defenseCosts = map[uint16]int{
+	repcmd.UnitIDPhotonCannon:  150,
+	repcmd.UnitIDMissileTurret: 75,
+	repcmd.UnitIDBunker:        100,
+	repcmd.UnitIDSunkenColony:  125,
+	repcmd.UnitIDSporeColony:   125,
+}
+
+// staticDefense tracks the static defense of every player. Creep colonies
+// only count once morphed.
+func staticDefense(rp *rep.Replay) []StaticDefense {
+	res := make([]StaticDefense, len(rp.Header.Players))
+	for i := range res {
+		res[i].Structures = []DefenseStructure{}
+	}
+	for _, cmd := range rp.Commands {
+		var s DefenseStructure
+		var unit *repcmd.Unit
+		switch c := cmd.(type) {
+		case *repcmd.BuildCmd:
+			unit = c.Unit
+			s.X, s.Y = int(c.Pos.X), int(c.Pos.Y)
+		case *repcmd.BuildingMorphCmd:
+			unit = c.Unit
+		default:
+			continue
+		}
+		base := cmd.BaseCmd()
+		if unit == nil || int(base.PlayerID) >= len(res) {
+			continue
+		}
+		cost, ok := defenseCosts[unit.ID]
+		if !ok {
+			continue
+		}
+		s.Unit, s.Frame, s.Time = unit.String(), int(base.Frame), frameToSeconds(base.Frame)
+		d := &res[base.PlayerID]
+		if d.Count == 0 {
+			d.FirstTime = s.Time
+		}
+		d.Count++
+		d.Minerals += cost
+		d.Structures = append(d.Structures, s)
+	}
+	return res
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DeletionReceipt documents what a deletion request removed. Stored replays
+// that jobs of others reference too are retained, only the reference of the
+// deleted jobs is dropped. Copies of public job and share endpoints in
+// browser and shared caches can't be deleted, Cached lists them.
+type DeletionReceipt struct {
+	ID              string    `json:"id"`
+	Owner           string    `json:"owner,omitempty"`
+	Jobs            []string  `json:"jobs"`   // Deleted jobs, with their results
+	Shares          int       `json:"shares"` // Deleted share links
+	ReplaysDeleted  []string  `json:"replaysDeleted"`
+	ReplaysRetained []string  `json:"replaysRetained"`
+	Uploads         int       `json:"uploads"` // Deleted tus and presigned uploads
+	Cached          []string  `json:"cached"`  // Endpoints caches may keep serving until they expire
+	Errors          []string  `json:"errors,omitempty"`
+	DeletedAt       time.Time `json:"deletedAt"`
+}
+
+// publicJobEndpoints are the job endpoints served with public, immutable
+// cache headers, whose copies in shared caches outlive a deletion.
+var publicJobEndpoints = []string{"/overlay/", "/embed/", "/features/", "/apm/", "/seek/", "/report/"}
+
+// deleteJobs permanently deletes everything derived from the jobs, which
+// were removed from the job store: share links, stored replays no other job
+// references and uploads.
+func deleteJobs(gone []*Job, owner string) DeletionReceipt {
+	rc := DeletionReceipt{ID: newID(), Owner: owner, Jobs: []string{}, ReplaysDeleted: []string{}, ReplaysRetained: []string{}, Cached: []string{}}
+	for _, j := range gone {
+		rc.Jobs = append(rc.Jobs, j.ID)
+		for _, id := range shares.removeJob(j.ID) {
+			rc.Shares++
+			rc.Cached = append(rc.Cached, "/s/"+id, "/s/"+id+"/report", "/s/"+id+"/embed")
+		}
+		if j.Status == JobDone {
+			for _, e := range publicJobEndpoints {
+				rc.Cached = append(rc.Cached, e+j.ID)
+			}
+		}
+		if tusUploads.discard(j.ID) {
+			rc.Uploads++
+		}
+		if objects != nil && j.upload != "" {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			if err := objects.delete(ctx, j.upload); err != nil {
+				rc.Errors = append(rc.Errors, err.Error())
+			} else {
+				rc.Uploads++
+			}
+			cancel()
+		}
+		if replays == nil {
+			continue
+		}
+		for _, hash := range j.replayHashes() {
+			deleted, err := replays.release(hash)
+			switch {
+			case err != nil:
+				rc.Errors = append(rc.Errors, err.Error())
+			case deleted:
+				rc.ReplaysDeleted = append(rc.ReplaysDeleted, hash)
+			default:
+				rc.ReplaysRetained = append(rc.ReplaysRetained, hash)
+			}
+		}
+	}
+	sort.Strings(rc.Jobs)
+	sort.Strings(rc.Cached)
+	rc.DeletedAt = time.Now().UTC()
+	if len(rc.Errors) > 0 {
+		log.Printf("Deletion %s incomplete: %s", rc.ID, strings.Join(rc.Errors, "; "))
+	}
+	return rc
+}
+
+// expireJobs deletes the expired jobs every jobExpiryInterval, like
+// explicitly deleted ones, so nothing of them is left on an idle server.
+func expireJobs() {
+	for range time.Tick(jobExpiryInterval) {
+		if gone := jobs.expired(); len(gone) > 0 {
+			rc := deleteJobs(gone, "")
+			log.Printf("Expired %d jobs, deletion %s", len(rc.Jobs), rc.ID)
+		}
+	}
+}
+
+// deleteEarlierRun deletes the data of the jobs of an earlier run, which
+// didn't survive the restart: stored replays, presigned uploads and tus
+// files. Run at startup, before any job exists.
+func deleteEarlierRun(ctx context.Context) {
+	if replays != nil {
+		n, err := replays.sweep(ctx)
+		if err != nil {
+			log.Printf("Error deleting replays of an earlier run: %v", err)
+		} else if n > 0 {
+			log.Printf("Deleted %d replays of an earlier run", n)
+		}
+	}
+	if objects != nil {
+		keys, err := objects.list(ctx, "uploads/")
+		for _, key := range keys {
+			if err == nil {
+				err = objects.delete(ctx, key)
+			}
+		}
+		if err != nil {
+			log.Printf("Error deleting uploads of an earlier run: %v", err)
+		}
+	}
+	entries, err := os.ReadDir(tusDir)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Error deleting tus uploads of an earlier run: %v", err)
+	}
+	for _, e := range entries {
+		// Only upload files, named by their job ID, TUS_DIR may hold others
+		if _, err := hex.DecodeString(e.Name()); err != nil || len(e.Name()) != 32 || e.IsDir() {
+			continue
+		}
+		if err := os.Remove(filepath.Join(tusDir, e.Name())); err != nil {
+			log.Printf("Error deleting tus upload of an earlier run: %v", err)
+		}
+	}
+}
+
+// deleteJobHandler permanently deletes a job and its data. Like reading it,
+// this only takes the unguessable job ID.
+func deleteJobHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	gone := jobs.remove(func(j *Job) bool { return j.ID == id })
+	if len(gone) == 0 {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, deleteJobs(gone, ""))
+}
+
+// deleteOwnerHandler permanently deletes all jobs and data of an owner.
+func deleteOwnerHandler(w http.ResponseWriter, r *http.Request) {
+	owner := mux.Vars(r)["owner"]
+	gone := jobs.remove(func(j *Job) bool { return j.Owner == owner })
+	writeJSON(w, deleteJobs(gone, owner))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// maxEmbedBuildSteps is the number of build order steps in an embed card.
+const maxEmbedBuildSteps = 10
+
+// EmbedCard is a compact match card for third-party embeds such as forum
+// posts and link unfurlers.
+type EmbedCard struct {
+	Map     string        `json:"map"`
+	Seconds int           `json:"sec"`
+	Matchup string        `json:"mu"`
+	Winner  int           `json:"winner,omitempty"` // Team, 0 if unknown
+	Players []EmbedPlayer `json:"players"`
+}
+
+type EmbedPlayer struct {
+	Name  string      `json:"name"`
+	Race  string      `json:"race"`
+	Team  int         `json:"team"`
+	APM   int         `json:"apm"`
+	Build []EmbedStep `json:"build"` // First build order steps
+}
+
+type EmbedStep struct {
+	Seconds int    `json:"sec"`
+	Name    string `json:"name"`
+}
+
+func embedCard(res *ReplayResult) EmbedCard {
+	c := EmbedCard{
+		Map:     res.CanonicalMapName,
+		Seconds: int(res.DurationSeconds),
+		Matchup: overlaySummary(res).Matchup,
+		Winner:  res.WinnerTeam,
+		Players: make([]EmbedPlayer, len(res.Players)),
+	}
+	for i, p := range res.Players {
+		c.Players[i] = EmbedPlayer{Name: p.Name, Race: p.Race, Team: p.Team, APM: p.APM, Build: []EmbedStep{}}
+	}
+	for _, b := range res.BuildOrders {
+		if b.PlayerID >= len(c.Players) {
+			continue
+		}
+		p := &c.Players[b.PlayerID]
+		for _, step := range b.Sequence {
+			if len(p.Build) == maxEmbedBuildSteps {
+				break
+			}
+			p.Build = append(p.Build, EmbedStep{Seconds: int(step.Time), Name: step.Target})
+		}
+	}
+	return c
+}
+
+// embedHandler parses an uploaded replay and returns its embed card. Actions
+// aren't needed, so they aren't collected.
+func embedHandler(w http.ResponseWriter, r *http.Request) {
+	file, _, err := r.FormFile("replay")
+	if err != nil {
+		http.Error(w, "Missing replay file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	rp, err := decodeReplay(file)
+	if err != nil {
+		http.Error(w, "Parse error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, embedCard(buildResult(rp, false)))
+}
+
+// jobEmbedHandler returns the embed card of a parsed job, with long-lived
+// cache headers like the overlay summary.
+func jobEmbedHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := jobs.get(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if job.Status != JobDone || job.Result == nil {
+		w.Header().Set("Cache-Control", "no-store")
+		http.Error(w, "Job "+job.Status, http.StatusConflict)
+		return
+	}
+
+	etag := `"` + job.ID + `"`
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, embedCard(job.Result))
+}
+
+// sharedEmbedHandler returns the embed card of a share link.
+func sharedEmbedHandler(w http.ResponseWriter, r *http.Request) {
+	res, ok := sharedResult(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, embedCard(res))
+}
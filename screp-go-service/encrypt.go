@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// sealedMagic starts encrypted blobs: the format version. Blobs without it
+// are plaintext, stored before encryption was enabled.
+const sealedMagic = "SRE1"
+
+// keyWrapper wraps the per-blob data keys with a key encryption key,
+// authenticating the additional data ad with them. It's the extension point
+// for key management services; keyFile implements it with local keys.
+type keyWrapper interface {
+	keyID() []byte // Identifies the key used by wrap, stored with the blob
+	wrap(dek, ad []byte) ([]byte, error)
+	unwrap(keyID, wrapped, ad []byte) ([]byte, error)
+}
+
+// keyFile holds AES-256 key encryption keys loaded from a file: one key per
+// line, hex or base64 encoded. The first key wraps new data keys, the others
+// only unwrap, so keys can be rotated without re-encrypting existing blobs.
+type keyFile struct {
+	keys []cipher.AEAD
+	ids  [][]byte
+}
+
+func loadKeyFile(path string) (*keyFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	kf := &keyFile{}
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := hex.DecodeString(line)
+		if err != nil {
+			key, err = base64.StdEncoding.DecodeString(line)
+		}
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("%s:%d: key must be 32 bytes, hex or base64 encoded", path, n+1)
+		}
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(key)
+		kf.keys = append(kf.keys, aead)
+		kf.ids = append(kf.ids, sum[:8])
+	}
+	if len(kf.keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys", path)
+	}
+	return kf, nil
+}
+
+func (kf *keyFile) keyID() []byte { return kf.ids[0] }
+
+func (kf *keyFile) wrap(dek, ad []byte) ([]byte, error) {
+	return seal(kf.keys[0], dek, ad)
+}
+
+func (kf *keyFile) unwrap(keyID, wrapped, ad []byte) ([]byte, error) {
+	for i, id := range kf.ids {
+		if bytes.Equal(id, keyID) {
+			return unseal(kf.keys[i], wrapped, ad)
+		}
+	}
+	return nil, fmt.Errorf("unknown key %x", keyID)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts data with a random nonce, prepended to the result, and
+// authenticates the additional data ad.
+func seal(aead cipher.AEAD, data, ad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, ad), nil
+}
+
+// unseal decrypts the result of seal, failing unless ad is the same.
+func unseal(aead cipher.AEAD, sealed, ad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed data too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], ad)
+}
+
+// sealedBackend encrypts blobs with envelope encryption: each blob with its
+// own random AES-256-GCM data key, which is stored with the blob, wrapped by
+// the key encryption key. The layout is the magic, key ID length and key ID,
+// wrapped key length and wrapped key, then the encrypted blob. Both the data
+// key and the blob are bound to the blob key, the content hash of the
+// replay, so they can't be moved to another blob unnoticed. Plaintext blobs
+// are read too if they match their hash.
+type sealedBackend struct {
+	blobBackend
+	keys keyWrapper
+}
+
+func (s sealedBackend) put(ctx context.Context, key string, data []byte) error {
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return err
+	}
+	aead, err := newGCM(dek)
+	if err != nil {
+		return err
+	}
+	wrapped, err := s.keys.wrap(dek, []byte(key))
+	if err != nil {
+		return err
+	}
+	sealed, err := seal(aead, data, []byte(key))
+	if err != nil {
+		return err
+	}
+
+	id := s.keys.keyID()
+	var buf bytes.Buffer
+	buf.WriteString(sealedMagic)
+	buf.WriteByte(byte(len(id)))
+	buf.Write(id)
+	buf.WriteByte(byte(len(wrapped)))
+	buf.Write(wrapped)
+	buf.Write(sealed)
+	return s.blobBackend.put(ctx, key, buf.Bytes())
+}
+
+func (s sealedBackend) get(ctx context.Context, key string) (io.ReadCloser, error) {
+	body, err := s.blobBackend.get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(io.LimitReader(body, maxReplaySize+1024))
+	if err != nil {
+		return nil, err
+	}
+
+	corrupt := fmt.Errorf("%s: not an encrypted replay", key)
+	if !bytes.HasPrefix(data, []byte(sealedMagic)) {
+		if replayKey(replayHash(data)) != key {
+			return nil, corrupt
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	data = data[len(sealedMagic):]
+	field := func() []byte {
+		if len(data) == 0 || len(data) < 1+int(data[0]) {
+			return nil
+		}
+		f := data[1 : 1+data[0]]
+		data = data[1+data[0]:]
+		return f
+	}
+	id, wrapped := field(), field()
+	if id == nil || wrapped == nil {
+		return nil, corrupt
+	}
+	dek, err := s.keys.unwrap(id, wrapped, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", key, err)
+	}
+	aead, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := unseal(aead, data, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", key, err)
+	}
+	return io.NopCloser(bytes.NewReader(plain)), nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKeyFile(t *testing.T, keys ...string) *keyFile {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte(strings.Join(keys, "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	kf, err := loadKeyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kf
+}
+
+const (
+	testKeyA = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	testKeyB = "1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100"
+)
+
+func readBlob(b blobBackend, key string) ([]byte, error) {
+	rc, err := b.get(context.Background(), key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func TestSealedBackendRoundTrip(t *testing.T) {
+	dir := dirBackend(t.TempDir())
+	s := sealedBackend{blobBackend: dir, keys: testKeyFile(t, testKeyA)}
+	data := []byte("replay with chat")
+	key := replayKey(replayHash(data))
+	if err := s.put(context.Background(), key, data); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := readBlob(dir, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(raw, []byte(sealedMagic)) || bytes.Contains(raw, data) {
+		t.Fatal("blob isn't encrypted")
+	}
+	got, err := readBlob(s, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("got %q, want %q", got, data)
+	}
+
+	// Rotated: the old key still decrypts as second key
+	rotated := sealedBackend{blobBackend: dir, keys: testKeyFile(t, testKeyB, testKeyA)}
+	if got, err := readBlob(rotated, key); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("rotated keys: got %q, %v", got, err)
+	}
+}
+
+func TestSealedBackendWrongKey(t *testing.T) {
+	dir := dirBackend(t.TempDir())
+	data := []byte("replay")
+	key := replayKey(replayHash(data))
+	if err := (sealedBackend{blobBackend: dir, keys: testKeyFile(t, testKeyA)}).put(context.Background(), key, data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readBlob(sealedBackend{blobBackend: dir, keys: testKeyFile(t, testKeyB)}, key); err == nil {
+		t.Error("decrypted with the wrong key")
+	}
+}
+
+func TestSealedBackendMovedBlob(t *testing.T) {
+	dir := dirBackend(t.TempDir())
+	s := sealedBackend{blobBackend: dir, keys: testKeyFile(t, testKeyA)}
+	data := []byte("replay")
+	key := replayKey(replayHash(data))
+	if err := s.put(context.Background(), key, data); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := readBlob(dir, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := replayKey(replayHash([]byte("other replay")))
+	if err := dir.put(context.Background(), other, raw); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readBlob(s, other); err == nil {
+		t.Error("decrypted a blob moved to another key")
+	}
+}
+
+func TestSealedBackendPlaintext(t *testing.T) {
+	dir := dirBackend(t.TempDir())
+	s := sealedBackend{blobBackend: dir, keys: testKeyFile(t, testKeyA)}
+	data := []byte("stored before encryption")
+	key := replayKey(replayHash(data))
+	if err := dir.put(context.Background(), key, data); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readBlob(s, key); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("plaintext blob: got %q, %v", got, err)
+	}
+
+	other := replayKey(replayHash([]byte("other replay")))
+	if err := dir.put(context.Background(), other, data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readBlob(s, other); err == nil {
+		t.Error("read a plaintext blob not matching its hash")
+	}
+}
+
+func TestLoadKeyFileInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	for _, content := range []string{"", "# only a comment\n", "abcd\n"} {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadKeyFile(path); err == nil {
+			t.Errorf("%q: no error", content)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcmd"
+	"github.com/icza/screp/rep/repcore"
+)
+
+// Engagement is a fight: attack and spell commands clustered in space and
+// time.
+type Engagement struct {
+	ID              int     `json:"id"`
+	Name            string  `json:"name"`
+	Frame           int     `json:"frame"`
+	Time            float64 `json:"time"`
+	EndFrame        int     `json:"endFrame"`
+	EndTime         float64 `json:"endTime"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	X               int     `json:"x"` // Centroid of the commands, in pixels
+	Y               int     `json:"y"`
+	Participants    []int   `json:"participants"` // Player IDs
+	Commands        int     `json:"commands"`
+
+	Paths []AttackPath `json:"paths"`
+}
+
+// Thresholds of the engagement clustering
+const (
+	engagementGapSeconds = 10            // Fights end after this long without commands
+	engagementRadius     = 14 * tileSize // Commands join a fight this close to its centroid
+	engagementMinCmds    = 6
+	engagementNameRadius = 20 * tileSize // Fights this close to a base are named after it
+)
+
+// isCombatCmd tells if the command is an attack or a spell cast at a target.
+func isCombatCmd(cmd repcmd.Cmd) bool {
+	c, ok := cmd.(*repcmd.TargetedOrderCmd)
+	if !ok || c.Order == nil {
+		return false
+	}
+	return repcmd.IsOrderIDKindAttack(c.Order.ID) || strings.HasPrefix(c.Order.Name, "Cast") ||
+		c.Order.Name == "FireYamatoGun"
+}
+
+// detectEngagements clusters the combat commands of all players: a command
+// joins the fight it is near to if that fight is still going on, else it
+// starts a new one. Fights with few commands are dropped.
+func detectEngagements(rp *rep.Replay) []Engagement {
+	type fight struct {
+		Engagement
+		sumX, sumY int
+		first      repcore.Frame
+		last       repcore.Frame
+		players    map[int]bool
+	}
+	gap := secondsToFrames(engagementGapSeconds)
+	var open, closed []*fight
+
+	for _, cmd := range rp.Commands {
+		if !isCombatCmd(cmd) {
+			continue
+		}
+		base := cmd.BaseCmd()
+		pos, _ := cmdPos(cmd)
+
+		// Close the fights that are over
+		active := open[:0]
+		for _, f := range open {
+			if base.Frame-f.last > gap {
+				closed = append(closed, f)
+			} else {
+				active = append(active, f)
+			}
+		}
+		open = active
+
+		var joined *fight
+		for _, f := range open {
+			center := repcore.Point{X: uint16(f.sumX / f.Commands), Y: uint16(f.sumY / f.Commands)}
+			if dist(pos, center) < engagementRadius {
+				joined = f
+				break
+			}
+		}
+		if joined == nil {
+			joined = &fight{first: base.Frame, players: map[int]bool{}}
+			open = append(open, joined)
+		}
+		joined.Commands++
+		joined.sumX += int(pos.X)
+		joined.sumY += int(pos.Y)
+		joined.last = base.Frame
+		joined.players[int(base.PlayerID)] = true
+	}
+	closed = append(closed, open...)
+
+	starts := startLocations(rp)
+	bases := playerBases(rp)
+	engagements := []Engagement{}
+	for _, f := range closed {
+		if f.Commands < engagementMinCmds {
+			continue
+		}
+		e := f.Engagement
+		e.Frame, e.Time = int(f.first), frameToSeconds(f.first)
+		e.EndFrame, e.EndTime = int(f.last), frameToSeconds(f.last)
+		e.DurationSeconds = round(frameToSeconds(f.last-f.first), 1)
+		e.X, e.Y = f.sumX/f.Commands, f.sumY/f.Commands
+		for pid := range rp.Header.Players {
+			if f.players[pid] {
+				e.Participants = append(e.Participants, pid)
+			}
+		}
+		e.Name = fmt.Sprintf("Fight %s (%s)", engagementPlace(rp, starts, bases, repcore.Point{X: uint16(e.X), Y: uint16(e.Y)}, f.first), formatClock(f.first))
+		engagements = append(engagements, e)
+	}
+
+	// Fights were closed out of order, number them by start
+	sort.SliceStable(engagements, func(i, j int) bool { return engagements[i].Frame < engagements[j].Frame })
+	for i := range engagements {
+		engagements[i].ID = i + 1
+	}
+	attackPaths(rp, engagements)
+	return engagements
+}
+
+// engagementPlace names the place of a fight after the nearest base.
+func engagementPlace(rp *rep.Replay, starts map[int]repcore.Point, bases [][]playerBase, pos repcore.Point, f repcore.Frame) string {
+	nearest, place := float64(engagementNameRadius), "mid map"
+	for pid, bs := range bases {
+		for _, b := range bs {
+			if d := dist(pos, b.pos); b.frame <= f && d < nearest {
+				kind := "expansion"
+				if s, ok := starts[pid]; ok && s == b.pos {
+					kind = "main"
+				}
+				nearest, place = d, fmt.Sprintf("at %s's %s", rp.Header.Players[pid].Name, kind)
+			}
+		}
+	}
+	return place
+}
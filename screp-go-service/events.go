@@ -0,0 +1,33 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/icza/screp/rep"
+)
+
+// Event kinds
+const (
+	EventProxy  = "proxy_building"
+	EventWallIn = "wall_in"
+)
+
+// Event is a notable moment of the game, e.g. for timelines and highlights.
+type Event struct {
+	PlayerID    int     `json:"playerId"`
+	Kind        string  `json:"kind"`
+	Frame       int     `json:"frame"`
+	Time        float64 `json:"time"`
+	X           int     `json:"x"`
+	Y           int     `json:"y"`
+	Description string  `json:"description"`
+}
+
+// detectEvents collects the notable events of all detectors, in game order.
+func detectEvents(rp *rep.Replay) []Event {
+	var events []Event
+	events = append(events, proxyBuildings(rp)...)
+	events = append(events, wallIns(rp)...)
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Frame < events[j].Frame })
+	return events
+}
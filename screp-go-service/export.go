@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// timelineEntry is a key moment of the game for exports, Time and Duration
+// in seconds of game time. Duration is 0 for instant events.
+type timelineEntry struct {
+	Time     float64
+	Duration float64
+	Label    string
+}
+
+// keyTimeline returns the key moments of the game in order: start, events,
+// engagements, highlights and end.
+func keyTimeline(res *ReplayResult) []timelineEntry {
+	name := func(pid int) string {
+		if pid < len(res.Players) {
+			return res.Players[pid].Name
+		}
+		return fmt.Sprintf("Player %d", pid)
+	}
+
+	var names []string
+	for _, p := range res.Players {
+		names = append(names, p.Name)
+	}
+	entries := []timelineEntry{{Label: "Game start: " + strings.Join(names, " vs ")}}
+	for _, e := range res.Events {
+		entries = append(entries, timelineEntry{Time: e.Time, Label: name(e.PlayerID) + ": " + e.Description})
+	}
+	for _, e := range res.Engagements {
+		entries = append(entries, timelineEntry{Time: e.Time, Duration: e.DurationSeconds, Label: e.Name})
+	}
+	for _, e := range res.Highlights {
+		entries = append(entries, timelineEntry{Time: e.Time, Label: e.Description})
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time < entries[j].Time })
+
+	end := "Game end"
+	if res.WinnerTeam != 0 {
+		var winners []string
+		for _, p := range res.Players {
+			if p.Team == res.WinnerTeam {
+				winners = append(winners, p.Name)
+			}
+		}
+		end += ": " + strings.Join(winners, ", ") + " wins"
+	}
+	return append(entries, timelineEntry{Time: float64(res.DurationSeconds), Label: end})
+}
+
+// parseOffset parses a recording offset given in seconds ("83.5") or as a
+// clock ("1:23", "1:02:03"). Empty means 0.
+func parseOffset(s string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	var secs float64
+	for _, part := range strings.Split(s, ":") {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid offset %q", s)
+		}
+		secs = secs*60 + v
+	}
+	return secs, nil
+}
+
+// formatVideoTime formats seconds as a video timestamp: m:ss, or h:mm:ss
+// from an hour on.
+func formatVideoTime(secs float64) string {
+	t := int(secs)
+	if t >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", t/3600, t/60%60, t%60)
+	}
+	return fmt.Sprintf("%d:%02d", t/60, t%60)
+}
+
+// minChapterSeconds is YouTube's minimum chapter length.
+const minChapterSeconds = 10
+
+// youtubeChapters renders the timeline as YouTube chapters, shifted by the
+// recording offset. YouTube requires the first chapter at 0:00 and chapters
+// of at least 10 seconds, so entries too close to the previous are dropped.
+func youtubeChapters(entries []timelineEntry, offset float64) string {
+	var sb strings.Builder
+	last := -1.0
+	if offset >= minChapterSeconds {
+		sb.WriteString("0:00 Intro\n")
+		last = 0
+	}
+	for _, e := range entries {
+		t := e.Time + offset
+		if last < 0 {
+			t = 0 // First chapter has to start at 0:00
+		} else if t-last < minChapterSeconds {
+			continue
+		}
+		fmt.Fprintf(&sb, "%s %s\n", formatVideoTime(t), e.Label)
+		last = t
+	}
+	return sb.String()
+}
+
+// minCueSeconds is how long subtitle cues of instant events stay on screen.
+const minCueSeconds = 4
+
+// subtitles renders the timeline as SRT or WebVTT (vtt true) cues, shifted by
+// the recording offset.
+func subtitles(entries []timelineEntry, offset float64, vtt bool) string {
+	stamp := func(secs float64) string {
+		ms := int(math.Round(secs * 1000))
+		sep := ","
+		if vtt {
+			sep = "."
+		}
+		return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+	}
+
+	var sb strings.Builder
+	if vtt {
+		sb.WriteString("WEBVTT\n\n")
+	}
+	for i, e := range entries {
+		start := e.Time + offset
+		end := start + math.Max(e.Duration, minCueSeconds)
+		if !vtt {
+			fmt.Fprintf(&sb, "%d\n", i+1)
+		}
+		fmt.Fprintf(&sb, "%s --> %s\n%s\n\n", stamp(start), stamp(end), e.Label)
+	}
+	return sb.String()
+}
+
+// exportRequest parses the replay and the offset of an export request,
+// responding with an error if either is invalid.
+func exportRequest(w http.ResponseWriter, r *http.Request) (*ReplayResult, float64, bool) {
+	file, _, err := r.FormFile("replay")
+	if err != nil {
+		http.Error(w, "Missing replay file", http.StatusBadRequest)
+		return nil, 0, false
+	}
+	defer file.Close()
+
+	offset, err := parseOffset(r.FormValue("offset"))
+	if err != nil {
+		http.Error(w, "Invalid offset", http.StatusBadRequest)
+		return nil, 0, false
+	}
+
+	rp, err := decodeReplay(file)
+	if err != nil {
+		http.Error(w, "Parse error: "+err.Error(), http.StatusInternalServerError)
+		return nil, 0, false
+	}
+	return buildResult(rp, false), offset, true
+}
+
+// subtitlesHandler exports the key events of the uploaded replay as subtitle
+// file, SRT by default or WebVTT with format=vtt. The offset form value is
+// where the game starts in the video.
+func subtitlesHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.FormValue("format")
+	if format == "" {
+		format = "srt"
+	}
+	if format != "srt" && format != "vtt" {
+		http.Error(w, "Invalid format, must be srt or vtt", http.StatusBadRequest)
+		return
+	}
+	res, offset, ok := exportRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if format == "vtt" {
+		w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/x-subrip; charset=utf-8")
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="events.`+format+`"`)
+	fmt.Fprint(w, subtitles(keyTimeline(res), offset, format == "vtt"))
+}
+
+// chaptersHandler exports the key events of the uploaded replay as a YouTube
+// chapter list. The offset form value is where the game starts in the video.
+func chaptersHandler(w http.ResponseWriter, r *http.Request) {
+	res, offset, ok := exportRequest(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, youtubeChapters(keyTimeline(res), offset))
+}
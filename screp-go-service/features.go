@@ -0,0 +1,320 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// featureVersion is bumped whenever features are added, removed or change
+// meaning, so datasets built from different versions aren't mixed up.
+const featureVersion = "v1"
+
+// noValue is the value of timing features the player never reached.
+const noValue = -1
+
+// FeatureSpec documents one entry of the feature vector.
+type FeatureSpec struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// FeatureRow is the feature vector of one player in one replay. Won is the
+// label for outcome models, nil if the winner is unknown.
+type FeatureRow struct {
+	Replay   string    `json:"replay,omitempty"` // File name, for uploads and batches
+	PlayerID int       `json:"playerId"`
+	Player   string    `json:"player"`
+	Matchup  string    `json:"matchup,omitempty"`
+	Won      *bool     `json:"won,omitempty"`
+	Values   []float64 `json:"values"`
+}
+
+// FeatureSet holds feature vectors with the names of their entries.
+type FeatureSet struct {
+	Version  string       `json:"version"`
+	Features []string     `json:"features"`
+	Rows     []FeatureRow `json:"rows"`
+}
+
+// featurePlayer is what features are computed from: a player of a result
+// and the first opponent, nil if there is none.
+type featurePlayer struct {
+	res *ReplayResult
+	p   *PlayerInfo
+	opp *PlayerInfo
+}
+
+// perMinute returns n per minute of game time.
+func (f featurePlayer) perMinute(n float64) float64 {
+	if f.res.DurationSeconds <= 0 {
+		return 0
+	}
+	return round(n/(float64(f.res.DurationSeconds)/60), 3)
+}
+
+func timing(t *float64) float64 {
+	if t == nil {
+		return noValue
+	}
+	return *t
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// unitCategories classify the units of unitsOrdered for the composition
+// shares. Spellcasters count as casters, whether air or ground, other units
+// as ground army.
+var unitCategories = map[string]string{
+	"SCV": "worker", "Probe": "worker", "Drone": "worker",
+	"Overlord": "supply",
+	"Medic":    "caster", "Science Vessel": "caster", "High Templar": "caster", "Dark Archon": "caster", "Queen": "caster", "Defiler": "caster",
+	"Wraith": "air", "Dropship": "air", "Valkyrie": "air", "Battlecruiser": "air",
+	"Shuttle": "air", "Observer": "air", "Scout": "air", "Corsair": "air", "Carrier": "air", "Arbiter": "air",
+	"Mutalisk": "air", "Scourge": "air", "Guardian": "air", "Devourer": "air",
+}
+
+// compositionShare returns the share of the units ordered (without supply
+// units) in the category.
+func (f featurePlayer) compositionShare(category string) float64 {
+	n, total := 0, 0
+	for name, count := range f.p.UnitsOrdered {
+		c := unitCategories[name]
+		if c == "" {
+			c = "ground"
+		}
+		if c == "supply" {
+			continue
+		}
+		total += count
+		if c == category {
+			n += count
+		}
+	}
+	return ratio(n, total)
+}
+
+// utilization is the mean utilization of the player's production
+// facilities, weighted by the time they were available.
+func (f featurePlayer) utilization() float64 {
+	var busy, available float64
+	for _, fu := range f.p.Production {
+		busy += fu.Utilization * fu.AvailableSeconds
+		available += fu.AvailableSeconds
+	}
+	if available == 0 {
+		return 0
+	}
+	return round(busy/available, 3)
+}
+
+func (f featurePlayer) engagements() int {
+	n := 0
+	for _, e := range f.res.Engagements {
+		for _, pid := range e.Participants {
+			if pid == f.p.ID {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+type feature struct {
+	FeatureSpec
+	value func(f featurePlayer) float64
+}
+
+func raceFeature(name, desc, race string, opp bool) feature {
+	return feature{FeatureSpec{name, desc}, func(f featurePlayer) float64 {
+		if opp {
+			return boolValue(f.opp != nil && f.opp.Race == race)
+		}
+		return boolValue(f.p.Race == race)
+	}}
+}
+
+// features are the entries of the feature vector, in order. Only append to
+// the list, and bump featureVersion with any change.
+var features = []feature{
+	raceFeature("race_terran", "1 if the player is Terran", "Terran", false),
+	raceFeature("race_protoss", "1 if the player is Protoss", "Protoss", false),
+	raceFeature("race_zerg", "1 if the player is Zerg", "Zerg", false),
+	raceFeature("opp_race_terran", "1 if the first opponent is Terran", "Terran", true),
+	raceFeature("opp_race_protoss", "1 if the first opponent is Protoss", "Protoss", true),
+	raceFeature("opp_race_zerg", "1 if the first opponent is Zerg", "Zerg", true),
+	{FeatureSpec{"duration_sec", "Game duration in seconds"}, func(f featurePlayer) float64 { return round(float64(f.res.DurationSeconds), 1) }},
+	{FeatureSpec{"apm", "Actions per minute"}, func(f featurePlayer) float64 { return float64(f.p.APM) }},
+	{FeatureSpec{"eapm", "Effective actions per minute"}, func(f featurePlayer) float64 { return float64(f.p.EAPM) }},
+	{FeatureSpec{"first_gas_sec", "Time of the first gas building, -1 if none"}, func(f featurePlayer) float64 { return timing(f.p.Milestones.FirstGas) }},
+	{FeatureSpec{"first_expansion_sec", "Time of the first expansion, -1 if none"}, func(f featurePlayer) float64 { return timing(f.p.Milestones.FirstExpansion) }},
+	{FeatureSpec{"first_tech_building_sec", "Time of the first tech building, -1 if none"}, func(f featurePlayer) float64 { return timing(f.p.Milestones.FirstTechBuilding) }},
+	{FeatureSpec{"first_army_unit_sec", "Time of the first army unit ordered, -1 if none"}, func(f featurePlayer) float64 { return timing(f.p.Milestones.FirstArmyUnit) }},
+	{FeatureSpec{"first_upgrade_sec", "Time of the first upgrade or tech research, -1 if none"}, func(f featurePlayer) float64 { return timing(f.p.Milestones.FirstUpgrade) }},
+	{FeatureSpec{"hotkey_select_ratio", "Share of selections done by control group recall, 0..1"}, func(f featurePlayer) float64 { return f.p.Hotkeys.HotkeySelectRatio }},
+	{FeatureSpec{"hotkey_ratio", "Share of hotkey-driven actions, 0..1"}, func(f featurePlayer) float64 { return f.p.Hotkeys.HotkeyRatio }},
+	{FeatureSpec{"control_groups", "Number of control groups recalled"}, func(f featurePlayer) float64 { return float64(len(f.p.Hotkeys.Groups)) }},
+	{FeatureSpec{"recalls_per_min", "Control group recalls per minute"}, func(f featurePlayer) float64 { return f.perMinute(float64(f.p.Hotkeys.Recalls)) }},
+	{FeatureSpec{"assigns_per_min", "Control group assignments per minute"}, func(f featurePlayer) float64 { return f.perMinute(float64(f.p.Hotkeys.Assigns)) }},
+	{FeatureSpec{"mouse_commands_per_min", "Commands with a target or placement click per minute"}, func(f featurePlayer) float64 { return f.perMinute(float64(f.p.Hotkeys.MouseCommands)) }},
+	{FeatureSpec{"keyboard_commands_per_min", "Other commands per minute"}, func(f featurePlayer) float64 { return f.perMinute(float64(f.p.Hotkeys.KeyboardCommands)) }},
+	{FeatureSpec{"production_utilization", "Utilization of all production facilities, weighted by availability, 0..1"}, func(f featurePlayer) float64 { return f.utilization() }},
+	{FeatureSpec{"production_facilities", "Production facilities at the end of the game"}, func(f featurePlayer) float64 {
+		n := 0
+		for _, fu := range f.p.Production {
+			n += fu.Count
+		}
+		return float64(n)
+	}},
+	{FeatureSpec{"units_per_min", "Units ordered per minute, supply units included"}, func(f featurePlayer) float64 {
+		n := 0
+		for _, count := range f.p.UnitsOrdered {
+			n += count
+		}
+		return f.perMinute(float64(n))
+	}},
+	{FeatureSpec{"worker_share", "Share of workers among the units ordered (supply units excluded), 0..1"}, func(f featurePlayer) float64 { return f.compositionShare("worker") }},
+	{FeatureSpec{"ground_army_share", "Share of ground army units among the units ordered, 0..1"}, func(f featurePlayer) float64 { return f.compositionShare("ground") }},
+	{FeatureSpec{"air_army_share", "Share of air army units among the units ordered, 0..1"}, func(f featurePlayer) float64 { return f.compositionShare("air") }},
+	{FeatureSpec{"caster_share", "Share of spellcasters among the units ordered, 0..1"}, func(f featurePlayer) float64 { return f.compositionShare("caster") }},
+	{FeatureSpec{"worker_pulls", "Number of worker pulls"}, func(f featurePlayer) float64 { return float64(len(f.p.WorkerPulls)) }},
+	{FeatureSpec{"worker_pull_lost_minerals", "Estimated minerals not mined due to worker pulls"}, func(f featurePlayer) float64 {
+		n := 0
+		for _, wp := range f.p.WorkerPulls {
+			n += wp.LostMinerals
+		}
+		return float64(n)
+	}},
+	{FeatureSpec{"static_defense", "Number of static defense buildings"}, func(f featurePlayer) float64 { return float64(f.p.StaticDefense.Count) }},
+	{FeatureSpec{"static_defense_minerals", "Minerals invested in static defense"}, func(f featurePlayer) float64 { return float64(f.p.StaticDefense.Minerals) }},
+	{FeatureSpec{"upgrade_references_started", "Share of the matchup's reference upgrades started, -1 if the matchup has none"}, func(f featurePlayer) float64 {
+		if len(f.p.Upgrades) == 0 {
+			return noValue
+		}
+		n := 0
+		for _, u := range f.p.Upgrades {
+			if u.ActualTime != nil {
+				n++
+			}
+		}
+		return ratio(n, len(f.p.Upgrades))
+	}},
+	{FeatureSpec{"spells_per_min", "Targeted spell casts per minute"}, func(f featurePlayer) float64 { return f.perMinute(float64(f.p.Spells.Total)) }},
+	{FeatureSpec{"casts_per_engagement", "Spell casts in engagements per engagement taken part in"}, func(f featurePlayer) float64 { return f.p.Spells.CastsPerEngagement }},
+	{FeatureSpec{"engagements", "Number of engagements taken part in"}, func(f featurePlayer) float64 { return float64(f.engagements()) }},
+	{FeatureSpec{"avg_distance_from_main", "Mean distance of target positions from the own main, in tiles"}, func(f featurePlayer) float64 { return f.p.Positioning.AvgDistanceFromMain }},
+	{FeatureSpec{"enemy_territory_share", "Share of target positions closer to an enemy start location, 0..1"}, func(f featurePlayer) float64 { return f.p.Positioning.EnemyTerritoryShare }},
+	{FeatureSpec{"aggression_index", "Mean forwardness of target positions, 0 at home to 1 at the enemy main"}, func(f featurePlayer) float64 { return f.p.Positioning.AggressionIndex }},
+}
+
+// featureNames returns the names of the feature vector entries.
+func featureNames() []string {
+	names := make([]string, len(features))
+	for i, ft := range features {
+		names[i] = ft.Name
+	}
+	return names
+}
+
+// featureRows computes the feature vector of every player of the result.
+func featureRows(replay string, res *ReplayResult) []FeatureRow {
+	rows := make([]FeatureRow, len(res.Players))
+	for i := range res.Players {
+		p := &res.Players[i]
+		f := featurePlayer{res: res, p: p}
+		for j := range res.Players {
+			if res.Players[j].Team != p.Team {
+				f.opp = &res.Players[j]
+				break
+			}
+		}
+		row := FeatureRow{Replay: replay, PlayerID: p.ID, Player: p.Name, Matchup: playerMatchup(res, *p), Values: make([]float64, len(features))}
+		if res.WinnerTeam != 0 {
+			won := p.Team == res.WinnerTeam
+			row.Won = &won
+		}
+		for j, ft := range features {
+			row.Values[j] = ft.value(f)
+		}
+		rows[i] = row
+	}
+	return rows
+}
+
+// jobFeatures returns the feature vectors of a job's replays: the result,
+// or every parsed replay of a batch.
+func jobFeatures(job Job) FeatureSet {
+	set := FeatureSet{Version: featureVersion, Features: featureNames(), Rows: []FeatureRow{}}
+	if job.Result != nil {
+		set.Rows = append(set.Rows, featureRows("", job.Result)...)
+	}
+	for _, item := range job.Batch {
+		if item.Result != nil {
+			set.Rows = append(set.Rows, featureRows(item.Name, item.Result)...)
+		}
+	}
+	return set
+}
+
+// featureSpecsHandler documents the feature vector.
+func featureSpecsHandler(w http.ResponseWriter, r *http.Request) {
+	specs := make([]FeatureSpec, len(features))
+	for i, ft := range features {
+		specs[i] = ft.FeatureSpec
+	}
+	writeJSON(w, struct {
+		Version  string        `json:"version"`
+		Features []FeatureSpec `json:"features"`
+	}{featureVersion, specs})
+}
+
+// featuresHandler parses an uploaded replay and returns its feature vectors.
+func featuresHandler(w http.ResponseWriter, r *http.Request) {
+	file, header, err := r.FormFile("replay")
+	if err != nil {
+		http.Error(w, "Missing replay file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	rp, err := decodeReplay(file)
+	if err != nil {
+		http.Error(w, "Parse error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, FeatureSet{Version: featureVersion, Features: featureNames(), Rows: featureRows(header.Filename, buildResult(rp, false))})
+}
+
+// jobFeaturesHandler returns the feature vectors of a parsed job, single
+// replay or batch, with long-lived cache headers like the overlay summary.
+func jobFeaturesHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := jobs.get(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if job.Status != JobDone {
+		w.Header().Set("Cache-Control", "no-store")
+		http.Error(w, "Job "+job.Status, http.StatusConflict)
+		return
+	}
+	if job.Result == nil && job.Batch == nil {
+		http.Error(w, "Job has no replays", http.StatusConflict)
+		return
+	}
+
+	etag := `"` + featureVersion + "-" + job.ID + `"`
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, jobFeatures(job))
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// replayHost is a connector to a community replay host.
+type replayHost struct {
+	Name string
+
+	// Domains accepted in replay URLs (subdomains included).
+	Domains []string
+
+	// PageURL is the URL template of a replay page by ID.
+	PageURL string
+
+	limiter *rateLimiter
+}
+
+// replayHosts are the built-in connectors. Additional direct-download domains
+// can be allowed with FETCH_ALLOWED_HOSTS (comma separated), e.g. a team storage.
+var replayHosts = []*replayHost{
+	{
+		Name:    "repmastered",
+		Domains: []string{"repmastered.app"},
+		PageURL: "https://repmastered.app/game/%s",
+		limiter: newRateLimiter(1, 5),
+	},
+}
+
+func init() {
+	for _, d := range strings.Split(os.Getenv("FETCH_ALLOWED_HOSTS"), ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			replayHosts = append(replayHosts, &replayHost{Name: d, Domains: []string{d}, limiter: newRateLimiter(2, 10)})
+		}
+	}
+}
+
+func hostByName(name string) *replayHost {
+	for _, h := range replayHosts {
+		if h.Name == name {
+			return h
+		}
+	}
+	return nil
+}
+
+func hostByURL(u *url.URL) *replayHost {
+	host := strings.ToLower(u.Hostname())
+	for _, h := range replayHosts {
+		for _, d := range h.Domains {
+			if host == d || strings.HasSuffix(host, "."+d) {
+				return h
+			}
+		}
+	}
+	return nil
+}
+
+// rateLimiter is a token bucket.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // Tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate, burst float64) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// allow takes a token if one is available, or else returns how long until
+// one is.
+func (l *rateLimiter) allow() (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// wait blocks until a token is available or ctx is done.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	for {
+		ok, delay := l.allow()
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+var fetchClient = &http.Client{
+	Timeout: 30 * time.Second,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+		if req.URL.Scheme != "https" {
+			return errors.New("redirect to non-https URL")
+		}
+		// Like page links, redirects must stay on the host, an open redirect
+		// must not reach other or internal URLs
+		if h := hostByURL(req.URL); h == nil || h != hostByURL(via[0].URL) {
+			return errors.New("redirect to another host")
+		}
+		return nil
+	},
+}
+
+// replayLinkRe matches links to replay files or downloads in a replay page.
+var replayLinkRe = regexp.MustCompile(`href="([^"]+(?:\.rep|/download[^"]*))"`)
+
+// fetchReplay downloads the replay at u. If u is a replay page of the host,
+// the replay download link is looked up in the page.
+func fetchReplay(ctx context.Context, h *replayHost, u *url.URL) ([]byte, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		if err := h.limiter.wait(ctx); err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", "replay-mastery-forge/1.0")
+		resp, err := fetchClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxReplaySize+1))
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s responded %s", h.Name, resp.Status)
+		}
+		if len(data) > maxReplaySize {
+			return nil, errors.New("replay too large")
+		}
+		if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+			return data, nil
+		}
+		if attempt > 0 {
+			break
+		}
+
+		m := replayLinkRe.FindSubmatch(data)
+		if m == nil {
+			return nil, errors.New("no replay download link found on page")
+		}
+		link, err := u.Parse(string(m[1]))
+		if err != nil {
+			return nil, err
+		}
+		if hostByURL(link) != h {
+			return nil, errors.New("replay download link points to another host")
+		}
+		if link.Scheme != "https" {
+			return nil, errors.New("replay download link isn't https")
+		}
+		u = link
+	}
+	return nil, errors.New("replay download link did not return a replay")
+}
+
+type FetchRequest struct {
+	// URL of a replay file or replay page.
+	URL string `json:"url,omitempty"`
+
+	// Host connector name and replay ID, alternatively to URL.
+	Host string `json:"host,omitempty"`
+	ID   string `json:"id,omitempty"`
+}
+
+// fetchHandler fetches a replay from a community replay host and parses it.
+func fetchHandler(w http.ResponseWriter, r *http.Request) {
+	var req FetchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	clock, ok := clockParam(w, r)
+	if !ok {
+		return
+	}
+
+	var h *replayHost
+	var u *url.URL
+	var err error
+	switch {
+	case req.URL != "":
+		if u, err = url.Parse(req.URL); err != nil || u.Scheme != "https" {
+			http.Error(w, "Invalid url, must be https", http.StatusBadRequest)
+			return
+		}
+		h = hostByURL(u)
+	case req.Host != "" && req.ID != "":
+		if h = hostByName(req.Host); h != nil && h.PageURL != "" {
+			u, err = url.Parse(fmt.Sprintf(h.PageURL, url.PathEscape(req.ID)))
+		}
+	default:
+		http.Error(w, "Either url or host and id must be provided", http.StatusBadRequest)
+		return
+	}
+	if h == nil || u == nil || err != nil {
+		http.Error(w, "Unsupported replay host", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+	data, err := fetchReplay(ctx, h, u)
+	if err != nil {
+		log.Printf("Error fetching %s: %v", u, err)
+		http.Error(w, "Fetch error: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	res, err := parseReplay(bytes.NewReader(data))
+	if err != nil {
+		http.Error(w, "Parse error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer res.release()
+	writeJSON(w, res.inClock(clock))
+}
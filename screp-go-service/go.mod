@@ -5,4 +5,6 @@ go 1.19
 require (
 	github.com/icza/screp v1.12.11
 	github.com/gorilla/mux v1.8.1
-)
\ No newline at end of file
+	golang.org/x/net v0.17.0
+	golang.org/x/text v0.13.0
+)
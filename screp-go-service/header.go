@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/icza/screp/repparser"
+)
+
+// HeaderResult holds the replay header only, for list views and triage.
+type HeaderResult struct {
+	MapName          string         `json:"mapName"`
+	CanonicalMapName string         `json:"canonicalMapName"`
+	Frames           int            `json:"frames"`
+	DurationSeconds  float32        `json:"durationSeconds"`
+	StartTime        time.Time      `json:"startTime"`
+	Players          []HeaderPlayer `json:"players"`
+}
+
+type HeaderPlayer struct {
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	Slug         string `json:"slug"`
+	NameEncoding string `json:"nameEncoding"`
+	Race         string `json:"race"`
+	Team         int    `json:"team"`
+}
+
+// parseReplayHeader decodes only the header section, skipping commands and map data.
+func parseReplayHeader(data []byte) (*HeaderResult, error) {
+	defer acquireParseSlot()()
+
+	rp, err := repparser.ParseSections(data, false, false)
+	if err != nil {
+		return nil, err
+	}
+	normalizeNames(rp)
+
+	res := &HeaderResult{
+		MapName:          rp.Header.MapName,
+		CanonicalMapName: canonicalMapName(rp.Header.MapName),
+		Frames:           int(rp.Header.Frames),
+		DurationSeconds:  float32(rp.Header.Frames) / 23.81,
+		StartTime:        rp.Header.StartTime,
+		Players:          make([]HeaderPlayer, len(rp.Header.Players)),
+	}
+	slugs := playerSlugs(rp.Header.Players)
+	for i, p := range rp.Header.Players {
+		_, encoding := decodeName(p.RawName)
+		res.Players[i] = HeaderPlayer{ID: i, Name: p.Name, Slug: slugs[i], NameEncoding: encoding, Race: p.Race.String(), Team: int(p.Team)}
+	}
+	return res, nil
+}
+
+func parseHeaderHandler(w http.ResponseWriter, r *http.Request) {
+	file, _, err := r.FormFile("replay")
+	if err != nil {
+		http.Error(w, "Missing replay file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if _, err := buf.ReadFrom(io.LimitReader(file, maxReplaySize)); err != nil {
+		log.Printf("Error reading replay: %v", err)
+		http.Error(w, "Failed to read replay", http.StatusBadRequest)
+		return
+	}
+
+	res, err := parseReplayHeader(buf.Bytes())
+	if err != nil {
+		http.Error(w, "Parse error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, res)
+}
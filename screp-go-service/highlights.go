@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcmd"
+)
+
+// Highlight kinds, rare high-impact events
+const (
+	HighlightNuke        = "nuke_launch"
+	HighlightRecall      = "recall"
+	HighlightMindControl = "mind_control"
+	HighlightInfestation = "infestation"
+	HighlightInfestedCC  = "infested_cc"
+)
+
+// infestedTerranID is the unit ID of the Infested Terran, only trainable from
+// an infested command center.
+const infestedTerranID = 0x32
+
+// Order IDs of the casts repcmd has no constants for
+const (
+	orderIDCastInfestation = 0x1b
+	orderIDCastMindControl = 0xb6
+)
+
+// detectHighlights extracts the rare high-impact events casters want
+// surfaced: nuke launches, recalls, mind controls and command center
+// infestations. An infested command center is reported when its owner first
+// trains an infested terran, in case the infestation itself was a right click.
+func detectHighlights(rp *rep.Replay) []Event {
+	events := []Event{}
+	infestedCC := map[int]bool{}
+	for _, cmd := range rp.Commands {
+		base := cmd.BaseCmd()
+		if base == nil || int(base.PlayerID) >= len(rp.Header.Players) {
+			continue
+		}
+		name := rp.Header.Players[base.PlayerID].Name
+		e := Event{PlayerID: int(base.PlayerID), Frame: int(base.Frame), Time: frameToSeconds(base.Frame)}
+
+		switch c := cmd.(type) {
+		case *repcmd.TargetedOrderCmd:
+			if c.Order == nil {
+				continue
+			}
+			e.X, e.Y = int(c.Pos.X), int(c.Pos.Y)
+			switch c.Order.ID {
+			case repcmd.OrderIDNukeLaunch:
+				e.Kind, e.Description = HighlightNuke, fmt.Sprintf("%s launches a nuke", name)
+			case repcmd.OrderIDCastRecall:
+				e.Kind, e.Description = HighlightRecall, fmt.Sprintf("%s recalls", name)
+			case orderIDCastMindControl:
+				e.Kind, e.Description = HighlightMindControl, fmt.Sprintf("%s mind controls %s", name, targetName(c.Unit))
+			case orderIDCastInfestation:
+				e.Kind, e.Description = HighlightInfestation, fmt.Sprintf("%s infests %s", name, targetName(c.Unit))
+				infestedCC[e.PlayerID] = true
+			default:
+				continue
+			}
+		case *repcmd.TrainCmd:
+			if c.Unit == nil || c.Unit.ID != infestedTerranID || infestedCC[e.PlayerID] {
+				continue
+			}
+			infestedCC[e.PlayerID] = true
+			e.Kind, e.Description = HighlightInfestedCC, fmt.Sprintf("%s trains infested terrans from an infested command center", name)
+		default:
+			continue
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// targetName names the targeted unit, "a unit" if unknown.
+func targetName(u *repcmd.Unit) string {
+	if u == nil || u.ID == repcmd.UnitIDNone {
+		return "a unit"
+	}
+	return "a " + u.String()
+}
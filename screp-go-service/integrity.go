@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcore"
+)
+
+// Integrity verdicts, by score
+const (
+	VerdictClean      = "clean"
+	VerdictReview     = "review"
+	VerdictSuspicious = "suspicious"
+)
+
+// Diagnostic kinds of the replay structure and action-rate checks
+const (
+	DiagUnknownPlayer  = "unknown_player"
+	DiagOutOfOrder     = "frames_out_of_order"
+	DiagAfterEnd       = "commands_after_end"
+	DiagNoCommands     = "no_commands"
+	DiagSilentPlayer   = "silent_player"
+	DiagShortGame      = "short_game"
+	DiagImplausibleAPM = "implausible_apm"
+)
+
+// Thresholds and weights of the integrity score
+const (
+	minGameSeconds   = 60
+	maxPlausibleAPM  = 500 // Whole game average
+	maxPlausibleEAPM = 400
+
+	penaltyAnomaly   = 25
+	penaltySuspicion = 15
+	penaltyError     = 30
+	penaltyWarning   = 10
+
+	verdictCleanScore  = 80
+	verdictReviewScore = 50
+)
+
+// Diagnostic is a finding of the replay structure or action-rate checks.
+// Errors hint at a tampered or corrupt replay, warnings only at an unusual game.
+type Diagnostic struct {
+	Kind        string `json:"kind"`
+	Severity    string `json:"severity"` // "error" or "warning"
+	PlayerID    *int   `json:"playerId,omitempty"`
+	Description string `json:"description"`
+}
+
+// IntegrityReport is the screening result of one replay. Score is 100 for a
+// replay without findings and drops with each.
+type IntegrityReport struct {
+	Name        string       `json:"name,omitempty"`
+	Score       int          `json:"score"`
+	Verdict     string       `json:"verdict"`
+	Anomalies   []Anomaly    `json:"anomalies"`
+	Suspicions  []Suspicion  `json:"suspicions"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// checkIntegrity runs all integrity heuristics on the replay.
+func checkIntegrity(rp *rep.Replay) *IntegrityReport {
+	res := &IntegrityReport{
+		Anomalies:   detectAnomalies(rp),
+		Suspicions:  detectMapHack(rp),
+		Diagnostics: diagnoseReplay(rp),
+	}
+	if res.Anomalies == nil {
+		res.Anomalies = []Anomaly{}
+	}
+	if res.Suspicions == nil {
+		res.Suspicions = []Suspicion{}
+	}
+
+	score := 100 - penaltyAnomaly*len(res.Anomalies) - penaltySuspicion*len(res.Suspicions)
+	for _, d := range res.Diagnostics {
+		if d.Severity == "error" {
+			score -= penaltyError
+		} else {
+			score -= penaltyWarning
+		}
+	}
+	if score < 0 {
+		score = 0
+	}
+	res.Score = score
+	switch {
+	case score >= verdictCleanScore:
+		res.Verdict = VerdictClean
+	case score >= verdictReviewScore:
+		res.Verdict = VerdictReview
+	default:
+		res.Verdict = VerdictSuspicious
+	}
+	return res
+}
+
+// diagnoseReplay checks the command stream against the header and the
+// players' overall action rates.
+func diagnoseReplay(rp *rep.Replay) []Diagnostic {
+	diags := []Diagnostic{}
+	add := func(kind, severity string, pid *int, format string, args ...interface{}) {
+		diags = append(diags, Diagnostic{Kind: kind, Severity: severity, PlayerID: pid, Description: fmt.Sprintf(format, args...)})
+	}
+
+	players := len(rp.Header.Players)
+	counts := make([]int, players)
+	unknown := map[int]int{} // Commands by slot of slots not in the header
+	var unknownSlots []int
+	var prev, afterEnd, outOfOrder repcore.Frame
+	nAfterEnd, nOutOfOrder := 0, 0
+	for _, cmd := range rp.Commands {
+		base := cmd.BaseCmd()
+		if base == nil {
+			continue
+		}
+		if base.Frame < prev {
+			if nOutOfOrder == 0 {
+				outOfOrder = base.Frame
+			}
+			nOutOfOrder++
+		}
+		prev = base.Frame
+		if base.Frame > rp.Header.Frames {
+			if nAfterEnd == 0 {
+				afterEnd = base.Frame
+			}
+			nAfterEnd++
+		}
+		if pid := int(base.PlayerID); pid < players {
+			counts[pid]++
+		} else if slot, ok := unknownSlot(pid); ok { // Observers' commands are fine
+			if unknown[slot] == 0 {
+				unknownSlots = append(unknownSlots, slot)
+			}
+			unknown[slot]++
+		}
+	}
+
+	if len(rp.Commands) == 0 {
+		add(DiagNoCommands, "error", nil, "replay has no commands")
+	}
+	if nOutOfOrder > 0 {
+		add(DiagOutOfOrder, "error", nil, "%d commands go back in time, first at frame %d", nOutOfOrder, outOfOrder)
+	}
+	if nAfterEnd > 0 {
+		add(DiagAfterEnd, "error", nil, "%d commands after the last frame %d, first at frame %d", nAfterEnd, rp.Header.Frames, afterEnd)
+	}
+	for _, slot := range unknownSlots {
+		if slot < 0 {
+			add(DiagUnknownPlayer, "error", nil, "%d commands from invalid player IDs", unknown[slot])
+		} else {
+			add(DiagUnknownPlayer, "error", nil, "%d commands from slot %d, which is not in the header", unknown[slot], slot)
+		}
+	}
+	if secs := frameToSeconds(rp.Header.Frames); secs < minGameSeconds {
+		add(DiagShortGame, "warning", nil, "game lasted only %.0fs", secs)
+	}
+
+	for pid := 0; pid < players; pid++ {
+		pid := pid
+		if counts[pid] == 0 && len(rp.Commands) > 0 {
+			add(DiagSilentPlayer, "warning", &pid, "%s issued no commands", rp.Header.Players[pid].Name)
+			continue
+		}
+		if apm, eapm := calculateAPM(rp, pid), calculateEAPM(rp, pid); apm > maxPlausibleAPM || eapm > maxPlausibleEAPM {
+			add(DiagImplausibleAPM, "warning", &pid, "%s averaged %d APM (%d EAPM) over the whole game", rp.Header.Players[pid].Name, apm, eapm)
+		}
+	}
+	return diags
+}
+
+// integrityHandler screens all replays of a multipart upload ("replay" files
+// and/or "archive" zip files) and responds with a report per replay.
+func integrityHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(64 << 20); err != nil {
+		http.Error(w, "Invalid multipart form", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	files, err := readBatchFiles(r.MultipartForm)
+	if err != nil {
+		http.Error(w, "Invalid batch: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(files) == 0 {
+		http.Error(w, "Missing replay file", http.StatusBadRequest)
+		return
+	}
+
+	reports := make([]*IntegrityReport, len(files))
+	runPool(len(files), func(i int) {
+		rp, err := decodeReplay(bytes.NewReader(files[i].data))
+		if err != nil {
+			reports[i] = &IntegrityReport{Verdict: VerdictSuspicious, Error: err.Error()}
+		} else {
+			reports[i] = checkIntegrity(rp)
+		}
+		reports[i].Name = files[i].name
+	})
+	writeJSON(w, reports)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Job statuses
+const (
+	JobAwaitingUpload = "awaiting_upload"
+	JobProcessing     = "processing"
+	JobDone           = "done"
+	JobFailed         = "failed"
+)
+
+// jobTTL is how long finished and abandoned jobs are kept in memory.
+const jobTTL = 24 * time.Hour
+
+// jobExpiryInterval is how often expired jobs are deleted.
+const jobExpiryInterval = 10 * time.Minute
+
+type Job struct {
+	ID         string          `json:"id"`
+	Status     string          `json:"status"`
+	Error      string          `json:"error,omitempty"`
+	Result     *ReplayResult   `json:"result,omitempty"`
+	ReplayHash string          `json:"replayHash,omitempty"` // Storage key, if the replay is stored
+	Owner      string          `json:"owner,omitempty"`
+	Batch      []BatchItem     `json:"batch,omitempty"`
+	Openings   *OpeningsReport `json:"openings,omitempty"` // Result of an openings analysis
+	Warnings   []string        `json:"warnings,omitempty"` // Degraded dependencies, e.g. replay_not_stored
+	CreatedAt  time.Time       `json:"createdAt"`
+	UpdatedAt  time.Time       `json:"updatedAt"`
+
+	upload string // Key of the presigned upload in object storage, until deleted
+}
+
+// addWarning adds a warning unless it's "" or already there.
+func (j *Job) addWarning(warning string) {
+	if warning == "" {
+		return
+	}
+	for _, w := range j.Warnings {
+		if w == warning {
+			return
+		}
+	}
+	j.Warnings = append(j.Warnings, warning)
+}
+
+// jobStore keeps the jobs in memory.
+type jobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+var jobs = &jobStore{jobs: map[string]*Job{}}
+
+// create registers a new job with the given status. owner is an opaque ID
+// of the user the job belongs to, "" if unknown.
+func (s *jobStore) create(status, owner string) Job {
+	now := time.Now()
+	j := &Job{ID: newID(), Status: status, Owner: owner, CreatedAt: now, UpdatedAt: now}
+	s.mu.Lock()
+	s.jobs[j.ID] = j
+	s.mu.Unlock()
+	return *j
+}
+
+// expired removes the jobs not updated for longer than the TTL and returns
+// them.
+func (s *jobStore) expired() []*Job {
+	now := time.Now()
+	return s.remove(func(j *Job) bool { return now.Sub(j.UpdatedAt) > jobTTL })
+}
+
+// get returns a copy of the job.
+func (s *jobStore) get(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+// update calls fn with the job under lock and returns a copy of the result.
+// fn may return false to leave the job unchanged.
+func (s *jobStore) update(id string, fn func(j *Job) bool) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	if fn(j) {
+		j.UpdatedAt = time.Now()
+	}
+	return *j, true
+}
+
+// remove deletes the jobs matching fn and returns them.
+func (s *jobStore) remove(fn func(j *Job) bool) []*Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var gone []*Job
+	for id, j := range s.jobs {
+		if fn(j) {
+			delete(s.jobs, id)
+			gone = append(gone, j)
+		}
+	}
+	return gone
+}
+
+// ownerHeader carries the owner of new jobs, for deleting a user's data.
+const ownerHeader = "X-Owner-ID"
+
+// newID returns a random, unguessable identifier.
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
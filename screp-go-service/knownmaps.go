@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/icza/screp/rep"
+)
+
+// KnownMap is an entry of the known competitive map database.
+type KnownMap struct {
+	Hash   string `json:"hash"`
+	Name   string `json:"name"`
+	Season string `json:"season,omitempty"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// knownMapsJSON is the bundled map database. Entries are keyed by mapHash,
+// take the hash from the mapHash field of a parse result of the map.
+//
+//go:embed knownmaps.json
+var knownMapsJSON []byte
+
+// knownMaps maps the map hashes to the known maps: the bundled ones and those
+// of the JSON file at KNOWN_MAPS_FILE, which take precedence.
+var knownMaps = map[string]KnownMap{}
+
+func init() {
+	loadKnownMaps("bundled", knownMapsJSON)
+	if path := os.Getenv("KNOWN_MAPS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Error reading known maps: %v", err)
+			return
+		}
+		loadKnownMaps(path, data)
+	}
+}
+
+func loadKnownMaps(source string, data []byte) {
+	var maps []KnownMap
+	if err := json.Unmarshal(data, &maps); err != nil {
+		log.Printf("Error loading known maps from %s: %v", source, err)
+		return
+	}
+	for _, m := range maps {
+		knownMaps[m.Hash] = m
+	}
+}
+
+// mapHash hashes the playable content of the embedded map: dimensions,
+// tileset, tiles, start locations and resources. Names, descriptions and
+// triggers don't count, so renamed and re-tagged copies of a map match.
+// Returns "" if the replay has no map data.
+func mapHash(rp *rep.Replay) string {
+	md := rp.MapData
+	if md == nil || len(md.Tiles) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	write := func(v interface{}) { binary.Write(h, binary.LittleEndian, v) }
+	write([]uint16{rp.Header.MapWidth, rp.Header.MapHeight})
+	if md.TileSet != nil {
+		write(md.TileSet.ID)
+	}
+	write(md.Tiles)
+	for _, sl := range md.StartLocations {
+		write([]uint16{sl.X, sl.Y})
+	}
+	for _, r := range md.MineralFields {
+		write([]uint16{r.X, r.Y})
+	}
+	for _, r := range md.Geysers {
+		write([]uint16{r.X, r.Y})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// lookupKnownMap returns the known map of the hash, nil if it's unknown.
+func lookupKnownMap(hash string) *KnownMap {
+	if m, ok := knownMaps[hash]; ok && hash != "" {
+		return &m
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// maxParseQueue is how many parses may wait for a slot before requests are
+// shed. It's PARSE_QUEUE, default 4 per slot.
+var maxParseQueue = parseQueueLimit()
+
+func parseQueueLimit() int64 {
+	if n, err := strconv.Atoi(os.Getenv("PARSE_QUEUE")); err == nil && n >= 0 {
+		return int64(n)
+	}
+	return 4 * int64(cap(parseSlots))
+}
+
+var (
+	parseWaiting atomic.Int64 // Parses waiting for a slot
+	parseAverage atomic.Int64 // Moving average of the parse duration, in ns
+)
+
+// acquireParseSlot waits for a parse slot; the returned func releases it.
+func acquireParseSlot() func() {
+	parseWaiting.Add(1)
+	parseSlots <- struct{}{}
+	parseWaiting.Add(-1)
+
+	start := time.Now()
+	return func() {
+		<-parseSlots
+		d := int64(time.Since(start))
+		for {
+			avg := parseAverage.Load()
+			next := d
+			if avg > 0 {
+				next = avg + (d-avg)/10
+			}
+			if parseAverage.CompareAndSwap(avg, next) {
+				return
+			}
+		}
+	}
+}
+
+// retryAfter estimates when a slot frees up for a new parse, in seconds.
+func retryAfter(queued int64) int {
+	avg := time.Duration(parseAverage.Load())
+	if avg == 0 {
+		avg = time.Second
+	}
+	wait := float64(queued+1) * avg.Seconds() / float64(cap(parseSlots))
+	return int(math.Max(1, math.Ceil(wait)))
+}
+
+// shedLoad wraps a handler that parses, responding 429 right away if the
+// parse queue is full instead of queuing the request too, so clients back
+// off before uploading. X-Queue-Depth tells how many parses are waiting.
+func shedLoad(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if queued := parseWaiting.Load(); queued >= maxParseQueue {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter(queued)))
+			w.Header().Set("X-Queue-Depth", strconv.FormatInt(queued, 10))
+			w.Header().Set("X-Queue-Limit", strconv.FormatInt(maxParseQueue, 10))
+			http.Error(w, "Server busy, retry later", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
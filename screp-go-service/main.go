@@ -1,21 +1,45 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcmd"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 type PlayerInfo struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
-	Race string `json:"race"`
-	APM  int    `json:"apm"`
-	EAPM int    `json:"eapm"`
+	ID           int    `json:"id"`
+	Name         string `json:"name"`         // Decoded and normalized, see decodeName
+	Slug         string `json:"slug"`         // ASCII form of the name for filenames and URLs
+	NameEncoding string `json:"nameEncoding"` // Encoding of the name in the replay
+	Race         string `json:"race"`
+	Team         int    `json:"team"`
+	APM          int    `json:"apm"`
+	EAPM         int    `json:"eapm"`
+
+	Hotkeys       HotkeyUsage        `json:"hotkeys"`
+	Production    []FacilityUsage    `json:"production"`
+	UnitsOrdered  map[string]int     `json:"unitsOrdered"`
+	WorkerPulls   []WorkerPull       `json:"workerPulls"`
+	Positioning   Positioning        `json:"positioning"`
+	StaticDefense StaticDefense      `json:"staticDefense"`
+	Upgrades      []UpgradeBenchmark `json:"upgrades"`
+	Spells        SpellStats         `json:"spells"`
+	Milestones    Milestones         `json:"milestones"`
+	Scouting      Scouting           `json:"scouting"`
+	RaceStats     RaceStats          `json:"raceStats"` // Section of the player's race
 }
 
 type Command struct {
@@ -24,6 +48,7 @@ type Command struct {
 	Time        float64 `json:"time"`
 	CommandType string  `json:"commandType"`
 	AbilityName string  `json:"abilityName"`
+	Target      string  `json:"target,omitempty"` // Unit, tech, upgrade or order
 }
 
 type BuildOrder struct {
@@ -32,20 +57,35 @@ type BuildOrder struct {
 }
 
 type ReplayResult struct {
-	MapName         string        `json:"mapName"`
-	DurationSeconds float32       `json:"durationSeconds"`
-	Players         []PlayerInfo  `json:"players"`
-	BuildOrders     []BuildOrder  `json:"buildOrders"`
-	Actions         []Command     `json:"actions"`
+	MapName          string       `json:"mapName"`
+	CanonicalMapName string       `json:"canonicalMapName"`
+	MapHash          string       `json:"mapHash,omitempty"`
+	Clock            string       `json:"clock"` // Clock of the times, real or game
+	KnownMap         *KnownMap    `json:"knownMap,omitempty"`
+	DurationSeconds  float32      `json:"durationSeconds"`
+	WinnerTeam       int          `json:"winnerTeam,omitempty"` // 0 if unknown
+	Players          []PlayerInfo `json:"players"`
+	BuildOrders      []BuildOrder `json:"buildOrders"`
+	Anomalies        []Anomaly    `json:"anomalies,omitempty"`
+	Suspicions       []Suspicion  `json:"suspicions,omitempty"`
+	Events           []Event      `json:"events,omitempty"`
+	Engagements      []Engagement `json:"engagements,omitempty"`
+	Highlights       []Event      `json:"highlights,omitempty"`
+	Actions          []Command    `json:"actions,omitempty"`
 }
 
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE, PATCH, HEAD")
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key, X-Owner-ID, "+
+			"Tus-Resumable, Upload-Length, Upload-Metadata, Upload-Offset")
+		w.Header().Set("Access-Control-Expose-Headers", "Location, Retry-After, X-Queue-Depth, X-Queue-Limit, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Length, Upload-Offset")
 
 		if r.Method == "OPTIONS" {
+			if strings.HasPrefix(r.URL.Path, "/tus") {
+				tusOptions(w)
+			}
 			return
 		}
 
@@ -66,63 +106,157 @@ func parseHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	rp, err := rep.ParseReplay(file)
+	clock, ok := clockParam(w, r)
+	if !ok {
+		return
+	}
+
+	rp, err := decodeReplay(file)
 	if err != nil {
 		http.Error(w, "Parse error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// The full action list can be tens of MB, so actions are streamed
+	// straight from the replay instead of being collected first
+	if err := streamReplayResult(w, buildResult(rp, false).inClock(clock), rp); err != nil {
+		log.Printf("Error streaming response: %v", err)
+	}
+}
+
+// decodeReplay parses the replay read from r, with the command player IDs
+// remapped to player indices and the player names normalized. It waits for a
+// free slot of the global concurrency limit.
+func decodeReplay(r io.Reader) (*rep.Replay, error) {
+	defer acquireParseSlot()()
+
+	rp, err := rep.ParseReplay(r)
+	if err != nil {
+		return nil, err
+	}
+	remapPlayerIDs(rp)
+	normalizeNames(rp)
+	return rp, nil
+}
+
+// parseReplay parses the replay read from r and extracts the result.
+func parseReplay(r io.Reader) (*ReplayResult, error) {
+	rp, err := decodeReplay(r)
+	if err != nil {
+		return nil, err
+	}
+	return buildResult(rp, true), nil
+}
+
+// buildResult extracts the result from the replay. Actions are only
+// included if withActions is true.
+func buildResult(rp *rep.Replay, withActions bool) *ReplayResult {
 	mapName := rp.Header.MapName
 	duration := float32(rp.Header.Frames) / 23.81 // Convert frames to seconds
 
+	engagements := detectEngagements(rp)
+	hash := mapHash(rp)
+
 	// Extract players
 	players := make([]PlayerInfo, len(rp.Header.Players))
+	hotkeys := hotkeyUsage(rp)
+	production := productionUsage(rp)
+	units := unitsOrdered(rp)
+	pulls := workerPulls(rp)
+	positions := positioning(rp)
+	defense := staticDefense(rp)
+	upgrades := upgradeBenchmarks(rp)
+	spells := spellStats(rp, engagements)
+	marks := milestones(rp)
+	scouts := scouting(rp)
+	races := raceStats(rp)
+	slugs := playerSlugs(rp.Header.Players)
 	for i, p := range rp.Header.Players {
+		_, encoding := decodeName(p.RawName)
 		players[i] = PlayerInfo{
-			ID:   i,
-			Name: p.Name,
-			Race: p.Race.String(),
-			APM:  calculateAPM(rp, i),
-			EAPM: calculateEAPM(rp, i),
+			ID:            i,
+			Name:          p.Name,
+			Slug:          slugs[i],
+			NameEncoding:  encoding,
+			Race:          p.Race.String(),
+			Team:          int(p.Team),
+			APM:           calculateAPM(rp, i),
+			EAPM:          calculateEAPM(rp, i),
+			Hotkeys:       hotkeys[i],
+			Production:    production[i],
+			UnitsOrdered:  units[i],
+			WorkerPulls:   pulls[i],
+			Positioning:   positions[i],
+			StaticDefense: defense[i],
+			Upgrades:      upgrades[i],
+			Spells:        spells[i],
+			Milestones:    marks[i],
+			Scouting:      scouts[i],
+			RaceStats:     races[i],
 		}
 	}
 
 	// Extract all commands/actions
 	var actions []Command
-	for _, cmd := range rp.Commands {
-		if cmd.BaseCmd() != nil {
-			actions = append(actions, Command{
-				PlayerID:    int(cmd.BaseCmd().PlayerID),
-				Frame:       int(cmd.BaseCmd().Frame),
-				Time:        float64(cmd.BaseCmd().Frame) / 23.81,
-				CommandType: cmd.BaseCmd().Type.String(),
-				AbilityName: getAbilityName(cmd),
-			})
+	if withActions {
+		actions = getCommandSlice(len(rp.Commands))
+		for _, cmd := range rp.Commands {
+			if cmd.BaseCmd() != nil {
+				actions = append(actions, commandOf(cmd))
+			}
 		}
 	}
 
 	// Extract build orders (Train + Build commands)
 	buildOrders := make([]BuildOrder, len(players))
 	for i, p := range players {
-		var seq []Command
-		for _, a := range actions {
-			if a.PlayerID == p.ID && (a.CommandType == "Train" || a.CommandType == "Build") {
-				seq = append(seq, a)
-			}
+		buildOrders[i].PlayerID = p.ID
+	}
+	for _, cmd := range rp.Commands {
+		base := cmd.BaseCmd()
+		if base == nil || int(base.PlayerID) >= len(buildOrders) {
+			continue
+		}
+		if t := base.Type.String(); t == "Train" || t == "Build" {
+			buildOrders[base.PlayerID].Sequence = append(buildOrders[base.PlayerID].Sequence, commandOf(cmd))
 		}
-		buildOrders[i] = BuildOrder{PlayerID: p.ID, Sequence: seq}
 	}
 
-	res := ReplayResult{
-		MapName:         mapName,
-		DurationSeconds: duration,
-		Players:         players,
-		BuildOrders:     buildOrders,
-		Actions:         actions,
+	// Winner detection ("largest remaining team wins") is done by screp
+	rp.Compute()
+	winnerTeam := 0
+	if rp.Computed != nil {
+		winnerTeam = int(rp.Computed.WinnerTeam)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(res)
+	return &ReplayResult{
+		MapName:          mapName,
+		CanonicalMapName: canonicalMapName(mapName),
+		MapHash:          hash,
+		Clock:            ClockReal,
+		KnownMap:         lookupKnownMap(hash),
+		DurationSeconds:  duration,
+		WinnerTeam:       winnerTeam,
+		Players:          players,
+		BuildOrders:      buildOrders,
+		Anomalies:        detectAnomalies(rp),
+		Suspicions:       detectMapHack(rp),
+		Events:           detectEvents(rp),
+		Engagements:      engagements,
+		Highlights:       detectHighlights(rp),
+		Actions:          actions,
+	}
+}
+
+func commandOf(cmd rep.Cmd) Command {
+	return Command{
+		PlayerID:    int(cmd.BaseCmd().PlayerID),
+		Frame:       int(cmd.BaseCmd().Frame),
+		Time:        float64(cmd.BaseCmd().Frame) / 23.81,
+		CommandType: cmd.BaseCmd().Type.String(),
+		AbilityName: getAbilityName(cmd),
+		Target:      getTarget(cmd),
+	}
 }
 
 func calculateAPM(rp *rep.Replay, playerID int) int {
@@ -166,25 +300,155 @@ func getAbilityName(cmd rep.Cmd) string {
 	return cmd.BaseCmd().Type.String()
 }
 
+// getTarget names the unit, tech, upgrade or order of the command, or returns
+// "" if it carries none.
+func getTarget(cmd rep.Cmd) string {
+	switch c := cmd.(type) {
+	case *repcmd.BuildCmd:
+		return c.Unit.String()
+	case *repcmd.TrainCmd:
+		return c.Unit.String()
+	case *repcmd.BuildingMorphCmd:
+		return c.Unit.String()
+	case *repcmd.TechCmd:
+		return c.Tech.String()
+	case *repcmd.UpgradeCmd:
+		return c.Upgrade.String()
+	case *repcmd.TargetedOrderCmd:
+		return c.Order.String()
+	}
+	return ""
+}
+
+// writeJSON encodes v as the response body, defaulting the content type to JSON.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+// writeJSONStatus is like writeJSON with a custom status code.
+func writeJSONStatus(w http.ResponseWriter, status int, v interface{}) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		log.Printf("Error encoding response: %v", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
 func healthHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
 
 func main() {
+	watchDir := flag.String("watch", "", "watch `dir` for new replays and parse them instead of serving HTTP")
+	postURL := flag.String("post", "", "with -watch, POST results to `url` instead of writing JSON next to the replays")
+	interval := flag.Duration("interval", 2*time.Second, "with -watch, directory poll interval")
+	flag.Parse()
+
+	if *watchDir != "" {
+		log.Fatal(runWatcher(*watchDir, *postURL, *interval))
+	}
+
 	r := mux.NewRouter()
-	
+
 	// Apply CORS middleware
 	r.Use(corsMiddleware)
-	
-	r.HandleFunc("/parse", parseHandler).Methods("POST", "OPTIONS")
+	r.Use(auditMiddleware)
+	r.Use(apiKeyMiddleware)
+	r.Use(clockMiddleware)
+	r.Use(compressMiddleware)
+
+	r.HandleFunc("/parse", shedLoad(parseHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/parse/batch", shedLoad(parseBatchHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/analysis/openings", shedLoad(openingsHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/analysis/similar", shedLoad(similarHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/search", shedLoad(searchHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/parse/header", shedLoad(parseHeaderHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/export/chapters", shedLoad(chaptersHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/export/subtitles", shedLoad(subtitlesHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/integrity", shedLoad(integrityHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/overlay", shedLoad(overlayHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/overlay/{id}", jobOverlayHandler).Methods("GET")
+	r.HandleFunc("/embed", shedLoad(embedHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/embed/{id}", jobEmbedHandler).Methods("GET")
+	r.HandleFunc("/features", shedLoad(featuresHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/features", featureSpecsHandler).Methods("GET")
+	r.HandleFunc("/features/{id}", jobFeaturesHandler).Methods("GET")
+	r.HandleFunc("/apm", shedLoad(apmHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/apm/{id}", jobAPMHandler).Methods("GET")
+	r.HandleFunc("/seek/{id}", seekIndexHandler).Methods("GET")
+	r.HandleFunc("/seek/{id}/actions", seekActionsHandler).Methods("GET")
+	r.HandleFunc("/datasets", shedLoad(datasetHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/fetch", shedLoad(fetchHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/uploads", createUploadHandler).Methods("POST", "OPTIONS")
+	r.HandleFunc("/tus", shedLoad(tusRequest(tusCreateHandler))).Methods("POST", "OPTIONS")
+	r.HandleFunc("/tus/{id}", tusRequest(tusHeadHandler)).Methods("HEAD")
+	r.HandleFunc("/tus/{id}", shedLoad(tusRequest(tusPatchHandler))).Methods("PATCH", "OPTIONS")
+	r.HandleFunc("/tus/{id}", tusRequest(tusDeleteHandler)).Methods("DELETE")
+	r.HandleFunc("/jobs/{id}", getJobHandler).Methods("GET")
+	r.HandleFunc("/jobs/{id}", deleteJobHandler).Methods("DELETE")
+	r.HandleFunc("/owners/{owner}", requireAdmin(deleteOwnerHandler)).Methods("DELETE")
+	r.HandleFunc("/admin/audit", requireAdmin(auditHandler)).Methods("GET")
+	r.HandleFunc("/admin/keys", requireAdmin(createKeyHandler)).Methods("POST")
+	r.HandleFunc("/admin/keys", requireAdmin(listKeysHandler)).Methods("GET")
+	r.HandleFunc("/admin/keys/{id}", requireAdmin(getKeyHandler)).Methods("GET")
+	r.HandleFunc("/admin/keys/{id}", requireAdmin(updateKeyHandler)).Methods("PATCH")
+	r.HandleFunc("/admin/keys/{id}", requireAdmin(revokeKeyHandler)).Methods("DELETE")
+	r.HandleFunc("/jobs/{id}/complete", shedLoad(completeUploadHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/jobs/{id}/archive", jobArchiveHandler).Methods("GET")
+	r.HandleFunc("/jobs/{id}/share", createShareHandler).Methods("POST", "OPTIONS")
+	r.HandleFunc("/s/{id}", sharedResultHandler).Methods("GET")
+	r.HandleFunc("/s/{id}/report", sharedReportHandler).Methods("GET")
+	r.HandleFunc("/s/{id}/embed", sharedEmbedHandler).Methods("GET")
+	r.HandleFunc("/report", shedLoad(reportHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/report/{id}", jobReportHandler).Methods("GET")
+	r.HandleFunc("/replays/{hash}", getReplayHandler).Methods("GET")
 	r.HandleFunc("/health", healthHandler).Methods("GET")
-	
+	r.HandleFunc("/health/dependencies", dependencyHealthHandler).Methods("GET")
+	r.HandleFunc("/openapi.json", openAPIHandler).Methods("GET")
+	r.HandleFunc("/docs", docsHandler).Methods("GET")
+	r.HandleFunc("/schemas/{version}", schemaIndexHandler).Methods("GET")
+	r.HandleFunc("/schemas/{version}/{name}.json", schemaHandler).Methods("GET")
+
+	objects = objectStoreFromEnv()
+	store, err := replayStoreFromEnv(objects)
+	if err != nil {
+		log.Fatalf("Error configuring replay storage: %v", err)
+	}
+	replays = store
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	deleteEarlierRun(ctx)
+	cancel()
+	go expireJobs()
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
-	
-	log.Printf("Server starting on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, r))
-}
\ No newline at end of file
+
+	srv := &http.Server{
+		Addr:              ":" + port,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// With TLS, net/http negotiates HTTP/2 via ALPN. In plaintext (e.g. behind
+	// a service mesh or TLS-terminating proxy) HTTP/2 is served as h2c.
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if certFile != "" && keyFile != "" {
+		srv.Handler = r
+		log.Printf("Server starting on port %s (TLS, HTTP/2)", port)
+		log.Fatal(srv.ListenAndServeTLS(certFile, keyFile))
+	}
+
+	srv.Handler = h2c.NewHandler(r, &http2.Server{})
+	log.Printf("Server starting on port %s (HTTP/1.1, h2c)", port)
+	log.Fatal(srv.ListenAndServe())
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcmd"
+	"github.com/icza/screp/rep/repcore"
+)
+
+// Suspicion is a command hinting at information the player shouldn't have
+// had (map hack), for human review.
+type Suspicion struct {
+	PlayerID       int     `json:"playerId"`
+	TargetPlayerID int     `json:"targetPlayerId"`
+	Frame          int     `json:"frame"`
+	Time           float64 `json:"time"`
+	X              int     `json:"x"`
+	Y              int     `json:"y"`
+	Reason         string  `json:"reason"`
+}
+
+// Radii of the map hack heuristic, in pixels
+const (
+	hiddenBuildingRadius = 8 * tileSize  // Target counts as aimed at the building
+	scoutedRadius        = 12 * tileSize // Area the player's own commands reveal
+	startLocationRadius  = 20 * tileSize // Main bases are common knowledge
+)
+
+type enemyBuilding struct {
+	owner int
+	frame repcore.Frame
+	pos   repcore.Point
+	unit  string
+}
+
+// detectMapHack flags attacks and right clicks on units aimed at enemy
+// buildings in places the player never sent a command to (and didn't scan)
+// before, i.e. which weren't plausibly scouted. Moves aren't flagged, those
+// are how players scout. Buildings near start locations are ignored, those
+// are found without scouting.
+func detectMapHack(rp *rep.Replay) []Suspicion {
+	starts := startLocations(rp)
+	nearStart := func(p repcore.Point) bool {
+		for _, s := range starts {
+			if dist(p, s) < startLocationRadius {
+				return true
+			}
+		}
+		return false
+	}
+
+	var buildings []enemyBuilding
+	seen := map[int][]repcore.Point{} // Positions each player has plausibly seen
+	for pid, s := range starts {
+		seen[pid] = append(seen[pid], s)
+	}
+	wasSeen := func(pid int, p repcore.Point) bool {
+		for _, s := range seen[pid] {
+			if dist(p, s) < scoutedRadius {
+				return true
+			}
+		}
+		return false
+	}
+
+	var suspicions []Suspicion
+	flagged := map[[2]int]bool{} // Player and building index, flag each once
+	for _, cmd := range rp.Commands {
+		pos, ok := cmdPos(cmd)
+		if !ok {
+			continue
+		}
+		base := cmd.BaseCmd()
+		pid := int(base.PlayerID)
+
+		attack := false
+		switch c := cmd.(type) {
+		case *repcmd.BuildCmd:
+			if !nearStart(c.Pos) {
+				buildings = append(buildings, enemyBuilding{owner: pid, frame: base.Frame, pos: c.Pos, unit: c.Unit.String()})
+			}
+		case *repcmd.TargetedOrderCmd:
+			// Scanner sweeps aren't attacks, they only reveal (recorded as seen below)
+			attack = c.Order != nil && repcmd.IsOrderIDKindAttack(c.Order.ID)
+		case *repcmd.RightClickCmd:
+			// Only right clicks on units, on the ground they are moves such as
+			// scouting ones
+			attack = c.UnitTag != 0 && c.UnitTag.Valid()
+		}
+
+		if attack && !wasSeen(pid, pos) {
+			for i, b := range buildings {
+				if b.frame >= base.Frame || !isEnemy(rp, pid, b.owner) || flagged[[2]int{pid, i}] {
+					continue
+				}
+				if dist(pos, b.pos) < hiddenBuildingRadius {
+					flagged[[2]int{pid, i}] = true
+					suspicions = append(suspicions, Suspicion{
+						PlayerID:       pid,
+						TargetPlayerID: b.owner,
+						Frame:          int(base.Frame),
+						Time:           frameToSeconds(base.Frame),
+						X:              int(pos.X),
+						Y:              int(pos.Y),
+						Reason: fmt.Sprintf("first command into an unscouted area targets a hidden %s built at %s",
+							b.unit, formatClock(b.frame)),
+					})
+					break
+				}
+			}
+		}
+		seen[pid] = append(seen[pid], pos)
+	}
+	return suspicions
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	// Tags in brackets or pipes, e.g. "| iCCup |", "[OBS]", "(4)"
+	mapTagRe = regexp.MustCompile(`\|[^|]*\||\[[^\]]*\]|\([^)]*\)|\{[^}]*\}`)
+	// League and observer prefixes, as separate words
+	mapPrefixRe = regexp.MustCompile(`(?i)^(iccup|obs?|ob[0-9]*|asl|ksl|ssl|bwcl|wcg|ecs|fish|new)\b[\s\-_:.]*`)
+	// Trailing versions and observer suffixes, e.g. "1.3", "v2.0a", "obs", "SE"
+	mapSuffixRe = regexp.MustCompile(`(?i)[\s\-_]+(v[0-9]+(\.[0-9]+)*[a-z]?|[0-9]+(\.[0-9]+)+[a-z]?|obs?|se|le)$`)
+)
+
+// canonicalMapName normalizes the raw map title for grouping: color codes,
+// tags, league/observer prefixes and version suffixes are stripped, so e.g.
+// "| iCCup | Fighting Spirit 1.3" becomes "Fighting Spirit". Returns the raw
+// name trimmed if nothing would be left.
+func canonicalMapName(raw string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1 // Color codes
+		}
+		return r
+	}, raw)
+
+	for {
+		prev := name
+		name = mapTagRe.ReplaceAllString(name, " ")
+		name = strings.Trim(strings.Join(strings.Fields(name), " "), " -_:.|")
+		name = mapPrefixRe.ReplaceAllString(name, "")
+		name = mapSuffixRe.ReplaceAllString(name, "")
+		if name == prev {
+			break
+		}
+	}
+	if name == "" {
+		return strings.TrimSpace(raw)
+	}
+	return name
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcmd"
+)
+
+// HotkeyUsage breaks down a player's actions into hotkey-driven and
+// mouse-driven ones.
+//
+// Selections are told apart exactly (hotkey recall vs click/box select).
+// Commands are estimated: those needing a target or placement click are
+// counted as mouse commands, the rest (train, stop, siege, ...) as keyboard
+// commands, although they may also be issued by clicking the command card.
+//
+// Camera location hotkeys (F2-F4) aren't in replays, they never leave the
+// client.
+type HotkeyUsage struct {
+	Assigns          int   `json:"assigns"`
+	Recalls          int   `json:"recalls"`
+	ClickSelects     int   `json:"clickSelects"`
+	KeyboardCommands int   `json:"keyboardCommands"`
+	MouseCommands    int   `json:"mouseCommands"`
+	Groups           []int `json:"groups"` // Control groups recalled at least once
+
+	// HotkeySelectRatio is the share of selections done by hotkey recall.
+	HotkeySelectRatio float64 `json:"hotkeySelectRatio"`
+	// HotkeyRatio is the share of hotkey-driven actions (recalls and
+	// keyboard commands) among all classified actions.
+	HotkeyRatio float64 `json:"hotkeyRatio"`
+}
+
+// hotkeyUsage computes the hotkey usage of every player.
+func hotkeyUsage(rp *rep.Replay) []HotkeyUsage {
+	usage := make([]HotkeyUsage, len(rp.Header.Players))
+	groups := make([]map[int]bool, len(usage))
+	for _, cmd := range rp.Commands {
+		base := cmd.BaseCmd()
+		if base == nil || int(base.PlayerID) >= len(usage) {
+			continue
+		}
+		u := &usage[base.PlayerID]
+		switch c := cmd.(type) {
+		case *repcmd.HotkeyCmd:
+			switch c.HotkeyType.ID {
+			case repcmd.HotkeyTypeIDSelect:
+				u.Recalls++
+				if groups[base.PlayerID] == nil {
+					groups[base.PlayerID] = map[int]bool{}
+				}
+				groups[base.PlayerID][int(c.Group)] = true
+			default:
+				u.Assigns++
+			}
+		case *repcmd.SelectCmd:
+			u.ClickSelects++
+		case *repcmd.BuildCmd, *repcmd.TargetedOrderCmd, *repcmd.RightClickCmd, *repcmd.LandCmd:
+			u.MouseCommands++
+		default:
+			if isMeaningful(cmd) {
+				u.KeyboardCommands++
+			}
+		}
+	}
+
+	for i := range usage {
+		u := &usage[i]
+		u.Groups = []int{}
+		for g := range groups[i] {
+			u.Groups = append(u.Groups, g)
+		}
+		sort.Ints(u.Groups)
+		u.HotkeySelectRatio = ratio(u.Recalls, u.Recalls+u.ClickSelects)
+		u.HotkeyRatio = ratio(u.Recalls+u.KeyboardCommands, u.Recalls+u.KeyboardCommands+u.ClickSelects+u.MouseCommands)
+	}
+	return usage
+}
+
+// ratio returns n/total rounded to 3 decimals, 0 if total is 0.
+func ratio(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(n)/float64(total)*1000) / 1000
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcmd"
+	"github.com/icza/screp/rep/repcore"
+)
+
+// Milestones are the time-to-first-X timings of a player, the standard
+// comparison points of every matchup. Times are in seconds from the start,
+// null if the player never got there. Like the other build metrics, they
+// are taken from commands, so a building cancelled right away still counts.
+type Milestones struct {
+	FirstGas          *float64 `json:"firstGas"`
+	FirstExpansion    *float64 `json:"firstExpansion"`
+	FirstTechBuilding *float64 `json:"firstTechBuilding"`
+	TechBuilding      string   `json:"techBuilding,omitempty"`
+	FirstArmyUnit     *float64 `json:"firstArmyUnit"`
+	ArmyUnit          string   `json:"armyUnit,omitempty"`
+	FirstUpgrade      *float64 `json:"firstUpgrade"` // Upgrade or tech research started
+	Upgrade           string   `json:"upgrade,omitempty"`
+}
+
+// expansionDistance is how far from the start location a town hall must be
+// to count as an expansion rather than a macro hatchery, in pixels.
+const expansionDistance = 12 * tileSize
+
+// techBuildings unlock units or research beyond the starting production
+// buildings. Lair and Hive are morphs, the others are built.
+var techBuildings = map[uint16]bool{
+	repcmd.UnitIDAcademy:            true,
+	repcmd.UnitIDEngineeringBay:     true,
+	repcmd.UnitIDFactory:            true,
+	repcmd.UnitIDStarport:           true,
+	repcmd.UnitIDArmory:             true,
+	repcmd.UnitIDScienceFacility:    true,
+	repcmd.UnitIDCyberneticsCore:    true,
+	repcmd.UnitIDForge:              true,
+	repcmd.UnitIDCitadelOfAdun:      true,
+	repcmd.UnitIDRoboticsFacility:   true,
+	repcmd.UnitIDStargate:           true,
+	repcmd.UnitIDTemplarArchives:    true,
+	repcmd.UnitIDObservatory:        true,
+	repcmd.UnitIDRoboticsSupportBay: true,
+	repcmd.UnitIDFleetBeacon:        true,
+	repcmd.UnitIDArbiterTribunal:    true,
+	repcmd.UnitIDSpawningPool:       true,
+	repcmd.UnitIDEvolutionChamber:   true,
+	repcmd.UnitIDHydraliskDen:       true,
+	repcmd.UnitIDLair:               true,
+	repcmd.UnitIDSpire:              true,
+	repcmd.UnitIDQueensNest:         true,
+	repcmd.UnitIDHive:               true,
+	repcmd.UnitIDDefilerMound:       true,
+	repcmd.UnitIDUltraliskCavern:    true,
+}
+
+// nonArmyUnits are the units of producedUnits that don't fight: workers and
+// overlords.
+var nonArmyUnits = map[uint16]bool{
+	0x07: true, // SCV
+	0x40: true, // Probe
+	0x29: true, // Drone
+	0x2A: true, // Overlord
+}
+
+func isGasBuilding(u *repcmd.Unit) bool {
+	return u != nil && (u.ID == repcmd.UnitIDRefinery || u.ID == repcmd.UnitIDAssimilator || u.ID == repcmd.UnitIDExtractor)
+}
+
+// milestones computes the milestone timings of every player.
+func milestones(rp *rep.Replay) []Milestones {
+	res := make([]Milestones, len(rp.Header.Players))
+	starts := startLocations(rp)
+	at := func(f repcore.Frame) *float64 {
+		t := round(frameToSeconds(f), 1)
+		return &t
+	}
+
+	for _, cmd := range rp.Commands {
+		base := cmd.BaseCmd()
+		if base == nil || int(base.PlayerID) >= len(res) {
+			continue
+		}
+		m := &res[base.PlayerID]
+		switch c := cmd.(type) {
+		case *repcmd.BuildCmd:
+			switch {
+			case isGasBuilding(c.Unit):
+				if m.FirstGas == nil {
+					m.FirstGas = at(base.Frame)
+				}
+			case isTownHall(c.Unit):
+				// Without map data every town hall counts: the start
+				// location is needed to tell macro hatcheries apart
+				start, ok := starts[int(base.PlayerID)]
+				if m.FirstExpansion == nil && (!ok || dist(c.Pos, start) >= expansionDistance) {
+					m.FirstExpansion = at(base.Frame)
+				}
+			case c.Unit != nil && techBuildings[c.Unit.ID]:
+				if m.FirstTechBuilding == nil {
+					m.FirstTechBuilding, m.TechBuilding = at(base.Frame), c.Unit.String()
+				}
+			}
+		case *repcmd.BuildingMorphCmd:
+			if c.Unit != nil && techBuildings[c.Unit.ID] && m.FirstTechBuilding == nil {
+				m.FirstTechBuilding, m.TechBuilding = at(base.Frame), c.Unit.String()
+			}
+		case *repcmd.TrainCmd:
+			if c.Unit == nil || nonArmyUnits[c.Unit.ID] {
+				break
+			}
+			if _, ok := producedUnits[c.Unit.ID]; ok && m.FirstArmyUnit == nil {
+				m.FirstArmyUnit, m.ArmyUnit = at(base.Frame), c.Unit.String()
+			}
+		case *repcmd.UpgradeCmd:
+			if c.Upgrade != nil && m.FirstUpgrade == nil {
+				m.FirstUpgrade, m.Upgrade = at(base.Frame), c.Upgrade.String()
+			}
+		case *repcmd.TechCmd:
+			if c.Tech != nil && m.FirstUpgrade == nil {
+				m.FirstUpgrade, m.Upgrade = at(base.Frame), c.Tech.String()
+			}
+		}
+	}
+	return res
+}
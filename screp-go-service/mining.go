@@ -0,0 +1,157 @@
+package main
+
+import (
+	"math"
+
+	"github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcmd"
+	"github.com/icza/screp/rep/repcore"
+)
+
+// WorkerPull is an interval in which a player pulled workers off mining at
+// one of their own bases, e.g. to fight off harassment.
+//
+// Replays don't record unit types, workers are the units that were sent to
+// minerals or gas or ordered to construct. The pull ends when at least half
+// of the workers are sent back to mining (or after maxPullSeconds).
+type WorkerPull struct {
+	Frame           int     `json:"frame"`
+	Time            float64 `json:"time"`
+	EndFrame        int     `json:"endFrame"`
+	EndTime         float64 `json:"endTime"`
+	Workers         int     `json:"workers"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	LostMinerals    int     `json:"lostMinerals"` // Estimated mining lost
+}
+
+// Thresholds of the worker pull heuristic
+const (
+	minPullWorkers          = 4
+	maxPullSeconds          = 90
+	ownBaseRadius           = 16 * tileSize
+	mineralsPerWorkerSecond = 0.9
+)
+
+// isResource tells if the unit is a mineral field or a (taken) geyser.
+func isResource(u *repcmd.Unit) bool {
+	if u == nil {
+		return false
+	}
+	switch u.ID {
+	case repcmd.UnitIDMineralField1, repcmd.UnitIDMineralField2, repcmd.UnitIDMineralField3,
+		repcmd.UnitIDVespeneGeyser, repcmd.UnitIDRefinery, repcmd.UnitIDAssimilator, repcmd.UnitIDExtractor:
+		return true
+	}
+	return false
+}
+
+// isHarvestOrder tells if the order sends workers to mine.
+func isHarvestOrder(o *repcmd.Order) bool {
+	if o == nil {
+		return false
+	}
+	switch o.ID {
+	case 0x4f, 0x50, 0x51, 0x53, 0x55, 0x58, 0x59: // Harvest1-4, MoveToGas, HarvestGas, MoveToMinerals
+		return true
+	}
+	return false
+}
+
+// workerPulls detects the worker pulls of every player.
+func workerPulls(rp *rep.Replay) [][]WorkerPull {
+	type player struct {
+		sel     selection
+		workers map[repcmd.UnitTag]bool
+		pull    *WorkerPull
+		pulled  []repcmd.UnitTag // Workers of the ongoing pull still away
+	}
+	players := make([]*player, len(rp.Header.Players))
+	pulls := make([][]WorkerPull, len(players))
+	bases := playerBases(rp)
+	for pid := range players {
+		players[pid] = &player{workers: map[repcmd.UnitTag]bool{}}
+		pulls[pid] = []WorkerPull{}
+	}
+
+	end := func(pid int, f repcore.Frame) {
+		p := players[pid]
+		if limit := repcore.Frame(p.pull.Frame) + secondsToFrames(maxPullSeconds); f > limit {
+			f = limit
+		}
+		p.pull.EndFrame = int(f)
+		p.pull.EndTime = frameToSeconds(f)
+		p.pull.DurationSeconds = math.Round(frameToSeconds(f-repcore.Frame(p.pull.Frame))*10) / 10
+		p.pull.LostMinerals = int(float64(p.pull.Workers) * p.pull.DurationSeconds * mineralsPerWorkerSecond)
+		pulls[pid] = append(pulls[pid], *p.pull)
+		p.pull, p.pulled = nil, nil
+	}
+	for _, cmd := range rp.Commands {
+		base := cmd.BaseCmd()
+		if base == nil || int(base.PlayerID) >= len(players) {
+			continue
+		}
+		pid := int(base.PlayerID)
+		p := players[pid]
+		if p.pull != nil && base.Frame > repcore.Frame(p.pull.Frame)+secondsToFrames(maxPullSeconds) {
+			end(pid, base.Frame)
+		}
+		p.sel.apply(cmd)
+
+		harvest, pullCmd := false, false
+		switch c := cmd.(type) {
+		case *repcmd.RightClickCmd:
+			harvest = isResource(c.Unit)
+			pullCmd = !harvest
+		case *repcmd.TargetedOrderCmd:
+			harvest = isHarvestOrder(c.Order)
+			pullCmd = c.Order != nil && (c.Order.ID == repcmd.OrderIDMove || repcmd.IsOrderIDKindAttack(c.Order.ID))
+		case *repcmd.BuildCmd:
+			for _, t := range p.sel.current {
+				p.workers[t] = true
+			}
+		}
+
+		if harvest {
+			for _, t := range p.sel.current {
+				p.workers[t] = true
+			}
+			if p.pull != nil {
+				p.pulled = removeTags(p.pulled, p.sel.current)
+				if len(p.pulled)*2 <= p.pull.Workers {
+					end(pid, base.Frame)
+				}
+			}
+			continue
+		}
+		if !pullCmd {
+			continue
+		}
+		pos, _ := cmdPos(cmd)
+		if nearestBase(bases[pid], pos, base.Frame) >= ownBaseRadius {
+			continue
+		}
+		var selected []repcmd.UnitTag
+		for _, t := range p.sel.current {
+			if p.workers[t] {
+				selected = append(selected, t)
+			}
+		}
+		if len(selected) < minPullWorkers {
+			continue
+		}
+		if p.pull == nil {
+			p.pull = &WorkerPull{Frame: int(base.Frame), Time: frameToSeconds(base.Frame)}
+		}
+		p.pulled = unionTags(p.pulled, selected)
+		if len(p.pulled) > p.pull.Workers {
+			p.pull.Workers = len(p.pulled)
+		}
+	}
+
+	for pid, p := range players {
+		if p.pull != nil {
+			end(pid, rp.Header.Frames)
+		}
+	}
+	return pulls
+}
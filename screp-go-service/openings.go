@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Limits and defaults of the openings analysis
+const (
+	maxOpeningJobs        = 1000
+	defaultOpeningSteps   = 10
+	maxOpeningSteps       = 30
+	defaultOpeningMaxDist = 3
+	maxOpeningExamples    = 3
+)
+
+// OpeningsRequest selects the jobs whose replays are clustered. Batch jobs
+// contribute every replay of the batch.
+type OpeningsRequest struct {
+	JobIDs      []string `json:"jobIds"`
+	Steps       int      `json:"steps,omitempty"`       // Build order steps compared, default 10
+	MaxDistance *int     `json:"maxDistance,omitempty"` // Max edit distance to a cluster's opening, default 3
+}
+
+// OpeningsReport is the meta report of a replay collection: the dominant
+// openings of each matchup.
+type OpeningsReport struct {
+	Replays     int               `json:"replays"`
+	Steps       int               `json:"steps"`
+	MaxDistance int               `json:"maxDistance"`
+	Matchups    []MatchupOpenings `json:"matchups"`
+	MissingJobs []string          `json:"missingJobs,omitempty"` // Unknown, expired or unfinished jobs
+}
+
+// MatchupOpenings are the opening clusters of one matchup, largest first.
+// Games counts player games: a 1v1 replay counts once per player.
+type MatchupOpenings struct {
+	Matchup  string    `json:"matchup"`
+	Games    int       `json:"games"`
+	Openings []Opening `json:"openings"`
+}
+
+// Opening is a cluster of similar build orders. Build is the most common
+// build order of the cluster, which every member is within MaxDistance of.
+type Opening struct {
+	Build       []string         `json:"build"`
+	Games       int              `json:"games"`
+	Share       float64          `json:"share"`             // Of the matchup's games, 0..1
+	WinRate     *float64         `json:"winRate,omitempty"` // Of the games with a known winner
+	AvgDistance float64          `json:"avgDistance"`
+	Examples    []OpeningExample `json:"examples"`
+}
+
+// OpeningExample points to a replay that played the opening.
+type OpeningExample struct {
+	JobID      string   `json:"jobId"`
+	Replay     string   `json:"replay,omitempty"` // Name in the batch
+	ReplayHash string   `json:"replayHash,omitempty"`
+	Player     string   `json:"player"`
+	Build      []string `json:"build"`
+}
+
+// openingWorkers are left out of normalized build orders: their count
+// varies with every build and says little about the opening.
+var openingWorkers = map[string]bool{"SCV": true, "Probe": true, "Drone": true}
+
+// normalizeBuild returns the first steps of the build order: unit and
+// building names without workers, repeats of the same unit collapsed.
+func normalizeBuild(bo BuildOrder, steps int) []string {
+	var build []string
+	for _, c := range bo.Sequence {
+		if len(build) == steps {
+			break
+		}
+		name := c.Target
+		if openingWorkers[name] || c.CommandType == "Train" && len(build) > 0 && build[len(build)-1] == name {
+			continue
+		}
+		build = append(build, name)
+	}
+	return build
+}
+
+// editDistance is the Levenshtein distance of two build orders, in steps.
+func editDistance(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// playerMatchup returns the matchup of the player against the first enemy
+// from the result, e.g. "TvZ", or "" if it can't be told.
+func playerMatchup(res *ReplayResult, p PlayerInfo) string {
+	for _, e := range res.Players {
+		if e.Team != p.Team && p.Race != "" && e.Race != "" {
+			return p.Race[:1] + "v" + e.Race[:1]
+		}
+	}
+	return ""
+}
+
+// openingGame is the build order of one player in one replay.
+type openingGame struct {
+	build   []string
+	won     *bool
+	example OpeningExample
+}
+
+// analyzeOpenings clusters the build orders of the jobs' replays per
+// matchup. Identical build orders are grouped first; then, most common
+// first, each joins the first cluster whose opening is within maxDist or
+// founds a new one. This is deterministic and linear in the number of
+// clusters, unlike hierarchical clustering.
+func analyzeOpenings(ids []string, steps, maxDist int) *OpeningsReport {
+	out := &OpeningsReport{Steps: steps, MaxDistance: maxDist, Matchups: []MatchupOpenings{}}
+	games := map[string][]openingGame{}
+	add := func(job Job, name, hash string, res *ReplayResult) {
+		out.Replays++
+		for _, p := range res.Players {
+			mu := playerMatchup(res, p)
+			if mu == "" || p.ID >= len(res.BuildOrders) {
+				continue
+			}
+			build := normalizeBuild(res.BuildOrders[p.ID], steps)
+			if len(build) == 0 {
+				continue
+			}
+			g := openingGame{build: build, example: OpeningExample{JobID: job.ID, Replay: name, ReplayHash: hash, Player: p.Name, Build: build}}
+			if res.WinnerTeam != 0 {
+				won := p.Team == res.WinnerTeam
+				g.won = &won
+			}
+			games[mu] = append(games[mu], g)
+		}
+	}
+	for _, id := range ids {
+		job, ok := jobs.get(id)
+		if !ok || job.Status != JobDone {
+			out.MissingJobs = append(out.MissingJobs, id)
+			continue
+		}
+		if job.Result != nil {
+			add(job, "", job.ReplayHash, job.Result)
+		}
+		for _, item := range job.Batch {
+			if item.Result != nil {
+				add(job, item.Name, item.ReplayHash, item.Result)
+			}
+		}
+	}
+
+	for mu, gs := range games {
+		out.Matchups = append(out.Matchups, MatchupOpenings{Matchup: mu, Games: len(gs), Openings: clusterOpenings(gs, maxDist)})
+	}
+	sort.Slice(out.Matchups, func(i, j int) bool {
+		a, b := out.Matchups[i], out.Matchups[j]
+		return a.Games > b.Games || a.Games == b.Games && a.Matchup < b.Matchup
+	})
+	return out
+}
+
+func clusterOpenings(gs []openingGame, maxDist int) []Opening {
+	// Group identical build orders, most common first
+	type group struct {
+		build []string
+		games []openingGame
+	}
+	var groups []*group
+	byKey := map[string]*group{}
+	for _, g := range gs {
+		key := strings.Join(g.build, "\x00")
+		if byKey[key] == nil {
+			byKey[key] = &group{build: g.build}
+			groups = append(groups, byKey[key])
+		}
+		byKey[key].games = append(byKey[key].games, g)
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return len(groups[i].games) > len(groups[j].games) })
+
+	type cluster struct {
+		Opening
+		wins, decided, dist int
+	}
+	var clusters []*cluster
+	for _, grp := range groups {
+		var c *cluster
+		d := 0
+		for _, cand := range clusters {
+			if d = editDistance(cand.Build, grp.build); d <= maxDist {
+				c = cand
+				break
+			}
+		}
+		if c == nil {
+			c, d = &cluster{Opening: Opening{Build: grp.build, Examples: []OpeningExample{}}}, 0
+			clusters = append(clusters, c)
+		}
+		for _, g := range grp.games {
+			c.Games++
+			c.dist += d
+			if g.won != nil {
+				c.decided++
+				if *g.won {
+					c.wins++
+				}
+			}
+			if len(c.Examples) < maxOpeningExamples {
+				c.Examples = append(c.Examples, g.example)
+			}
+		}
+	}
+
+	openings := make([]Opening, len(clusters))
+	for i, c := range clusters {
+		c.Share = ratio(c.Games, len(gs))
+		c.AvgDistance = round(float64(c.dist)/float64(c.Games), 2)
+		if c.decided > 0 {
+			wr := ratio(c.wins, c.decided)
+			c.WinRate = &wr
+		}
+		openings[i] = c.Opening
+	}
+	sort.SliceStable(openings, func(i, j int) bool { return openings[i].Games > openings[j].Games })
+	return openings
+}
+
+// openingsHandler clusters the build orders of a set of jobs. Like batch
+// parsing, it runs in the background with ?async=true, the report is then
+// the job's openings once done.
+func openingsHandler(w http.ResponseWriter, r *http.Request) {
+	var req OpeningsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.JobIDs) == 0 || len(req.JobIDs) > maxOpeningJobs {
+		http.Error(w, "jobIds must list 1 to "+strconv.Itoa(maxOpeningJobs)+" jobs", http.StatusBadRequest)
+		return
+	}
+	if req.Steps == 0 {
+		req.Steps = defaultOpeningSteps
+	}
+	if req.Steps < 1 || req.Steps > maxOpeningSteps {
+		http.Error(w, "Invalid steps, must be 1 to "+strconv.Itoa(maxOpeningSteps), http.StatusBadRequest)
+		return
+	}
+	maxDist := defaultOpeningMaxDist
+	if req.MaxDistance != nil {
+		maxDist = *req.MaxDistance
+	}
+	if maxDist < 0 || maxDist > req.Steps {
+		http.Error(w, "Invalid maxDistance, must be 0 to steps", http.StatusBadRequest)
+		return
+	}
+
+	job := jobs.create(JobProcessing, r.Header.Get(ownerHeader))
+	setAuditResource(r, job.ID)
+	run := func() Job {
+		report := analyzeOpenings(req.JobIDs, req.Steps, maxDist)
+		job, _ := jobs.update(job.ID, func(j *Job) bool {
+			j.Status, j.Openings = JobDone, report
+			return true
+		})
+		return job
+	}
+
+	if async, _ := strconv.ParseBool(r.URL.Query().Get("async")); async {
+		go run()
+		writeJSONStatus(w, http.StatusAccepted, job)
+		return
+	}
+	writeJSON(w, run())
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// OverlaySummary is a compact replay summary for OBS browser-source overlays.
+type OverlaySummary struct {
+	Map     string          `json:"map"`
+	Seconds int             `json:"sec"`
+	Matchup string          `json:"mu"`
+	Players []OverlayPlayer `json:"players"`
+	Winner  int             `json:"winner,omitempty"` // Team, 0 if unknown
+}
+
+type OverlayPlayer struct {
+	Name string `json:"name"`
+	Race string `json:"race"` // Race letter
+	Team int    `json:"team"`
+	APM  int    `json:"apm"`
+}
+
+func overlaySummary(res *ReplayResult) OverlaySummary {
+	s := OverlaySummary{
+		Map:     res.MapName,
+		Seconds: int(res.DurationSeconds),
+		Players: make([]OverlayPlayer, len(res.Players)),
+		Winner:  res.WinnerTeam,
+	}
+	var mu strings.Builder
+	for i, p := range res.Players {
+		race := "U"
+		if p.Race != "" {
+			race = p.Race[:1]
+		}
+		if i > 0 && p.Team != res.Players[i-1].Team {
+			mu.WriteByte('v')
+		}
+		mu.WriteString(race)
+		s.Players[i] = OverlayPlayer{Name: p.Name, Race: race, Team: p.Team, APM: p.APM}
+	}
+	s.Matchup = mu.String()
+	return s
+}
+
+// overlayHandler parses an uploaded replay and returns its overlay summary,
+// built without the action list.
+// APM is computed over the recorded frames, so a partial replay yields the
+// APM so far.
+func overlayHandler(w http.ResponseWriter, r *http.Request) {
+	file, _, err := r.FormFile("replay")
+	if err != nil {
+		http.Error(w, "Missing replay file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	rp, err := decodeReplay(file)
+	if err != nil {
+		http.Error(w, "Parse error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, overlaySummary(buildResult(rp, false)))
+}
+
+// jobOverlayHandler returns the overlay summary of a parsed job, or of the
+// replay named by the replay query parameter of a batch job. Finished
+// results never change, so they are served with long-lived cache headers.
+func jobOverlayHandler(w http.ResponseWriter, r *http.Request) {
+	job, res, item, ok := jobReplayResult(w, r)
+	if !ok {
+		return
+	}
+
+	etag := `"` + job.ID + item + `"`
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, overlaySummary(res))
+}
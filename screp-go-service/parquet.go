@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// A minimal Apache Parquet writer, enough for flat datasets: one row group,
+// one uncompressed PLAIN encoded data page per column, required or optional
+// columns of strings, int32s, doubles and booleans. Metadata is written with
+// the Thrift compact protocol, see
+// https://github.com/apache/parquet-format/blob/master/src/main/thrift/parquet.thrift.
+
+// Parquet physical types
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetDouble    = 5
+	parquetByteArray = 6
+)
+
+// parquetColumn is a column being written. Values are string, int32,
+// float64 or bool according to typ, nil for nulls of optional columns.
+type parquetColumn struct {
+	name     string
+	typ      int32
+	optional bool
+	values   []interface{}
+}
+
+func (c *parquetColumn) add(v interface{}) {
+	c.values = append(c.values, v)
+}
+
+// page encodes the values of the column as a data page body: definition
+// levels for optional columns, then the non-null values.
+func (c *parquetColumn) page() []byte {
+	var b bytes.Buffer
+	if c.optional {
+		levels := rleLevels(c.values)
+		binary.Write(&b, binary.LittleEndian, uint32(len(levels)))
+		b.Write(levels)
+	}
+	var bits []bool
+	for _, v := range c.values {
+		switch v := v.(type) {
+		case string:
+			binary.Write(&b, binary.LittleEndian, uint32(len(v)))
+			b.WriteString(v)
+		case int32:
+			binary.Write(&b, binary.LittleEndian, v)
+		case float64:
+			binary.Write(&b, binary.LittleEndian, math.Float64bits(v))
+		case bool:
+			bits = append(bits, v)
+		}
+	}
+	// Booleans are bit-packed, least significant bit first
+	packed := make([]byte, (len(bits)+7)/8)
+	for i, v := range bits {
+		if v {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+	b.Write(packed)
+	return b.Bytes()
+}
+
+// rleLevels encodes the definition levels (1 if present, 0 if null) with
+// the RLE/bit-packing hybrid encoding, as RLE runs only.
+func rleLevels(values []interface{}) []byte {
+	var b []byte
+	for i := 0; i < len(values); {
+		present := values[i] != nil
+		j := i
+		for j < len(values) && (values[j] != nil) == present {
+			j++
+		}
+		b = binary.AppendUvarint(b, uint64(j-i)<<1)
+		if present {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+		i = j
+	}
+	return b
+}
+
+// writeParquet writes the columns, which must all have rows values, as a
+// Parquet file.
+func writeParquet(w io.Writer, cols []*parquetColumn, rows int, createdBy string) error {
+	var file bytes.Buffer
+	file.WriteString("PAR1")
+
+	rowGroup := &thriftWriter{}
+	rowGroup.listBegin(1, len(cols))
+	var totalSize int64
+	for _, c := range cols {
+		offset := int64(file.Len())
+		page := c.page()
+		header := &thriftWriter{}
+		header.i32(1, 0) // DATA_PAGE
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(page)))
+		header.structBegin(5)
+		header.i32(1, int32(rows))
+		header.i32(2, 0) // PLAIN
+		header.i32(3, 3) // RLE definition levels
+		header.i32(4, 3) // RLE repetition levels
+		header.end()
+		header.end()
+		file.Write(header.b.Bytes())
+		file.Write(page)
+		size := int64(file.Len()) - offset
+		totalSize += size
+
+		rowGroup.elemBegin() // ColumnChunk
+		rowGroup.i64(2, offset)
+		rowGroup.structBegin(3) // ColumnMetaData
+		rowGroup.i32(1, c.typ)
+		rowGroup.i32List(2, []int32{0, 3})
+		rowGroup.stringList(3, []string{c.name})
+		rowGroup.i32(4, 0) // UNCOMPRESSED
+		rowGroup.i64(5, int64(rows))
+		rowGroup.i64(6, size)
+		rowGroup.i64(7, size)
+		rowGroup.i64(9, offset)
+		rowGroup.end()
+		rowGroup.end()
+	}
+	rowGroup.i64(2, totalSize)
+	rowGroup.i64(3, int64(rows))
+	rowGroup.end()
+
+	meta := &thriftWriter{}
+	meta.i32(1, 1)
+	meta.listBegin(2, len(cols)+1)
+	meta.elemBegin()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(cols)))
+	meta.end()
+	for _, c := range cols {
+		meta.elemBegin()
+		meta.i32(1, c.typ)
+		if c.optional {
+			meta.i32(3, 1) // OPTIONAL
+		} else {
+			meta.i32(3, 0) // REQUIRED
+		}
+		meta.binary(4, c.name)
+		if c.typ == parquetByteArray {
+			meta.i32(6, 0) // UTF8
+		}
+		meta.end()
+	}
+	meta.i64(3, int64(rows))
+	meta.listBegin(4, 1)
+	meta.b.Write(rowGroup.b.Bytes())
+	meta.binary(6, createdBy)
+	meta.end()
+
+	file.Write(meta.b.Bytes())
+	binary.Write(&file, binary.LittleEndian, uint32(meta.b.Len()))
+	file.WriteString("PAR1")
+	_, err := w.Write(file.Bytes())
+	return err
+}
+
+// thriftWriter encodes structs with the Thrift compact protocol. Field IDs
+// must be written in increasing order within a struct.
+type thriftWriter struct {
+	b     bytes.Buffer
+	last  int16
+	stack []int16
+}
+
+// Thrift compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.b.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.b.WriteByte(typ)
+		t.varint(int64(id))
+	}
+	t.last = id
+}
+
+// varint writes a zigzag encoded integer.
+func (t *thriftWriter) varint(v int64) {
+	t.b.Write(binary.AppendUvarint(nil, uint64(v<<1^v>>63)))
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.b.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	t.b.WriteString(s)
+}
+
+func (t *thriftWriter) listHeader(n int, elem byte) {
+	if n < 15 {
+		t.b.WriteByte(byte(n)<<4 | elem)
+		return
+	}
+	t.b.WriteByte(0xF0 | elem)
+	t.b.Write(binary.AppendUvarint(nil, uint64(n)))
+}
+
+func (t *thriftWriter) i32List(id int16, vs []int32) {
+	t.field(id, thriftList)
+	t.listHeader(len(vs), thriftI32)
+	for _, v := range vs {
+		t.varint(int64(v))
+	}
+}
+
+func (t *thriftWriter) stringList(id int16, vs []string) {
+	t.field(id, thriftList)
+	t.listHeader(len(vs), thriftBinary)
+	for _, v := range vs {
+		t.b.Write(binary.AppendUvarint(nil, uint64(len(v))))
+		t.b.WriteString(v)
+	}
+}
+
+// listBegin starts a list of n structs, each written with elemBegin and end.
+func (t *thriftWriter) listBegin(id int16, n int) {
+	t.field(id, thriftList)
+	t.listHeader(n, thriftStruct)
+}
+
+func (t *thriftWriter) structBegin(id int16) {
+	t.field(id, thriftStruct)
+	t.elemBegin()
+}
+
+func (t *thriftWriter) elemBegin() {
+	t.stack = append(t.stack, t.last)
+	t.last = 0
+}
+
+// end ends the current struct, or the top-level one.
+func (t *thriftWriter) end() {
+	t.b.WriteByte(0)
+	if len(t.stack) > 0 {
+		t.last = t.stack[len(t.stack)-1]
+		t.stack = t.stack[:len(t.stack)-1]
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcmd"
+	"github.com/icza/screp/rep/repcore"
+)
+
+// AttackPath is the approximate route a player's force took into an
+// engagement, for drawing arrows on the map.
+type AttackPath struct {
+	PlayerID  int        `json:"playerId"`
+	Waypoints []Waypoint `json:"waypoints"`
+}
+
+// Waypoint is a commanded position, in pixels.
+type Waypoint struct {
+	Frame int     `json:"frame"`
+	Time  float64 `json:"time"`
+	X     int     `json:"x"`
+	Y     int     `json:"y"`
+}
+
+// Limits of the attack path reconstruction
+const (
+	pathLeadSeconds  = 60           // How far back before the fight moves are followed
+	pathMinStep      = 3 * tileSize // Closer waypoints are merged
+	pathMaxWaypoints = 20
+)
+
+// isMoveCmd tells if the command moves units to a position: moves,
+// attack-moves, patrols and right clicks on the ground.
+func isMoveCmd(cmd repcmd.Cmd) bool {
+	switch c := cmd.(type) {
+	case *repcmd.RightClickCmd:
+		return c.UnitTag == 0 || !c.UnitTag.Valid() // Ground clicks carry no unit
+	case *repcmd.TargetedOrderCmd:
+		return c.Order != nil && (c.Order.ID == repcmd.OrderIDMove || c.Order.ID == repcmd.OrderIDAttackMove ||
+			c.Order.Name == "Patrol")
+	}
+	return false
+}
+
+// attackPaths adds the attack paths of the participants to the engagements.
+//
+// The force of a participant is the selection of their first combat command
+// in the fight; the path is made of the move commands issued to any unit of
+// that force in the pathLeadSeconds before, ending at the fight.
+func attackPaths(rp *rep.Replay, engagements []Engagement) {
+	type move struct {
+		frame repcore.Frame
+		pos   repcore.Point
+		tags  []repcmd.UnitTag
+	}
+	type start struct {
+		frame repcore.Frame
+		pos   repcore.Point
+		force []repcmd.UnitTag
+	}
+	players := len(rp.Header.Players)
+	sels := make([]selection, players)
+	moves := make([][]move, players)
+	starts := make([]map[int]*start, len(engagements)) // Per engagement, by player
+
+	for _, cmd := range rp.Commands {
+		base := cmd.BaseCmd()
+		if base == nil || int(base.PlayerID) >= players {
+			continue
+		}
+		pid := int(base.PlayerID)
+		sels[pid].apply(cmd)
+		pos, ok := cmdPos(cmd)
+		if !ok {
+			continue
+		}
+		if isMoveCmd(cmd) {
+			moves[pid] = append(moves[pid], move{frame: base.Frame, pos: pos, tags: sels[pid].current})
+		}
+		if !isCombatCmd(cmd) {
+			continue
+		}
+		for i, e := range engagements {
+			if int(base.Frame) < e.Frame || int(base.Frame) > e.EndFrame ||
+				dist(pos, repcore.Point{X: uint16(e.X), Y: uint16(e.Y)}) >= engagementRadius {
+				continue
+			}
+			if starts[i] == nil {
+				starts[i] = map[int]*start{}
+			}
+			if starts[i][pid] == nil {
+				starts[i][pid] = &start{frame: base.Frame, pos: pos, force: sels[pid].current}
+			}
+			break
+		}
+	}
+
+	lead := secondsToFrames(pathLeadSeconds)
+	for i := range engagements {
+		e := &engagements[i]
+		e.Paths = []AttackPath{}
+		for _, pid := range e.Participants {
+			s := starts[i][pid]
+			if s == nil {
+				continue
+			}
+			var wps []Waypoint
+			add := func(f repcore.Frame, pos repcore.Point) {
+				if n := len(wps); n > 0 && dist(pos, repcore.Point{X: uint16(wps[n-1].X), Y: uint16(wps[n-1].Y)}) < pathMinStep {
+					return
+				}
+				wps = append(wps, Waypoint{Frame: int(f), Time: frameToSeconds(f), X: int(pos.X), Y: int(pos.Y)})
+			}
+			for _, m := range moves[pid] {
+				if m.frame+lead < s.frame || m.frame >= s.frame {
+					continue
+				}
+				for _, t := range m.tags {
+					if hasTag(s.force, t) {
+						add(m.frame, m.pos)
+						break
+					}
+				}
+			}
+			add(s.frame, s.pos)
+			if len(wps) < 2 {
+				continue // Didn't move there (or we can't tell)
+			}
+			if len(wps) > pathMaxWaypoints {
+				wps = wps[len(wps)-pathMaxWaypoints:]
+			}
+			e.Paths = append(e.Paths, AttackPath{PlayerID: pid, Waypoints: wps})
+		}
+	}
+}
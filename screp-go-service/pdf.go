@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Page layout of the generated PDFs (A4, in points)
+const (
+	pdfPageWidth  = 595
+	pdfPageHeight = 842
+	pdfMargin     = 48
+)
+
+// pdfWriter is a minimal PDF generator for text and table documents. It only
+// uses the standard Helvetica fonts, so the output needs no embedded fonts;
+// characters outside Latin-1 are replaced by '?'.
+type pdfWriter struct {
+	pages []*bytes.Buffer
+	page  *bytes.Buffer
+	y     float64 // Baseline of the next line, from the bottom
+}
+
+// pdfCol is a table column; rows are clipped to the column width.
+type pdfCol struct {
+	Title string
+	Width float64
+}
+
+// newline moves down by h points, starting a new page if it doesn't fit.
+func (p *pdfWriter) newline(h float64) {
+	if p.page == nil || p.y-h < pdfMargin {
+		p.page = new(bytes.Buffer)
+		p.pages = append(p.pages, p.page)
+		p.y = pdfPageHeight - pdfMargin
+	}
+	p.y -= h
+}
+
+// text draws s at x on the current line.
+func (p *pdfWriter) text(x, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(p.page, "BT /%s %g Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, p.y, pdfString(s))
+}
+
+// rule draws a horizontal line below the current line.
+func (p *pdfWriter) rule() {
+	fmt.Fprintf(p.page, "0.8 G 0.5 w %d %.2f m %d %.2f l S 0 G\n", pdfMargin, p.y-3, pdfPageWidth-pdfMargin, p.y-3)
+}
+
+// heading starts a section.
+func (p *pdfWriter) heading(s string) {
+	p.newline(26)
+	p.text(pdfMargin, 13, true, s)
+}
+
+// paragraph writes text wrapped to the page width.
+func (p *pdfWriter) paragraph(size float64, s string) {
+	max := int((pdfPageWidth - 2*pdfMargin) / (size * 0.5))
+	var line string
+	for _, w := range strings.Fields(s) {
+		if line != "" && len(line)+1+len(w) > max {
+			p.newline(size * 1.4)
+			p.text(pdfMargin, size, false, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += w
+	}
+	if line != "" {
+		p.newline(size * 1.4)
+		p.text(pdfMargin, size, false, line)
+	}
+}
+
+// table writes a table, repeating the header on page breaks.
+func (p *pdfWriter) table(cols []pdfCol, rows [][]string) {
+	const size, height = 9, 13
+	header := func() {
+		p.newline(height + 4)
+		x := float64(pdfMargin)
+		for _, c := range cols {
+			p.text(x, size, true, c.Title)
+			x += c.Width
+		}
+		p.rule()
+	}
+	header()
+	for _, row := range rows {
+		page := p.page
+		p.newline(height)
+		if p.page != page {
+			p.y += height
+			header()
+			p.newline(height)
+		}
+		x := float64(pdfMargin)
+		for i, c := range cols {
+			if i < len(row) {
+				p.text(x, size, false, clipText(row[i], int(c.Width/(size*0.5))-1))
+			}
+			x += c.Width
+		}
+	}
+}
+
+// bytes assembles the document.
+func (p *pdfWriter) bytes() []byte {
+	if len(p.pages) == 0 {
+		p.newline(0)
+	}
+	var buf bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	buf.WriteString("%PDF-1.4\n")
+
+	// Objects 1-4 are fixed, then a page and its content per page
+	var kids []string
+	for i := range p.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 5+2*i))
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(p.pages)))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, page := range p.pages {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 6+2*i))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.Bytes()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, o := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", o)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.Bytes()
+}
+
+// pdfString escapes s for a PDF string literal in WinAnsiEncoding.
+func pdfString(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case r < ' ':
+			// Control characters (e.g. color codes of map names) are dropped
+		case r < 256:
+			sb.WriteByte(byte(r))
+		default:
+			sb.WriteByte('?')
+		}
+	}
+	return sb.String()
+}
+
+// clipText shortens s to at most n characters.
+func clipText(s string, n int) string {
+	if r := []rune(s); len(r) > n && n > 1 {
+		return string(r[:n-1]) + "."
+	}
+	return s
+}
@@ -0,0 +1,1299 @@
+// Package client is a Go client for the replay parser service.
+//
+// Usage:
+//
+//	c := client.New("https://starcraft-replay-parser.fly.dev")
+//	res, err := c.ParseFile(ctx, "game.rep")
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type PlayerInfo struct {
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	Slug         string `json:"slug"`
+	NameEncoding string `json:"nameEncoding"`
+	Race         string `json:"race"`
+	Team         int    `json:"team"`
+	APM          int    `json:"apm"`
+	EAPM         int    `json:"eapm"`
+
+	Hotkeys       HotkeyUsage        `json:"hotkeys"`
+	Production    []FacilityUsage    `json:"production"`
+	UnitsOrdered  map[string]int     `json:"unitsOrdered"`
+	WorkerPulls   []WorkerPull       `json:"workerPulls"`
+	Positioning   Positioning        `json:"positioning"`
+	StaticDefense StaticDefense      `json:"staticDefense"`
+	Upgrades      []UpgradeBenchmark `json:"upgrades"`
+	Spells        SpellStats         `json:"spells"`
+	Milestones    Milestones         `json:"milestones"`
+	Scouting      Scouting           `json:"scouting"`
+	RaceStats     RaceStats          `json:"raceStats"`
+}
+
+// Milestones are the time-to-first-X timings of a player, in seconds, nil if
+// the player never got there.
+type Milestones struct {
+	FirstGas          *float64 `json:"firstGas"`
+	FirstExpansion    *float64 `json:"firstExpansion"`
+	FirstTechBuilding *float64 `json:"firstTechBuilding"`
+	TechBuilding      string   `json:"techBuilding,omitempty"`
+	FirstArmyUnit     *float64 `json:"firstArmyUnit"`
+	ArmyUnit          string   `json:"armyUnit,omitempty"`
+	FirstUpgrade      *float64 `json:"firstUpgrade"` // Upgrade or tech research started
+	Upgrade           string   `json:"upgrade,omitempty"`
+}
+
+// Scouting lists the scouts that revealed new enemy building types and the
+// player's first new decision after each, nil latencies if none.
+type Scouting struct {
+	Scouts     []Scout  `json:"scouts"`
+	Reacted    int      `json:"reacted"`
+	AvgLatency *float64 `json:"avgLatency"`
+}
+
+type Scout struct {
+	TargetPlayerID int      `json:"targetPlayerId"`
+	Frame          int      `json:"frame"`
+	Time           float64  `json:"time"`
+	X              int      `json:"x"`
+	Y              int      `json:"y"`
+	Revealed       []string `json:"revealed"`
+	Reaction       string   `json:"reaction,omitempty"`
+	ReactionTime   *float64 `json:"reactionTime,omitempty"`
+	Latency        *float64 `json:"latency,omitempty"`
+}
+
+// RaceStats is the race-specific section of a player, only the field of the
+// player's race is set.
+type RaceStats struct {
+	Terran  *TerranStats  `json:"terran,omitempty"`
+	Protoss *ProtossStats `json:"protoss,omitempty"`
+	Zerg    *ZergStats    `json:"zerg,omitempty"`
+}
+
+type TerranStats struct {
+	Comsats        int           `json:"comsats"`
+	FirstComsat    *float64      `json:"firstComsat,omitempty"`
+	Scans          []ScannerScan `json:"scans"`
+	ScansPerMinute float64       `json:"scansPerMinute"`
+	SupplyDepots   []float64     `json:"supplyDepots"`
+}
+
+type ScannerScan struct {
+	Frame int     `json:"frame"`
+	Time  float64 `json:"time"`
+	X     int     `json:"x"`
+	Y     int     `json:"y"`
+}
+
+type ProtossStats struct {
+	Pylons          []float64  `json:"pylons"`
+	Probes          int        `json:"probes"`
+	ProbeCuts       []ProbeCut `json:"probeCuts"`
+	ProbeCutSeconds float64    `json:"probeCutSeconds"`
+}
+
+type ProbeCut struct {
+	Time            float64 `json:"time"`
+	EndTime         float64 `json:"endTime"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	Workers         int     `json:"workers"`
+}
+
+type ZergStats struct {
+	Overlords     []float64     `json:"overlords"`
+	CreepColonies int           `json:"creepColonies"`
+	CreepSources  []CreepSource `json:"creepSources"`
+	CreepTiles    int           `json:"creepTiles"`
+}
+
+type CreepSource struct {
+	Unit  string  `json:"unit"`
+	Frame int     `json:"frame"`
+	Time  float64 `json:"time"`
+	X     int     `json:"x"`
+	Y     int     `json:"y"`
+}
+
+// SpellStats counts a player's spell casts (storms, EMPs, plagues, ...).
+type SpellStats struct {
+	Total              int            `json:"total"`
+	BySpell            map[string]int `json:"bySpell"`
+	CastsPerEngagement float64        `json:"castsPerEngagement"`
+	Casts              []SpellCast    `json:"casts"`
+}
+
+// SpellCast is one targeted spell cast. EngagementID is 0 if it was cast
+// outside of engagements.
+type SpellCast struct {
+	Spell        string  `json:"spell"`
+	Frame        int     `json:"frame"`
+	Time         float64 `json:"time"`
+	X            int     `json:"x"`
+	Y            int     `json:"y"`
+	EngagementID int     `json:"engagementId,omitempty"`
+}
+
+// UpgradeBenchmark compares when a key upgrade or tech research was started
+// against the matchup's standard timing. Status is early, on-time, late or
+// missing.
+type UpgradeBenchmark struct {
+	Name         string   `json:"name"`
+	ExpectedTime float64  `json:"expectedTime"`
+	ActualTime   *float64 `json:"actualTime,omitempty"`
+	DeltaSeconds *float64 `json:"deltaSeconds,omitempty"`
+	Status       string   `json:"status"`
+}
+
+// StaticDefense sums up a player's static defense: cannons, turrets,
+// bunkers, sunken and spore colonies.
+type StaticDefense struct {
+	Count      int                `json:"count"`
+	Minerals   int                `json:"minerals"`
+	FirstTime  float64            `json:"firstTime,omitempty"`
+	Structures []DefenseStructure `json:"structures"`
+}
+
+// DefenseStructure is one static defense building. Position is unknown
+// (zero) for colony morphs.
+type DefenseStructure struct {
+	Unit  string  `json:"unit"`
+	Frame int     `json:"frame"`
+	Time  float64 `json:"time"`
+	X     int     `json:"x,omitempty"`
+	Y     int     `json:"y,omitempty"`
+}
+
+// Positioning measures where on the map a player issued their commands,
+// relative to their own and the nearest enemy start location.
+type Positioning struct {
+	AvgDistanceFromMain float64          `json:"avgDistanceFromMain"`
+	EnemyTerritoryShare float64          `json:"enemyTerritoryShare"`
+	AggressionIndex     float64          `json:"aggressionIndex"`
+	Curve               []PositionSample `json:"curve"`
+}
+
+// PositionSample holds the positioning metrics of one minute of the game.
+type PositionSample struct {
+	Minute              int     `json:"minute"`
+	Commands            int     `json:"commands"`
+	EnemyTerritoryShare float64 `json:"enemyTerritoryShare"`
+	AggressionIndex     float64 `json:"aggressionIndex"`
+}
+
+// WorkerPull is an interval in which a player pulled workers off mining at
+// one of their own bases. LostMinerals is an estimate.
+type WorkerPull struct {
+	Frame           int     `json:"frame"`
+	Time            float64 `json:"time"`
+	EndFrame        int     `json:"endFrame"`
+	EndTime         float64 `json:"endTime"`
+	Workers         int     `json:"workers"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	LostMinerals    int     `json:"lostMinerals"`
+}
+
+// FacilityUsage estimates how well a player kept one type of production
+// facility busy. Utilization is the busy share of available time, 0..1.
+type FacilityUsage struct {
+	Facility         string          `json:"facility"`
+	Count            int             `json:"count"`
+	Timeline         []FacilityCount `json:"timeline"`
+	UnitsOrdered     int             `json:"unitsOrdered"`
+	BusySeconds      float64         `json:"busySeconds"`
+	AvailableSeconds float64         `json:"availableSeconds"`
+	Utilization      float64         `json:"utilization"`
+}
+
+// FacilityCount is the number of facilities available from Time on.
+type FacilityCount struct {
+	Frame int     `json:"frame"`
+	Time  float64 `json:"time"`
+	Count int     `json:"count"`
+}
+
+// HotkeyUsage breaks down a player's actions into hotkey-driven and
+// mouse-driven ones. Command classification is an estimate.
+type HotkeyUsage struct {
+	Assigns           int     `json:"assigns"`
+	Recalls           int     `json:"recalls"`
+	ClickSelects      int     `json:"clickSelects"`
+	KeyboardCommands  int     `json:"keyboardCommands"`
+	MouseCommands     int     `json:"mouseCommands"`
+	Groups            []int   `json:"groups"`
+	HotkeySelectRatio float64 `json:"hotkeySelectRatio"`
+	HotkeyRatio       float64 `json:"hotkeyRatio"`
+}
+
+type Command struct {
+	PlayerID    int     `json:"playerId"`
+	Frame       int     `json:"frame"`
+	Time        float64 `json:"time"`
+	CommandType string  `json:"commandType"`
+	AbilityName string  `json:"abilityName"`
+	Target      string  `json:"target,omitempty"` // Unit, tech, upgrade or order
+}
+
+type BuildOrder struct {
+	PlayerID int       `json:"playerId"`
+	Sequence []Command `json:"sequence"`
+}
+
+type ReplayResult struct {
+	MapName          string       `json:"mapName"`
+	CanonicalMapName string       `json:"canonicalMapName"`
+	MapHash          string       `json:"mapHash,omitempty"`
+	Clock            string       `json:"clock"`
+	KnownMap         *KnownMap    `json:"knownMap,omitempty"`
+	DurationSeconds  float32      `json:"durationSeconds"`
+	WinnerTeam       int          `json:"winnerTeam,omitempty"` // 0 if unknown
+	Players          []PlayerInfo `json:"players"`
+	BuildOrders      []BuildOrder `json:"buildOrders"`
+	Anomalies        []Anomaly    `json:"anomalies,omitempty"`
+	Suspicions       []Suspicion  `json:"suspicions,omitempty"`
+	Events           []Event      `json:"events,omitempty"`
+	Engagements      []Engagement `json:"engagements,omitempty"`
+	Highlights       []Event      `json:"highlights,omitempty"`
+	Actions          []Command    `json:"actions"`
+}
+
+type HeaderResult struct {
+	MapName          string         `json:"mapName"`
+	CanonicalMapName string         `json:"canonicalMapName"`
+	Frames           int            `json:"frames"`
+	DurationSeconds  float32        `json:"durationSeconds"`
+	StartTime        time.Time      `json:"startTime"`
+	Players          []HeaderPlayer `json:"players"`
+}
+
+type HeaderPlayer struct {
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	Slug         string `json:"slug"`
+	NameEncoding string `json:"nameEncoding"`
+	Race         string `json:"race"`
+	Team         int    `json:"team"`
+}
+
+type BatchItem struct {
+	Name       string        `json:"name"`
+	ReplayHash string        `json:"replayHash,omitempty"`
+	Result     *ReplayResult `json:"result,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Warnings   []string      `json:"warnings,omitempty"` // Degraded dependencies, e.g. replay_not_stored
+}
+
+// BatchFile is a replay or zip archive of replays to be parsed in a batch.
+type BatchFile struct {
+	Name string
+	R    io.Reader
+
+	// Archive tells if R is a zip archive of replays.
+	Archive bool
+}
+
+// Job statuses
+const (
+	JobAwaitingUpload = "awaiting_upload"
+	JobProcessing     = "processing"
+	JobDone           = "done"
+	JobFailed         = "failed"
+)
+
+type Job struct {
+	ID         string          `json:"id"`
+	Status     string          `json:"status"`
+	Error      string          `json:"error,omitempty"`
+	Result     *ReplayResult   `json:"result,omitempty"`
+	ReplayHash string          `json:"replayHash,omitempty"` // Storage key, if the replay is stored
+	Owner      string          `json:"owner,omitempty"`
+	Batch      []BatchItem     `json:"batch,omitempty"`
+	Openings   *OpeningsReport `json:"openings,omitempty"` // Result of an openings analysis
+	Warnings   []string        `json:"warnings,omitempty"` // Degraded dependencies, e.g. replay_not_stored
+	CreatedAt  time.Time       `json:"createdAt"`
+	UpdatedAt  time.Time       `json:"updatedAt"`
+}
+
+// FeatureSet holds per player feature vectors; Features names their entries,
+// documented at GET /features.
+type FeatureSet struct {
+	Version  string       `json:"version"`
+	Features []string     `json:"features"`
+	Rows     []FeatureRow `json:"rows"`
+}
+
+type FeatureRow struct {
+	Replay   string    `json:"replay,omitempty"`
+	PlayerID int       `json:"playerId"`
+	Player   string    `json:"player"`
+	Matchup  string    `json:"matchup,omitempty"`
+	Won      *bool     `json:"won,omitempty"`
+	Values   []float64 `json:"values"`
+}
+
+// OpeningsRequest selects the jobs whose build orders are clustered. Steps
+// defaults to 10 if 0, MaxDistance to 3 if nil.
+type OpeningsRequest struct {
+	JobIDs      []string `json:"jobIds"`
+	Steps       int      `json:"steps,omitempty"`
+	MaxDistance *int     `json:"maxDistance,omitempty"`
+}
+
+// DatasetRequest selects the jobs exported by ExportDataset. Format is csv
+// (default) or parquet.
+type DatasetRequest struct {
+	JobIDs []string `json:"jobIds"`
+	Format string   `json:"format,omitempty"`
+}
+
+// OpeningsReport lists the dominant openings of each matchup.
+type OpeningsReport struct {
+	Replays     int               `json:"replays"`
+	Steps       int               `json:"steps"`
+	MaxDistance int               `json:"maxDistance"`
+	Matchups    []MatchupOpenings `json:"matchups"`
+	MissingJobs []string          `json:"missingJobs,omitempty"`
+}
+
+type MatchupOpenings struct {
+	Matchup  string    `json:"matchup"`
+	Games    int       `json:"games"`
+	Openings []Opening `json:"openings"`
+}
+
+// Opening is a cluster of similar build orders, Build the most common one.
+type Opening struct {
+	Build       []string         `json:"build"`
+	Games       int              `json:"games"`
+	Share       float64          `json:"share"`
+	WinRate     *float64         `json:"winRate,omitempty"`
+	AvgDistance float64          `json:"avgDistance"`
+	Examples    []OpeningExample `json:"examples"`
+}
+
+type OpeningExample struct {
+	JobID      string   `json:"jobId"`
+	Replay     string   `json:"replay,omitempty"`
+	ReplayHash string   `json:"replayHash,omitempty"`
+	Player     string   `json:"player"`
+	Build      []string `json:"build"`
+}
+
+// SimilarRequest searches the replays of JobIDs for the ones most similar
+// to the result of JobID, or its batch item named Replay. Steps defaults to
+// 15 and Limit to 10 if 0; a nil PlayerID compares all players.
+type SimilarRequest struct {
+	JobID    string   `json:"jobId"`
+	Replay   string   `json:"replay,omitempty"`
+	PlayerID *int     `json:"playerId,omitempty"`
+	JobIDs   []string `json:"jobIds"`
+	Steps    int      `json:"steps,omitempty"`
+	Limit    int      `json:"limit,omitempty"`
+}
+
+// SimilarityReport lists the replays most similar to the query, best first.
+type SimilarityReport struct {
+	Query       SimilarQuery    `json:"query"`
+	Steps       int             `json:"steps"`
+	Searched    int             `json:"searched"`
+	Matches     []SimilarReplay `json:"matches"`
+	MissingJobs []string        `json:"missingJobs,omitempty"`
+}
+
+type SimilarQuery struct {
+	JobID    string   `json:"jobId"`
+	Replay   string   `json:"replay,omitempty"`
+	Map      string   `json:"map"`
+	Matchups []string `json:"matchups"`
+}
+
+// SimilarReplay is a replay found; Score is 0..1, 1 being identical.
+type SimilarReplay struct {
+	JobID      string          `json:"jobId"`
+	Replay     string          `json:"replay,omitempty"`
+	ReplayHash string          `json:"replayHash,omitempty"`
+	Map        string          `json:"map"`
+	Score      float64         `json:"score"`
+	Players    []SimilarPlayer `json:"players"`
+}
+
+type SimilarPlayer struct {
+	Player        string   `json:"player"`
+	Matched       string   `json:"matched"`
+	Matchup       string   `json:"matchup"`
+	Score         float64  `json:"score"`
+	BuildDistance int      `json:"buildDistance"`
+	TimingDelta   *float64 `json:"timingDelta,omitempty"`
+	Won           *bool    `json:"won,omitempty"`
+}
+
+// SearchRequest filters the replays of JobIDs by a part of a player's name
+// or slug, the player's race, a part of the map name and the matchup (e.g.
+// "TvZ", in any order). Empty filters match every replay; Limit defaults to
+// 50 if 0.
+type SearchRequest struct {
+	JobIDs  []string `json:"jobIds"`
+	Player  string   `json:"player,omitempty"`
+	Race    string   `json:"race,omitempty"`
+	Map     string   `json:"map,omitempty"`
+	Matchup string   `json:"matchup,omitempty"`
+	Limit   int      `json:"limit,omitempty"`
+}
+
+// SearchResult lists the replays matching a search, in the order of the jobs.
+type SearchResult struct {
+	Searched    int         `json:"searched"`
+	Total       int         `json:"total"` // Before the limit
+	Hits        []SearchHit `json:"hits"`
+	MissingJobs []string    `json:"missingJobs,omitempty"`
+}
+
+// SearchHit is a replay found, with the players matching the filters.
+type SearchHit struct {
+	JobID           string         `json:"jobId"`
+	Replay          string         `json:"replay,omitempty"`
+	ReplayHash      string         `json:"replayHash,omitempty"`
+	Map             string         `json:"map"`
+	Matchup         string         `json:"matchup"`
+	DurationSeconds float32        `json:"durationSeconds"`
+	WinnerTeam      int            `json:"winnerTeam,omitempty"`
+	Players         []SearchPlayer `json:"players"`
+}
+
+type SearchPlayer struct {
+	Name string `json:"name"`
+	Race string `json:"race"` // First letter
+	Team int    `json:"team"`
+	APM  int    `json:"apm"`
+}
+
+// APMSeries is the APM over time of every player; Values[i] covers the
+// step starting at i*Step seconds.
+type APMSeries struct {
+	Step      int         `json:"step"`
+	Smoothing string      `json:"smoothing"`
+	Window    int         `json:"window,omitempty"`
+	Players   []PlayerAPM `json:"players"`
+}
+
+type PlayerAPM struct {
+	PlayerID int       `json:"playerId"`
+	Name     string    `json:"name"`
+	Values   []float64 `json:"values"`
+}
+
+// SeekIndex maps frames to actions and their byte offsets in the action
+// lines (DownloadActions): keyframe k is at frame k*Interval, and both
+// columns end with the number of actions and the length of the lines.
+type SeekIndex struct {
+	Clock    string      `json:"clock"`
+	Frames   int         `json:"frames"`
+	Interval int         `json:"interval"`
+	Action   []int       `json:"action"`
+	Offset   []int64     `json:"offset"`
+	Events   []SeekEvent `json:"events"`
+}
+
+type SeekEvent struct {
+	Frame  int    `json:"frame"`
+	Kind   string `json:"kind"`
+	Label  string `json:"label"`
+	Action int    `json:"action"`
+	Offset int64  `json:"offset"`
+}
+
+// APMOptions configure APM series. Zero values use the service defaults:
+// rolling smoothing over 60 seconds in steps of 10 seconds. Smoothing is
+// none, rolling or ema.
+type APMOptions struct {
+	Smoothing string
+	Window    int // Seconds
+	Step      int // Seconds
+}
+
+func (o APMOptions) query() string {
+	q := url.Values{}
+	if o.Smoothing != "" {
+		q.Set("smoothing", o.Smoothing)
+	}
+	if o.Window > 0 {
+		q.Set("window", strconv.Itoa(o.Window))
+	}
+	if o.Step > 0 {
+		q.Set("step", strconv.Itoa(o.Step))
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+type UploadTicket struct {
+	JobID     string    `json:"jobId"`
+	UploadURL string    `json:"uploadUrl"`
+	Method    string    `json:"method"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Share is a public, read-only link to the result of a job.
+type Share struct {
+	ID        string    `json:"id"`
+	JobID     string    `json:"jobId"`
+	Path      string    `json:"path"` // Relative to the service
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// DeletionReceipt documents what a deletion request removed.
+type DeletionReceipt struct {
+	ID              string    `json:"id"`
+	Owner           string    `json:"owner,omitempty"`
+	Jobs            []string  `json:"jobs"`
+	Shares          int       `json:"shares"`
+	ReplaysDeleted  []string  `json:"replaysDeleted"`
+	ReplaysRetained []string  `json:"replaysRetained"` // Referenced by jobs of others
+	Uploads         int       `json:"uploads"`
+	Cached          []string  `json:"cached"` // Endpoints caches may keep serving
+	Errors          []string  `json:"errors,omitempty"`
+	DeletedAt       time.Time `json:"deletedAt"`
+}
+
+// Anomaly flags an implausible action pattern (potential bot/macro use).
+type Anomaly struct {
+	PlayerID       int    `json:"playerId"`
+	Kind           string `json:"kind"`
+	Description    string `json:"description"`
+	EvidenceFrames []int  `json:"evidenceFrames"`
+}
+
+// Suspicion is a command hinting at map hack use, for human review.
+type Suspicion struct {
+	PlayerID       int     `json:"playerId"`
+	TargetPlayerID int     `json:"targetPlayerId"`
+	Frame          int     `json:"frame"`
+	Time           float64 `json:"time"`
+	X              int     `json:"x"`
+	Y              int     `json:"y"`
+	Reason         string  `json:"reason"`
+}
+
+// KnownMap is an entry of the server's known competitive map database.
+type KnownMap struct {
+	Hash   string `json:"hash"`
+	Name   string `json:"name"`
+	Season string `json:"season,omitempty"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// Event is a notable moment of the game, e.g. a proxy building.
+type Event struct {
+	PlayerID    int     `json:"playerId"`
+	Kind        string  `json:"kind"`
+	Frame       int     `json:"frame"`
+	Time        float64 `json:"time"`
+	X           int     `json:"x"`
+	Y           int     `json:"y"`
+	Description string  `json:"description"`
+}
+
+// Engagement is a fight: attack and spell commands clustered in space and
+// time. X and Y are the centroid in pixels.
+type Engagement struct {
+	ID              int     `json:"id"`
+	Name            string  `json:"name"`
+	Frame           int     `json:"frame"`
+	Time            float64 `json:"time"`
+	EndFrame        int     `json:"endFrame"`
+	EndTime         float64 `json:"endTime"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	X               int     `json:"x"`
+	Y               int     `json:"y"`
+	Participants    []int   `json:"participants"`
+	Commands        int     `json:"commands"`
+
+	Paths []AttackPath `json:"paths"`
+}
+
+// AttackPath is the approximate route a player's force took into an
+// engagement.
+type AttackPath struct {
+	PlayerID  int        `json:"playerId"`
+	Waypoints []Waypoint `json:"waypoints"`
+}
+
+// Waypoint is a commanded position, in pixels.
+type Waypoint struct {
+	Frame int     `json:"frame"`
+	Time  float64 `json:"time"`
+	X     int     `json:"x"`
+	Y     int     `json:"y"`
+}
+
+// Diagnostic is a finding of the replay structure or action-rate checks.
+type Diagnostic struct {
+	Kind        string `json:"kind"`
+	Severity    string `json:"severity"`
+	PlayerID    *int   `json:"playerId,omitempty"`
+	Description string `json:"description"`
+}
+
+// IntegrityReport is the screening result of one replay, Score is 0-100.
+type IntegrityReport struct {
+	Name        string       `json:"name,omitempty"`
+	Score       int          `json:"score"`
+	Verdict     string       `json:"verdict"`
+	Anomalies   []Anomaly    `json:"anomalies"`
+	Suspicions  []Suspicion  `json:"suspicions"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// Error is returned when the service responds with a non-2xx status.
+type Error struct {
+	StatusCode int
+	Message    string
+	RetryAfter time.Duration // From Retry-After of 429 and 503 responses, 0 if not given
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("replay parser: %d %s", e.StatusCode, e.Message)
+}
+
+// Client talks to a replay parser service instance.
+type Client struct {
+	// BaseURL of the service, e.g. "http://localhost:8080".
+	BaseURL string
+
+	// HTTPClient used for requests; http.DefaultClient if nil.
+	HTTPClient *http.Client
+
+	// APIKey sent with requests to the service, if set.
+	APIKey string
+
+	// Clock of the times in parse results: "real" (the default if empty)
+	// or "game", see ClockReal and ClockGame. It's sent with every request,
+	// so set it only on clients calling endpoints taking it.
+	Clock string
+}
+
+// Clock conventions of the times in parse results
+const (
+	ClockReal = "real" // Real seconds on "Fastest", like the Remastered timer
+	ClockGame = "game" // Game seconds, like the in-game timer before Remastered
+)
+
+// New returns a client for the service at baseURL.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// Parse uploads the replay read from r and returns the parse result.
+// The upload is streamed, r is not buffered in memory.
+func (c *Client) Parse(ctx context.Context, name string, r io.Reader) (*ReplayResult, error) {
+	var res ReplayResult
+	if err := c.upload(ctx, "/parse", "replay", name, r, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// ParseBatch uploads the files and parses them concurrently on the service.
+// The returned job holds one BatchItem per replay. If async is true, the job is
+// returned while still processing; use WaitJob to wait for it.
+func (c *Client) ParseBatch(ctx context.Context, files []BatchFile, async bool) (*Job, error) {
+	parts := make([]formFile, len(files))
+	for i, f := range files {
+		parts[i] = formFile{"replay", f.Name, f.R}
+		if f.Archive {
+			parts[i].field = "archive"
+		}
+	}
+	path := "/parse/batch"
+	if async {
+		path += "?async=true"
+	}
+	var job Job
+	if err := c.uploadParts(ctx, path, parts, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// AnalyzeOpenings clusters the build orders of the replays of finished jobs
+// and returns a job holding the report in Openings. If async is true, the
+// job is returned while still processing; use WaitJob to wait for it.
+func (c *Client) AnalyzeOpenings(ctx context.Context, req OpeningsRequest, async bool) (*Job, error) {
+	path := "/analysis/openings"
+	if async {
+		path += "?async=true"
+	}
+	var job Job
+	if err := c.postJSON(ctx, path, req, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// FindSimilar returns the replays of the finished jobs req.JobIDs most
+// similar to the query replay: same matchup, closest build orders and
+// milestone timings.
+func (c *Client) FindSimilar(ctx context.Context, req SimilarRequest) (*SimilarityReport, error) {
+	var report SimilarityReport
+	if err := c.postJSON(ctx, "/analysis/similar", req, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// Search returns the replays of the finished jobs req.JobIDs matching the
+// player, race, map and matchup filters.
+func (c *Client) Search(ctx context.Context, req SearchRequest) (*SearchResult, error) {
+	var res SearchResult
+	if err := c.postJSON(ctx, "/search", req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Integrity uploads the replays (and zip archives of replays) for integrity
+// screening and returns a report per replay.
+func (c *Client) Integrity(ctx context.Context, files []BatchFile) ([]IntegrityReport, error) {
+	parts := make([]formFile, len(files))
+	for i, f := range files {
+		parts[i] = formFile{"replay", f.Name, f.R}
+		if f.Archive {
+			parts[i].field = "archive"
+		}
+	}
+	var reports []IntegrityReport
+	if err := c.uploadParts(ctx, "/integrity", parts, &reports); err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+// ParseHeader uploads the replay read from r and returns its header only
+// (players, map, duration, start time). It is much faster than Parse.
+func (c *Client) ParseHeader(ctx context.Context, name string, r io.Reader) (*HeaderResult, error) {
+	var res HeaderResult
+	if err := c.upload(ctx, "/parse/header", "replay", name, r, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Features uploads a replay and returns the feature vector of every player.
+func (c *Client) Features(ctx context.Context, name string, r io.Reader) (*FeatureSet, error) {
+	var set FeatureSet
+	if err := c.upload(ctx, "/features", "replay", name, r, &set); err != nil {
+		return nil, err
+	}
+	return &set, nil
+}
+
+// JobFeatures returns the feature vectors of a finished job, one per player
+// of every replay for batches.
+func (c *Client) JobFeatures(ctx context.Context, jobID string) (*FeatureSet, error) {
+	var set FeatureSet
+	if err := c.get(ctx, "/features/"+url.PathEscape(jobID), &set); err != nil {
+		return nil, err
+	}
+	return &set, nil
+}
+
+// APM parses the replay and returns its APM series.
+func (c *Client) APM(ctx context.Context, name string, r io.Reader, opts APMOptions) (*APMSeries, error) {
+	var series APMSeries
+	if err := c.upload(ctx, "/apm"+opts.query(), "replay", name, r, &series); err != nil {
+		return nil, err
+	}
+	return &series, nil
+}
+
+// JobAPM returns the APM series of a finished job's replay.
+func (c *Client) JobAPM(ctx context.Context, jobID string, opts APMOptions) (*APMSeries, error) {
+	var series APMSeries
+	if err := c.get(ctx, "/apm/"+url.PathEscape(jobID)+opts.query(), &series); err != nil {
+		return nil, err
+	}
+	return &series, nil
+}
+
+// SeekIndex returns the seek index of a finished job's replay, or of the
+// batch replay named replay. A zero interval uses the default of 24 frames.
+func (c *Client) SeekIndex(ctx context.Context, jobID, replay string, interval int) (*SeekIndex, error) {
+	q := url.Values{}
+	if replay != "" {
+		q.Set("replay", replay)
+	}
+	if interval > 0 {
+		q.Set("interval", strconv.Itoa(interval))
+	}
+	path := "/seek/" + url.PathEscape(jobID)
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	var idx SeekIndex
+	if err := c.get(ctx, path, &idx); err != nil {
+		return nil, err
+	}
+	return &idx, nil
+}
+
+// DownloadActions writes the action lines of a finished job's replay, which
+// the offsets of the seek index point into, to w.
+func (c *Client) DownloadActions(ctx context.Context, jobID, replay string, w io.Writer) error {
+	path := "/seek/" + url.PathEscape(jobID) + "/actions"
+	if replay != "" {
+		path += "?replay=" + url.QueryEscape(replay)
+	}
+	return c.get(ctx, path, pipeSink{w})
+}
+
+// ParseFile uploads the replay file at path and returns the parse result.
+func (c *Client) ParseFile(ctx context.Context, path string) (*ReplayResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return c.Parse(ctx, filepath.Base(path), f)
+}
+
+// upload streams r as a multipart form file field to path and decodes the
+// JSON response into out.
+func (c *Client) upload(ctx context.Context, path, field, name string, r io.Reader, out interface{}) error {
+	return c.uploadParts(ctx, path, []formFile{{field, name, r}}, out)
+}
+
+type formFile struct {
+	field, name string
+	r           io.Reader
+}
+
+// uploadParts streams files as a multipart form to path and decodes the
+// JSON response into out.
+func (c *Client) uploadParts(ctx context.Context, path string, files []formFile, out interface{}) error {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		var err error
+		for _, f := range files {
+			var part io.Writer
+			if part, err = mw.CreateFormFile(f.field, f.name); err != nil {
+				break
+			}
+			if _, err = io.Copy(part, f.r); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+path, pr)
+	if err != nil {
+		pr.Close()
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return c.do(req, out)
+}
+
+func (c *Client) get(ctx context.Context, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, out)
+}
+
+func (c *Client) do(req *http.Request, out interface{}) error {
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	c.authorize(req)
+	c.selectClock(req)
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		e := &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			e.RetryAfter = time.Duration(secs) * time.Second
+		}
+		return e
+	}
+	switch out := out.(type) {
+	case nil:
+		return nil
+	case pipeSink:
+		_, err = io.Copy(out.w, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// selectClock adds the clock parameter to requests to the service. The
+// service rejects the game clock on endpoints responding in real seconds
+// only, with a 400 *Error.
+func (c *Client) selectClock(req *http.Request) {
+	if c.Clock == "" || !strings.HasPrefix(req.URL.String(), c.BaseURL+"/") {
+		return
+	}
+	q := req.URL.Query()
+	q.Set("clock", c.Clock)
+	req.URL.RawQuery = q.Encode()
+}
+
+// authorize adds the API key to requests to the service, but not to those
+// to presigned storage URLs.
+func (c *Client) authorize(req *http.Request) {
+	if c.APIKey != "" && strings.HasPrefix(req.URL.String(), c.BaseURL+"/") {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+}
+
+// Health reports whether the service is up.
+func (c *Client) Health(ctx context.Context) error {
+	return c.get(ctx, "/health", nil)
+}
+
+// ParseStream is like Parse, but instead of collecting all actions into
+// ReplayResult.Actions it decodes the response incrementally and calls fn
+// for each action. Use it for long games where the full action list is large.
+// Decoding stops at the first error returned by fn.
+func (c *Client) ParseStream(ctx context.Context, name string, r io.Reader, fn func(Command) error) (*ReplayResult, error) {
+	pr, pw := io.Pipe()
+	res := &ReplayResult{}
+	done := make(chan error, 1)
+	go func() {
+		err := decodeStream(pr, res, fn)
+		pr.CloseWithError(err)
+		done <- err
+	}()
+
+	if err := c.upload(ctx, "/parse", "replay", name, r, pipeSink{pw}); err != nil {
+		pw.CloseWithError(err)
+		<-done
+		return nil, err
+	}
+	pw.Close()
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// pipeSink makes do copy the response body into a pipe instead of decoding it.
+type pipeSink struct{ w io.Writer }
+
+// decodeStream decodes a ReplayResult object from r, streaming the actions
+// array element by element into fn.
+func decodeStream(r io.Reader, res *ReplayResult, fn func(Command) error) error {
+	dec := json.NewDecoder(r)
+	if t, err := dec.Token(); err != nil {
+		return err
+	} else if t != json.Delim('{') {
+		return fmt.Errorf("replay parser: unexpected token %v", t)
+	}
+
+	fields := map[string]json.RawMessage{}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := t.(string)
+		if key != "actions" {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return err
+			}
+			fields[key] = raw
+			continue
+		}
+
+		if t, err := dec.Token(); err != nil {
+			return err
+		} else if t == nil {
+			continue // "actions": null
+		}
+		for dec.More() {
+			var cmd Command
+			if err := dec.Decode(&cmd); err != nil {
+				return err
+			}
+			if err := fn(cmd); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+	}
+
+	head, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(head, res)
+}
+
+// Fetch has the service download a replay from a community replay host by URL
+// (replay file or replay page) and parse it.
+func (c *Client) Fetch(ctx context.Context, replayURL string) (*ReplayResult, error) {
+	return c.fetch(ctx, map[string]string{"url": replayURL})
+}
+
+// FetchByID is like Fetch, with the replay identified by host connector name
+// (e.g. "repmastered") and the host's replay ID.
+func (c *Client) FetchByID(ctx context.Context, host, id string) (*ReplayResult, error) {
+	return c.fetch(ctx, map[string]string{"host": host, "id": id})
+}
+
+func (c *Client) fetch(ctx context.Context, body map[string]string) (*ReplayResult, error) {
+	var res ReplayResult
+	if err := c.postJSON(ctx, "/fetch", body, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (c *Client) postJSON(ctx context.Context, path string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req, out)
+}
+
+// GetJob returns the job with the given ID.
+func (c *Client) GetJob(ctx context.Context, id string) (*Job, error) {
+	var job Job
+	if err := c.get(ctx, "/jobs/"+url.PathEscape(id), &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// DeleteJob permanently deletes the job and its data.
+func (c *Client) DeleteJob(ctx context.Context, id string) (*DeletionReceipt, error) {
+	var rc DeletionReceipt
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.BaseURL+"/jobs/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.do(req, &rc); err != nil {
+		return nil, err
+	}
+	return &rc, nil
+}
+
+// WaitJob polls the job every interval until it is done or failed.
+func (c *Client) WaitJob(ctx context.Context, id string, interval time.Duration) (*Job, error) {
+	for {
+		job, err := c.GetJob(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if job.Status == JobDone || job.Status == JobFailed {
+			return job, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// ParseViaUpload uploads the replay directly to the service's object store
+// using a presigned URL and starts parsing it. size is the replay size in bytes.
+// Use WaitJob to wait for the result.
+func (c *Client) ParseViaUpload(ctx context.Context, r io.Reader, size int64) (*Job, error) {
+	var ticket UploadTicket
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/uploads", nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.do(req, &ticket); err != nil {
+		return nil, err
+	}
+
+	req, err = http.NewRequestWithContext(ctx, ticket.Method, ticket.UploadURL, r)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	if err := c.do(req, nil); err != nil {
+		return nil, err
+	}
+
+	var job Job
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/jobs/"+url.PathEscape(ticket.JobID)+"/complete", nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.do(req, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Resumable upload settings of ParseResumable
+const (
+	ResumableChunkSize = 4 << 20
+	resumableRetries   = 5
+)
+
+// ParseResumable uploads a replay, or a zip archive of replays if name ends
+// in .zip, with the tus resumable upload protocol and starts parsing it.
+// Failed chunks are retried from the offset the service has. Use WaitJob to
+// wait for the result.
+func (c *Client) ParseResumable(ctx context.Context, name string, r io.ReaderAt, size int64) (*Job, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/tus", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Upload-Length", fmt.Sprint(size))
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte(name)))
+	var job Job
+	if err := c.do(req, &job); err != nil {
+		return nil, err
+	}
+	uploadURL := c.BaseURL + "/tus/" + url.PathEscape(job.ID)
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	// send sends the chunk at offset and returns the offset the service has
+	send := func(offset int64) (int64, error) {
+		n := size - offset
+		if n > ResumableChunkSize {
+			n = ResumableChunkSize
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPatch, uploadURL, io.NewSectionReader(r, offset, n))
+		if err != nil {
+			return offset, err
+		}
+		req.ContentLength = n
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		req.Header.Set("Upload-Offset", fmt.Sprint(offset))
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+		c.authorize(req)
+		resp, err := hc.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusNoContent {
+				return offset + n, nil
+			}
+			err = &Error{StatusCode: resp.StatusCode, Message: resp.Status}
+		}
+		// Ask where to resume from
+		req, herr := http.NewRequestWithContext(ctx, http.MethodHead, uploadURL, nil)
+		if herr != nil {
+			return offset, err
+		}
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		c.authorize(req)
+		if resp, herr := hc.Do(req); herr == nil {
+			resp.Body.Close()
+			if o, perr := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64); resp.StatusCode == http.StatusOK && perr == nil {
+				offset = o
+			}
+		}
+		return offset, err
+	}
+
+	for offset, failures := int64(0), 0; offset < size; {
+		next, err := send(offset)
+		if err != nil {
+			if failures++; failures > resumableRetries || ctx.Err() != nil {
+				return nil, err
+			}
+			time.Sleep(time.Duration(failures) * time.Second)
+		} else {
+			failures = 0
+		}
+		offset = next
+	}
+	job.Status = JobProcessing
+	return &job, nil
+}
+
+// ExportDataset writes the labeled training dataset of the jobs' replays
+// to w: a zip of the data file and manifest.json.
+func (c *Client) ExportDataset(ctx context.Context, req DatasetRequest, w io.Writer) error {
+	return c.postJSON(ctx, "/datasets", req, pipeSink{w})
+}
+
+// DownloadArchive writes the zip of all results of a finished job to w: per
+// replay result.json, features.csv and the report in format, html (or "")
+// or pdf, and index.json.
+func (c *Client) DownloadArchive(ctx context.Context, jobID, format string, w io.Writer) error {
+	path := "/jobs/" + url.PathEscape(jobID) + "/archive"
+	if format != "" {
+		path += "?format=" + url.QueryEscape(format)
+	}
+	return c.get(ctx, path, pipeSink{w})
+}
+
+// DownloadReplay writes the stored replay with the given hash to w. The
+// replay must belong to the job.
+func (c *Client) DownloadReplay(ctx context.Context, jobID, hash string, w io.Writer) error {
+	return c.get(ctx, "/replays/"+url.PathEscape(hash)+"?job="+url.QueryEscape(jobID), pipeSink{w})
+}
+
+// ShareJob creates a public share link for the result of a finished job. A
+// zero expiresIn uses the service default.
+func (c *Client) ShareJob(ctx context.Context, jobID string, expiresIn time.Duration) (*Share, error) {
+	var share Share
+	body := map[string]int{}
+	if expiresIn > 0 {
+		body["expiresIn"] = int(expiresIn.Seconds())
+	}
+	if err := c.postJSON(ctx, "/jobs/"+url.PathEscape(jobID)+"/share", body, &share); err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
+
+// GetShared returns the result of a share link.
+func (c *Client) GetShared(ctx context.Context, shareID string) (*ReplayResult, error) {
+	var res ReplayResult
+	if err := c.get(ctx, "/s/"+url.PathEscape(shareID), &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
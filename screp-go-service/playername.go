@@ -0,0 +1,148 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/icza/screp/rep"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Encodings of player names in the replay
+const (
+	NameEncodingUTF8   = "utf-8" // Also plain ASCII
+	NameEncodingCP949  = "cp949" // Korean clients
+	NameEncodingLatin1 = "latin-1"
+)
+
+// maxSlugLength is the maximum length of player slugs, in bytes.
+const maxSlugLength = 48
+
+// decodeName decodes a raw player name and normalizes it: valid UTF-8 is
+// taken as is, otherwise CP949 if it decodes to Hangul only, Latin-1 (as
+// Windows-1252) else. Any byte string decodes as Latin-1, so CP949 has to
+// prove itself; accented Latin-1 names often happen to be valid CP949, but
+// of Hanja and symbols, which player names are hardly made of. The name is
+// put in NFC form, with color codes and other control characters removed.
+func decodeName(raw string) (name, encoding string) {
+	name, encoding = raw, NameEncodingUTF8
+	if !utf8.ValidString(raw) {
+		name, encoding = decodeLatin1(raw), NameEncodingLatin1
+		if kr, err := korean.EUCKR.NewDecoder().String(raw); err == nil && hangulOnly(kr) {
+			name, encoding = kr, NameEncodingCP949
+		}
+	}
+
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, norm.NFC.String(name))
+	return strings.TrimSpace(name), encoding
+}
+
+// decodeLatin1 decodes Windows-1252, the Latin-1 superset of western
+// Windows clients.
+func decodeLatin1(raw string) string {
+	s, err := charmap.Windows1252.NewDecoder().String(raw)
+	if err != nil {
+		return strings.ToValidUTF8(raw, "")
+	}
+	return s
+}
+
+// hangulOnly tells if all non-ASCII characters of s are Hangul syllables or
+// compatibility jamo.
+func hangulOnly(s string) bool {
+	for _, r := range s {
+		if r >= utf8.RuneSelf && !(r >= 0xAC00 && r <= 0xD7A3) && !(r >= 0x3131 && r <= 0x318E) {
+			return false // Also the replacement character of invalid data
+		}
+	}
+	return true
+}
+
+// normalizeNames replaces the player names of the replay, which screp
+// decodes as UTF-8 or EUC-KR, with decodeName's, so analyses describe
+// players by their normalized names.
+func normalizeNames(rp *rep.Replay) {
+	if rp.Header == nil {
+		return
+	}
+	for _, p := range rp.Header.Players {
+		p.Name, _ = decodeName(p.RawName)
+	}
+}
+
+// Revised Romanization of the initial, medial and final jamo of Hangul
+// syllables, without the sound changes between syllables.
+var (
+	hangulInitials = []string{"g", "kk", "n", "d", "tt", "r", "m", "b", "pp", "s", "ss", "", "j", "jj", "ch", "k", "t", "p", "h"}
+	hangulMedials  = []string{"a", "ae", "ya", "yae", "eo", "e", "yeo", "ye", "o", "wa", "wae", "oe", "yo", "u", "wo", "we", "wi", "yu", "eu", "ui", "i"}
+	hangulFinals   = []string{"", "k", "k", "k", "n", "n", "n", "t", "l", "k", "m", "l", "l", "l", "p", "l", "m", "p", "p", "t", "t", "ng", "t", "t", "k", "t", "p", "t"}
+)
+
+// nameSlug returns an ASCII slug of a player name for filenames and URLs:
+// lowercase letters and digits separated by single dashes. Accents are
+// dropped, Hangul is romanized and other characters separate words, so
+// "[NC]Flash" becomes "nc-flash" and "이영호" "iyeongho". Returns "" if
+// nothing is left, e.g. for Chinese or Cyrillic names.
+func nameSlug(name string) string {
+	var b strings.Builder
+	dash := false
+	word := func(s string) {
+		if dash && b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		dash = false
+		b.WriteString(s)
+	}
+	for _, r := range norm.NFC.String(name) {
+		if r >= 0xAC00 && r <= 0xD7A3 {
+			s := int(r - 0xAC00)
+			word(hangulInitials[s/588] + hangulMedials[s%588/28] + hangulFinals[s%28])
+			continue
+		}
+		for _, d := range norm.NFKD.String(string(r)) {
+			switch {
+			case d >= 'a' && d <= 'z' || d >= '0' && d <= '9':
+				word(string(d))
+			case d >= 'A' && d <= 'Z':
+				word(string(d + 'a' - 'A'))
+			case unicode.Is(unicode.Mn, d):
+				// Accents of decomposed letters
+			default:
+				dash = true
+			}
+		}
+	}
+	slug := b.String()
+	if len(slug) > maxSlugLength {
+		slug = strings.TrimRight(slug[:maxSlugLength], "-")
+	}
+	return slug
+}
+
+// playerSlugs returns the slugs of the players' names, unique in the
+// replay: "player-<id>" if a name has none, and a "-2", "-3", ... suffix for
+// repeated ones.
+func playerSlugs(players []*rep.Player) []string {
+	slugs := make([]string, len(players))
+	seen := map[string]int{}
+	for i, p := range players {
+		s := nameSlug(p.Name)
+		if s == "" {
+			s = "player-" + strconv.Itoa(i)
+		}
+		if seen[s]++; seen[s] > 1 {
+			s += "-" + strconv.Itoa(seen[s])
+		}
+		slugs[i] = s
+	}
+	return slugs
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Pools reused across requests to keep per-request allocations (and GC work)
+// low under sustained load. Objects that grew unusually large are dropped
+// instead of being pooled, so one huge replay doesn't pin its memory forever.
+//
+// Only the action slices and response buffers are pooled: they are the
+// allocations that grow with the number of commands. The per-player maps
+// and slices of the analyses end up in the result, which jobs and share
+// links retain, and are small next to the action list. The replay's own
+// command slice belongs to screp.
+const (
+	maxPooledCommands = 1 << 20
+	maxPooledBuffer   = 64 << 20
+)
+
+var commandSlicePool = sync.Pool{
+	New: func() interface{} {
+		s := make([]Command, 0, 4096)
+		return &s
+	},
+}
+
+// getCommandSlice returns an empty slice with capacity for at least n commands.
+func getCommandSlice(n int) []Command {
+	s := *commandSlicePool.Get().(*[]Command)
+	if cap(s) < n {
+		s = make([]Command, 0, n)
+	}
+	return s[:0]
+}
+
+func putCommandSlice(s []Command) {
+	if s == nil || cap(s) > maxPooledCommands {
+		return
+	}
+	s = s[:0]
+	commandSlicePool.Put(&s)
+}
+
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() > maxPooledBuffer {
+		return
+	}
+	b.Reset()
+	bufferPool.Put(b)
+}
+
+// release returns the pooled memory of the result. The result must not be
+// used afterwards, so only call it for results that aren't retained
+// (e.g. not for results stored in a job).
+func (res *ReplayResult) release() {
+	putCommandSlice(res.Actions)
+	res.Actions = nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"math"
+
+	"github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcmd"
+)
+
+// Positioning measures where on the map a player issued their commands,
+// relative to their own and the nearest enemy start location. Only commands
+// with a target position count (moves, attacks, right clicks, builds).
+type Positioning struct {
+	AvgDistanceFromMain float64 `json:"avgDistanceFromMain"` // In tiles
+	// EnemyTerritoryShare is the share of commands closer to an enemy's
+	// start location than to the own.
+	EnemyTerritoryShare float64          `json:"enemyTerritoryShare"`
+	AggressionIndex     float64          `json:"aggressionIndex"`
+	Curve               []PositionSample `json:"curve"` // Per minute
+}
+
+// PositionSample holds the positioning metrics of one minute of the game.
+//
+// AggressionIndex is the mean forwardness of the commands, 0 at the own and
+// 1 at the enemy's start location, with attack commands weighted double.
+type PositionSample struct {
+	Minute              int     `json:"minute"`
+	Commands            int     `json:"commands"`
+	EnemyTerritoryShare float64 `json:"enemyTerritoryShare"`
+	AggressionIndex     float64 `json:"aggressionIndex"`
+}
+
+type positionAcc struct {
+	n, enemy         int
+	dist             float64
+	forward, weights float64
+}
+
+func (a *positionAcc) add(d, forward float64, attack bool) {
+	w := 1.0
+	if attack {
+		w = 2
+	}
+	a.n++
+	a.dist += d
+	if forward > 0.5 {
+		a.enemy++
+	}
+	a.forward += w * forward
+	a.weights += w
+}
+
+// positioning computes the positioning metrics of every player. Players
+// without a known start location or enemy get zero metrics.
+func positioning(rp *rep.Replay) []Positioning {
+	starts := startLocations(rp)
+	res := make([]Positioning, len(rp.Header.Players))
+	totals := make([]positionAcc, len(res))
+	minutes := make([][]positionAcc, len(res))
+
+	for _, cmd := range rp.Commands {
+		base := cmd.BaseCmd()
+		if base == nil || int(base.PlayerID) >= len(res) {
+			continue
+		}
+		pos, ok := cmdPos(cmd)
+		if !ok {
+			continue
+		}
+		pid := int(base.PlayerID)
+		own, ok := starts[pid]
+		if !ok {
+			continue
+		}
+		enemy := math.Inf(1)
+		for epid, s := range starts {
+			if isEnemy(rp, pid, epid) {
+				enemy = math.Min(enemy, dist(pos, s))
+			}
+		}
+		if math.IsInf(enemy, 1) {
+			continue
+		}
+
+		d := dist(pos, own)
+		forward := 0.0
+		if d+enemy > 0 {
+			forward = d / (d + enemy)
+		}
+		attack := false
+		if c, ok := cmd.(*repcmd.TargetedOrderCmd); ok && c.Order != nil {
+			attack = repcmd.IsOrderIDKindAttack(c.Order.ID)
+		}
+
+		m := int(frameToSeconds(base.Frame) / 60)
+		for len(minutes[pid]) <= m {
+			minutes[pid] = append(minutes[pid], positionAcc{})
+		}
+		minutes[pid][m].add(d, forward, attack)
+		totals[pid].add(d, forward, attack)
+	}
+
+	for pid := range res {
+		t := totals[pid]
+		res[pid].Curve = []PositionSample{}
+		if t.n == 0 {
+			continue
+		}
+		res[pid].AvgDistanceFromMain = round(t.dist/float64(t.n)/tileSize, 1)
+		res[pid].EnemyTerritoryShare = ratio(t.enemy, t.n)
+		res[pid].AggressionIndex = round(t.forward/t.weights, 3)
+		for m, a := range minutes[pid] {
+			s := PositionSample{Minute: m, Commands: a.n}
+			if a.n > 0 {
+				s.EnemyTerritoryShare = ratio(a.enemy, a.n)
+				s.AggressionIndex = round(a.forward/a.weights, 3)
+			}
+			res[pid].Curve = append(res[pid].Curve, s)
+		}
+	}
+	return res
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"math"
+
+	"github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcmd"
+	"github.com/icza/screp/rep/repcore"
+)
+
+// FacilityUsage estimates how well a player kept one type of production
+// facility busy.
+//
+// Facilities are counted from build commands (cancelled or never started
+// buildings can't be told apart), available once their build time elapsed.
+// Busy time is the build time of the units ordered for the facility type.
+// For hatcheries the capacity is the larva spawn rate instead.
+type FacilityUsage struct {
+	Facility         string          `json:"facility"`
+	Count            int             `json:"count"` // At the end of the game
+	Timeline         []FacilityCount `json:"timeline"`
+	UnitsOrdered     int             `json:"unitsOrdered"`
+	BusySeconds      float64         `json:"busySeconds"`
+	AvailableSeconds float64         `json:"availableSeconds"`
+	Utilization      float64         `json:"utilization"` // Busy share of available time, 0..1
+}
+
+// FacilityCount is the number of facilities available from Time on.
+type FacilityCount struct {
+	Frame int     `json:"frame"`
+	Time  float64 `json:"time"`
+	Count int     `json:"count"`
+}
+
+// larvaFrames is the larva spawn interval of a hatchery.
+const larvaFrames = 342
+
+// facilityBuildFrames are the build times of the production facilities.
+var facilityBuildFrames = map[uint16]repcore.Frame{
+	repcmd.UnitIDCommandCenter:    1800,
+	repcmd.UnitIDBarracks:         1200,
+	repcmd.UnitIDFactory:          1200,
+	repcmd.UnitIDStarport:         1050,
+	repcmd.UnitIDNexus:            1800,
+	repcmd.UnitIDGateway:          900,
+	repcmd.UnitIDRoboticsFacility: 1200,
+	repcmd.UnitIDStargate:         1050,
+	repcmd.UnitIDHatchery:         1800,
+}
+
+// startingFacilities are the facilities every player of a race starts with.
+var startingFacilities = map[string]uint16{
+	"Terran":  repcmd.UnitIDCommandCenter,
+	"Protoss": repcmd.UnitIDNexus,
+	"Zerg":    repcmd.UnitIDHatchery,
+}
+
+type producedUnit struct {
+	facility uint16
+	frames   repcore.Frame
+}
+
+// producedUnits maps the unit IDs to their production facility and build time.
+var producedUnits = map[uint16]producedUnit{
+	0x07: {repcmd.UnitIDCommandCenter, 300},     // SCV
+	0x00: {repcmd.UnitIDBarracks, 360},          // Marine
+	0x20: {repcmd.UnitIDBarracks, 360},          // Firebat
+	0x22: {repcmd.UnitIDBarracks, 450},          // Medic
+	0x01: {repcmd.UnitIDBarracks, 750},          // Ghost
+	0x02: {repcmd.UnitIDFactory, 450},           // Vulture
+	0x05: {repcmd.UnitIDFactory, 750},           // Siege Tank
+	0x03: {repcmd.UnitIDFactory, 600},           // Goliath
+	0x08: {repcmd.UnitIDStarport, 900},          // Wraith
+	0x0B: {repcmd.UnitIDStarport, 750},          // Dropship
+	0x09: {repcmd.UnitIDStarport, 1200},         // Science Vessel
+	0x3A: {repcmd.UnitIDStarport, 750},          // Valkyrie
+	0x0C: {repcmd.UnitIDStarport, 2000},         // Battlecruiser
+	0x40: {repcmd.UnitIDNexus, 300},             // Probe
+	0x41: {repcmd.UnitIDGateway, 600},           // Zealot
+	0x42: {repcmd.UnitIDGateway, 750},           // Dragoon
+	0x43: {repcmd.UnitIDGateway, 750},           // High Templar
+	0x3D: {repcmd.UnitIDGateway, 750},           // Dark Templar
+	0x45: {repcmd.UnitIDRoboticsFacility, 900},  // Shuttle
+	0x53: {repcmd.UnitIDRoboticsFacility, 1050}, // Reaver
+	0x54: {repcmd.UnitIDRoboticsFacility, 600},  // Observer
+	0x46: {repcmd.UnitIDStargate, 1200},         // Scout
+	0x3C: {repcmd.UnitIDStargate, 600},          // Corsair
+	0x48: {repcmd.UnitIDStargate, 2100},         // Carrier
+	0x47: {repcmd.UnitIDStargate, 2400},         // Arbiter
+	0x29: {repcmd.UnitIDHatchery, larvaFrames},  // Drone
+	0x25: {repcmd.UnitIDHatchery, larvaFrames},  // Zergling
+	0x26: {repcmd.UnitIDHatchery, larvaFrames},  // Hydralisk
+	0x2A: {repcmd.UnitIDHatchery, larvaFrames},  // Overlord
+	0x2B: {repcmd.UnitIDHatchery, larvaFrames},  // Mutalisk
+	0x2F: {repcmd.UnitIDHatchery, larvaFrames},  // Scourge
+	0x2D: {repcmd.UnitIDHatchery, larvaFrames},  // Queen
+	0x27: {repcmd.UnitIDHatchery, larvaFrames},  // Ultralisk
+	0x2E: {repcmd.UnitIDHatchery, larvaFrames},  // Defiler
+}
+
+// productionUsage computes the facility usage of every player, facility
+// types in order of first appearance.
+func productionUsage(rp *rep.Replay) [][]FacilityUsage {
+	type facility struct {
+		ready   []repcore.Frame
+		ordered int
+		busy    repcore.Frame
+	}
+	end := rp.Header.Frames
+	facilities := make([]map[uint16]*facility, len(rp.Header.Players))
+	order := make([][]uint16, len(facilities))
+	get := func(pid int, id uint16) *facility {
+		f := facilities[pid][id]
+		if f == nil {
+			f = &facility{}
+			facilities[pid][id] = f
+			order[pid] = append(order[pid], id)
+		}
+		return f
+	}
+	for pid, p := range rp.Header.Players {
+		facilities[pid] = map[uint16]*facility{}
+		if id, ok := startingFacilities[p.Race.String()]; ok {
+			f := get(pid, id)
+			f.ready = append(f.ready, 0)
+		}
+	}
+
+	for _, cmd := range rp.Commands {
+		base := cmd.BaseCmd()
+		if base == nil || int(base.PlayerID) >= len(facilities) {
+			continue
+		}
+		pid := int(base.PlayerID)
+		switch c := cmd.(type) {
+		case *repcmd.BuildCmd:
+			if c.Unit == nil {
+				break
+			}
+			if frames, ok := facilityBuildFrames[c.Unit.ID]; ok && base.Frame+frames < end {
+				f := get(pid, c.Unit.ID)
+				f.ready = append(f.ready, base.Frame+frames)
+			}
+		case *repcmd.TrainCmd:
+			if c.Unit == nil {
+				break
+			}
+			if u, ok := producedUnits[c.Unit.ID]; ok {
+				f := get(pid, u.facility)
+				f.ordered++
+				f.busy += u.frames
+			}
+		}
+	}
+
+	usage := make([][]FacilityUsage, len(facilities))
+	for pid := range facilities {
+		usage[pid] = []FacilityUsage{}
+		for _, id := range order[pid] {
+			f := facilities[pid][id]
+			fu := FacilityUsage{
+				Facility:     repcmd.UnitByID(id).String(),
+				Count:        len(f.ready),
+				Timeline:     []FacilityCount{},
+				UnitsOrdered: f.ordered,
+				BusySeconds:  math.Round(frameToSeconds(f.busy)),
+			}
+			sortFrames(f.ready)
+			var available repcore.Frame
+			for i, ready := range f.ready {
+				fu.Timeline = append(fu.Timeline, FacilityCount{Frame: int(ready), Time: frameToSeconds(ready), Count: i + 1})
+				available += end - ready
+			}
+			if id == repcmd.UnitIDHatchery {
+				// Hatcheries start with 3 larvae
+				available += repcore.Frame(3*larvaFrames) * repcore.Frame(len(f.ready))
+			}
+			fu.AvailableSeconds = math.Round(frameToSeconds(available))
+			if available > 0 {
+				fu.Utilization = math.Min(1, math.Round(float64(f.busy)/float64(available)*1000)/1000)
+			}
+			usage[pid] = append(usage[pid], fu)
+		}
+	}
+	return usage
+}
+
+// unitsOrdered counts the units every player ordered by name: trained, and
+// for Zerg morphed from larvae or other units. Orders cancelled later still
+// count.
+func unitsOrdered(rp *rep.Replay) []map[string]int {
+	counts := make([]map[string]int, len(rp.Header.Players))
+	for i := range counts {
+		counts[i] = map[string]int{}
+	}
+	for _, cmd := range rp.Commands {
+		c, ok := cmd.(*repcmd.TrainCmd)
+		if !ok || c.Unit == nil || int(c.PlayerID) >= len(counts) {
+			continue
+		}
+		counts[c.PlayerID][c.Unit.String()]++
+	}
+	return counts
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcmd"
+)
+
+// Thresholds of the proxy heuristic
+const (
+	proxyMinDistance = 30 * tileSize // From the nearest own base
+	proxyMaxSeconds  = 600           // Later buildings are rather expansions of the front
+)
+
+// proxyBuildings flags buildings placed far from all bases the owner had at
+// the time (proxy gateways and barracks, hidden tech). Town halls found new
+// bases and are never proxies, neither are refineries.
+func proxyBuildings(rp *rep.Replay) []Event {
+	bases := playerBases(rp)
+	starts := startLocations(rp)
+	maxFrame := secondsToFrames(proxyMaxSeconds)
+
+	var events []Event
+	for _, cmd := range rp.Commands {
+		c, ok := cmd.(*repcmd.BuildCmd)
+		if !ok || c.Unit == nil || c.Frame > maxFrame || int(c.PlayerID) >= len(bases) {
+			continue
+		}
+		if isTownHall(c.Unit) || isResource(c.Unit) {
+			continue
+		}
+		pid := int(c.PlayerID)
+		if _, ok := starts[pid]; !ok {
+			continue // Without a known main there's nothing to measure from
+		}
+		d := nearestBase(bases[pid], c.Pos, c.Frame)
+		if d < proxyMinDistance {
+			continue
+		}
+
+		desc := fmt.Sprintf("proxy %s %.0f tiles from the nearest own base", c.Unit, d/tileSize)
+		for epid, s := range starts {
+			if isEnemy(rp, pid, epid) {
+				desc += fmt.Sprintf(", %.0f tiles from %s's start location", dist(c.Pos, s)/tileSize, rp.Header.Players[epid].Name)
+			}
+		}
+		events = append(events, Event{
+			PlayerID:    pid,
+			Kind:        EventProxy,
+			Frame:       int(c.Frame),
+			Time:        frameToSeconds(c.Frame),
+			X:           int(c.Pos.X),
+			Y:           int(c.Pos.Y),
+			Description: desc,
+		})
+	}
+	return events
+}
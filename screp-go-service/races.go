@@ -0,0 +1,223 @@
+package main
+
+import (
+	"github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcmd"
+	"github.com/icza/screp/rep/repcore"
+)
+
+// RaceStats is the race-specific section of a player: only the field of the
+// player's race is set.
+type RaceStats struct {
+	Terran  *TerranStats  `json:"terran,omitempty"`
+	Protoss *ProtossStats `json:"protoss,omitempty"`
+	Zerg    *ZergStats    `json:"zerg,omitempty"`
+}
+
+// TerranStats covers Comsat Station usage and supply depot timings.
+type TerranStats struct {
+	Comsats        int           `json:"comsats"` // Comsat Stations built
+	FirstComsat    *float64      `json:"firstComsat,omitempty"`
+	Scans          []ScannerScan `json:"scans"`
+	ScansPerMinute float64       `json:"scansPerMinute"` // Since the first Comsat Station
+	SupplyDepots   []float64     `json:"supplyDepots"`   // Build times in seconds
+}
+
+// ScannerScan is a Scanner Sweep cast.
+type ScannerScan struct {
+	Frame int     `json:"frame"`
+	Time  float64 `json:"time"`
+	X     int     `json:"x"`
+	Y     int     `json:"y"`
+}
+
+// ProtossStats covers pylon timings and probe production cuts.
+type ProtossStats struct {
+	Pylons          []float64  `json:"pylons"` // Build times in seconds
+	Probes          int        `json:"probes"` // Probes ordered
+	ProbeCuts       []ProbeCut `json:"probeCuts"`
+	ProbeCutSeconds float64    `json:"probeCutSeconds"` // Total length of the cuts
+}
+
+// ProbeCut is a gap in probe production of more than probeCutSeconds before
+// the player reached probeTarget workers. Only orders are known from the
+// replay, so a cut is measured from order to order.
+type ProbeCut struct {
+	Time            float64 `json:"time"`
+	EndTime         float64 `json:"endTime"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	Workers         int     `json:"workers"` // Probes ordered before the cut, with the starting ones
+}
+
+// ZergStats covers overlord timings and proxies of the creep spread. The
+// spread is estimated from the creep sources built, the real one also
+// depends on terrain, morph times and lost buildings.
+type ZergStats struct {
+	Overlords     []float64     `json:"overlords"` // Order times in seconds
+	CreepColonies int           `json:"creepColonies"`
+	CreepSources  []CreepSource `json:"creepSources"` // Hatcheries and Creep Colonies built
+	CreepTiles    int           `json:"creepTiles"`   // Tiles in creep range of the start location and sources
+}
+
+// CreepSource is a building placed by a Zerg player which spreads creep.
+type CreepSource struct {
+	Unit  string  `json:"unit"`
+	Frame int     `json:"frame"`
+	Time  float64 `json:"time"`
+	X     int     `json:"x"`
+	Y     int     `json:"y"`
+}
+
+// Parameters of the race modules
+const (
+	probeCutSeconds  = 25 // Twice the build time of a probe
+	probeTarget      = 60 // Workers after which players stop building probes on purpose
+	startingWorkers  = 4
+	creepRadiusTiles = 10
+)
+
+// raceModule is the analysis of one race, run for each player of the race.
+// It sets the race's field of the stats.
+type raceModule struct {
+	race    string
+	analyze func(rp *rep.Replay, pid int, stats *RaceStats)
+}
+
+// raceModules are the race modules. Adding a race-specific metric means
+// extending the module's stats, a new section a new module.
+var raceModules = []raceModule{
+	{"Terran", terranModule},
+	{"Protoss", protossModule},
+	{"Zerg", zergModule},
+}
+
+// raceStats runs the race modules for every player.
+func raceStats(rp *rep.Replay) []RaceStats {
+	res := make([]RaceStats, len(rp.Header.Players))
+	for pid, p := range rp.Header.Players {
+		for _, m := range raceModules {
+			if p.Race != nil && p.Race.String() == m.race {
+				m.analyze(rp, pid, &res[pid])
+			}
+		}
+	}
+	return res
+}
+
+// playerCmds returns the commands of the player.
+func playerCmds(rp *rep.Replay, pid int) []repcmd.Cmd {
+	var cmds []repcmd.Cmd
+	for _, cmd := range rp.Commands {
+		if base := cmd.BaseCmd(); base != nil && int(base.PlayerID) == pid {
+			cmds = append(cmds, cmd)
+		}
+	}
+	return cmds
+}
+
+func terranModule(rp *rep.Replay, pid int, stats *RaceStats) {
+	s := &TerranStats{Scans: []ScannerScan{}, SupplyDepots: []float64{}}
+	for _, cmd := range playerCmds(rp, pid) {
+		switch c := cmd.(type) {
+		case *repcmd.BuildCmd:
+			switch {
+			case c.Unit == nil:
+			case c.Unit.ID == repcmd.UnitIDComSat:
+				s.Comsats++
+				if s.FirstComsat == nil {
+					t := round(frameToSeconds(c.Frame), 1)
+					s.FirstComsat = &t
+				}
+			case c.Unit.ID == repcmd.UnitIDSupplyDepot:
+				s.SupplyDepots = append(s.SupplyDepots, round(frameToSeconds(c.Frame), 1))
+			}
+		case *repcmd.TargetedOrderCmd:
+			if c.Order != nil && c.Order.ID == repcmd.OrderIDCastScannerSweep {
+				s.Scans = append(s.Scans, ScannerScan{Frame: int(c.Frame), Time: round(frameToSeconds(c.Frame), 1), X: int(c.Pos.X), Y: int(c.Pos.Y)})
+			}
+		}
+	}
+	if s.FirstComsat != nil {
+		if minutes := (frameToSeconds(rp.Header.Frames) - *s.FirstComsat) / 60; minutes > 0 {
+			s.ScansPerMinute = round(float64(len(s.Scans))/minutes, 2)
+		}
+	}
+	stats.Terran = s
+}
+
+func protossModule(rp *rep.Replay, pid int, stats *RaceStats) {
+	s := &ProtossStats{Pylons: []float64{}, ProbeCuts: []ProbeCut{}}
+	var last repcore.Frame // The game start for the first probe
+	for _, cmd := range playerCmds(rp, pid) {
+		switch c := cmd.(type) {
+		case *repcmd.BuildCmd:
+			if c.Unit != nil && c.Unit.ID == repcmd.UnitIDPylon {
+				s.Pylons = append(s.Pylons, round(frameToSeconds(c.Frame), 1))
+			}
+		case *repcmd.TrainCmd:
+			if c.Unit == nil || c.Unit.ID != 0x40 { // Probe
+				break
+			}
+			workers := startingWorkers + s.Probes
+			if gap := frameToSeconds(c.Frame - last); gap > probeCutSeconds && workers < probeTarget {
+				s.ProbeCuts = append(s.ProbeCuts, ProbeCut{
+					Time:            round(frameToSeconds(last), 1),
+					EndTime:         round(frameToSeconds(c.Frame), 1),
+					DurationSeconds: round(gap, 1),
+					Workers:         workers,
+				})
+				s.ProbeCutSeconds += gap
+			}
+			s.Probes++
+			last = c.Frame
+		}
+	}
+	s.ProbeCutSeconds = round(s.ProbeCutSeconds, 1)
+	stats.Protoss = s
+}
+
+func zergModule(rp *rep.Replay, pid int, stats *RaceStats) {
+	s := &ZergStats{Overlords: []float64{}, CreepSources: []CreepSource{}}
+	var sources []repcore.Point
+	if start, ok := startLocations(rp)[pid]; ok {
+		sources = append(sources, start)
+	}
+	for _, cmd := range playerCmds(rp, pid) {
+		switch c := cmd.(type) {
+		case *repcmd.BuildCmd:
+			if c.Unit == nil || c.Unit.ID != repcmd.UnitIDHatchery && c.Unit.ID != repcmd.UnitIDCreepColony {
+				break
+			}
+			if c.Unit.ID == repcmd.UnitIDCreepColony {
+				s.CreepColonies++
+			}
+			s.CreepSources = append(s.CreepSources, CreepSource{Unit: c.Unit.String(), Frame: int(c.Frame), Time: round(frameToSeconds(c.Frame), 1), X: int(c.Pos.X), Y: int(c.Pos.Y)})
+			sources = append(sources, c.Pos)
+		case *repcmd.TrainCmd:
+			if c.Unit != nil && c.Unit.ID == 0x2A { // Overlord
+				s.Overlords = append(s.Overlords, round(frameToSeconds(c.Frame), 1))
+			}
+		}
+	}
+	s.CreepTiles = creepTiles(rp, sources)
+	stats.Zerg = s
+}
+
+// creepTiles counts the map tiles within creepRadiusTiles of any source.
+func creepTiles(rp *rep.Replay, sources []repcore.Point) int {
+	w, h := int(rp.Header.MapWidth), int(rp.Header.MapHeight)
+	covered := map[[2]int]bool{}
+	for _, src := range sources {
+		cx, cy := int(src.X)/tileSize, int(src.Y)/tileSize
+		for x := cx - creepRadiusTiles; x <= cx+creepRadiusTiles; x++ {
+			for y := cy - creepRadiusTiles; y <= cy+creepRadiusTiles; y++ {
+				dx, dy := x-cx, y-cy
+				if x < 0 || y < 0 || w > 0 && x >= w || h > 0 && y >= h || dx*dx+dy*dy > creepRadiusTiles*creepRadiusTiles {
+					continue
+				}
+				covered[[2]int{x, y}] = true
+			}
+		}
+	}
+	return len(covered)
+}
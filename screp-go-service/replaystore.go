@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// blobBackend stores replay binaries by key.
+type blobBackend interface {
+	put(ctx context.Context, key string, data []byte) error
+	get(ctx context.Context, key string) (io.ReadCloser, error)
+	delete(ctx context.Context, key string) error
+	list(ctx context.Context, prefix string) ([]string, error) // Keys starting with prefix
+}
+
+// dirBackend stores blobs as files in a directory.
+type dirBackend string
+
+func (d dirBackend) path(key string) string {
+	return filepath.Join(string(d), filepath.FromSlash(key))
+}
+
+func (d dirBackend) put(ctx context.Context, key string, data []byte) error {
+	p := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return err
+	}
+	// Write to a temp file first so readers never see a partial replay
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (d dirBackend) get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(d.path(key))
+}
+
+func (d dirBackend) delete(ctx context.Context, key string) error {
+	return os.Remove(d.path(key))
+}
+
+func (d dirBackend) list(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(string(d), func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if e.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(string(d), p)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// replayStore stores replay binaries content-addressed by their SHA-256
+// hash, so a replay uploaded by many users is stored once. Blobs are
+// reference counted by the jobs using them and deleted with the last one.
+// Like the jobs, reference counts live in memory, so no job references the
+// blobs stored before a restart: sweep deletes them at startup.
+type replayStore struct {
+	backend blobBackend
+	mu      sync.Mutex
+	refs    map[string]int
+	pending map[string]*pendingBlob // Blobs being put or deleted
+}
+
+// pendingBlob is a put or delete of a blob in progress. done is closed when
+// it finished, and err is then the error of a failed put.
+type pendingBlob struct {
+	done chan struct{}
+	err  error
+}
+
+// replays is the configured replay store, nil if replays aren't stored.
+var replays *replayStore
+
+// replayStoreFromEnv returns the replay store in REPLAY_STORE_DIR if set, or
+// else in the object store under replays/, or nil if neither is configured.
+// Replays are encrypted with the keys of REPLAY_ENCRYPTION_KEY_FILE if set.
+func replayStoreFromEnv(objects *objectStore) (*replayStore, error) {
+	var b blobBackend
+	if dir := os.Getenv("REPLAY_STORE_DIR"); dir != "" {
+		b = breakerBackend{dirBackend(dir), newCircuitBreaker("replay store")}
+	} else if objects != nil {
+		b = objects
+	}
+
+	if path := os.Getenv("REPLAY_ENCRYPTION_KEY_FILE"); path != "" {
+		if b == nil {
+			return nil, errors.New("REPLAY_ENCRYPTION_KEY_FILE is set but replays aren't stored")
+		}
+		keys, err := loadKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		b = sealedBackend{blobBackend: b, keys: keys}
+	}
+	if b == nil {
+		return nil, nil
+	}
+	return &replayStore{backend: b, refs: map[string]int{}, pending: map[string]*pendingBlob{}}, nil
+}
+
+// replayHash returns the storage key of a replay: the hex SHA-256 of its
+// bytes.
+func replayHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func replayKey(hash string) string {
+	return "replays/" + hash[:2] + "/" + hash + ".rep"
+}
+
+// add stores the replay, or only takes a reference if it's already stored,
+// and returns its hash. Concurrent adds of a replay wait for the first one
+// to put it, and fail with it.
+func (s *replayStore) add(data []byte) (string, error) {
+	hash := replayHash(data)
+	s.mu.Lock()
+	for {
+		if s.refs[hash] > 0 {
+			s.refs[hash]++
+			s.mu.Unlock()
+			return hash, nil
+		}
+		p, ok := s.pending[hash]
+		if !ok {
+			break
+		}
+		s.mu.Unlock()
+		<-p.done
+		if p.err != nil {
+			return "", p.err
+		}
+		s.mu.Lock() // Stored or deleted meanwhile, look again
+	}
+	p := &pendingBlob{done: make(chan struct{})}
+	s.pending[hash] = p
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	p.err = s.backend.put(ctx, replayKey(hash), data)
+	s.mu.Lock()
+	delete(s.pending, hash)
+	if p.err == nil {
+		s.refs[hash]++
+	}
+	s.mu.Unlock()
+	close(p.done)
+	if p.err != nil {
+		return "", p.err
+	}
+	return hash, nil
+}
+
+// errNotReferenced is returned for releases of replays no job references.
+var errNotReferenced = errors.New("replay not referenced")
+
+// has tells if the replay is stored.
+func (s *replayStore) has(hash string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refs[hash] > 0
+}
+
+// release drops a reference, deleting the replay with the last one, and
+// tells if it was deleted. Replays without references are left alone.
+func (s *replayStore) release(hash string) (bool, error) {
+	s.mu.Lock()
+	if s.refs[hash] <= 0 {
+		s.mu.Unlock()
+		return false, fmt.Errorf("%s: %w", hash, errNotReferenced)
+	}
+	s.refs[hash]--
+	last := s.refs[hash] <= 0
+	var p *pendingBlob
+	if last {
+		// Adds wait for the delete, so it can't remove a new put
+		delete(s.refs, hash)
+		p = &pendingBlob{done: make(chan struct{})}
+		s.pending[hash] = p
+	}
+	s.mu.Unlock()
+	if !last {
+		return false, nil
+	}
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, hash)
+		s.mu.Unlock()
+		close(p.done)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := s.backend.delete(ctx, replayKey(hash)); err != nil && !os.IsNotExist(err) {
+		return true, err
+	}
+	return true, nil
+}
+
+// sweep deletes the stored replays no job references, and returns how many
+// it deleted. Run at startup, it removes every blob left by the jobs of an
+// earlier run, which can't be read or deleted by job any more. The store must
+// not be shared with other instances.
+func (s *replayStore) sweep(ctx context.Context) (int, error) {
+	keys, err := s.backend.list(ctx, "replays/")
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, key := range keys {
+		hash := strings.TrimSuffix(strings.TrimSuffix(path.Base(key), ".tmp"), ".rep")
+		s.mu.Lock()
+		_, pending := s.pending[hash]
+		used := s.refs[hash] > 0 || pending
+		s.mu.Unlock()
+		if used {
+			continue
+		}
+		if err := s.backend.delete(ctx, key); err != nil && !os.IsNotExist(err) {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// warnReplayNotStored flags results whose replay couldn't be stored. The
+// result itself is complete, only the replay download is missing.
+const warnReplayNotStored = "replay_not_stored"
+
+// storeReplay stores the replay if a replay store is configured, and returns
+// its hash, or "" if it isn't stored. If storage fails, parsing goes on
+// without it and a warning is returned for the response.
+func storeReplay(data []byte) (hash, warning string) {
+	if replays == nil {
+		return "", ""
+	}
+	hash, err := replays.add(data)
+	if err != nil {
+		log.Printf("Error storing replay, skipping: %v", err)
+		return "", warnReplayNotStored
+	}
+	return hash, ""
+}
+
+// replayHashes returns the hashes of the stored replays of the job.
+func (j *Job) replayHashes() []string {
+	var hashes []string
+	if j.ReplayHash != "" {
+		hashes = append(hashes, j.ReplayHash)
+	}
+	for _, item := range j.Batch {
+		if item.ReplayHash != "" {
+			hashes = append(hashes, item.ReplayHash)
+		}
+	}
+	return hashes
+}
+
+// hasReplay tells if the job references the stored replay.
+func (j *Job) hasReplay(hash string) bool {
+	for _, h := range j.replayHashes() {
+		if h == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseReplays drops the references of jobs that are gone.
+func releaseReplays(gone []*Job) {
+	if replays == nil {
+		return
+	}
+	for _, j := range gone {
+		for _, hash := range j.replayHashes() {
+			if _, err := replays.release(hash); err != nil {
+				log.Printf("Error deleting replay %s: %v", hash, err)
+			}
+		}
+	}
+}
+
+// getReplayHandler downloads a stored replay by hash. Only jobs referencing
+// the replay give access to it, by their unguessable IDs in the job query
+// parameter. Replays may be encrypted at rest and hold the players' chat, so
+// they aren't cached.
+func getReplayHandler(w http.ResponseWriter, r *http.Request) {
+	hash := mux.Vars(r)["hash"]
+	w.Header().Set("Cache-Control", "private, no-store")
+	job, ok := jobs.get(r.URL.Query().Get("job"))
+	if !ok || replays == nil || !replays.has(hash) || !job.hasReplay(hash) {
+		http.Error(w, "Replay not found", http.StatusNotFound)
+		return
+	}
+
+	body, err := replays.backend.get(r.Context(), replayKey(hash))
+	if errors.Is(err, errCircuitOpen) {
+		unavailable(w, "Replay storage unavailable")
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading replay %s: %v", hash, err)
+		http.Error(w, "Replay storage error", http.StatusInternalServerError)
+		return
+	}
+	defer body.Close()
+	data, err := io.ReadAll(io.LimitReader(body, maxReplaySize))
+	if err != nil {
+		log.Printf("Error reading replay %s: %v", hash, err)
+		http.Error(w, "Replay storage error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+hash+`.rep"`)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+func testReplayStore(t *testing.T) (*replayStore, dirBackend) {
+	dir := dirBackend(t.TempDir())
+	return &replayStore{backend: dir, refs: map[string]int{}, pending: map[string]*pendingBlob{}}, dir
+}
+
+func TestReplayStoreRefcount(t *testing.T) {
+	s, dir := testReplayStore(t)
+	data := []byte("replay")
+	hash, err := s.add(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != replayHash(data) {
+		t.Fatalf("got hash %s, want %s", hash, replayHash(data))
+	}
+	if _, err := s.add(data); err != nil {
+		t.Fatal(err)
+	}
+	if s.refs[hash] != 2 {
+		t.Fatalf("got %d refs, want 2", s.refs[hash])
+	}
+
+	deleted, err := s.release(hash)
+	if err != nil || deleted {
+		t.Fatalf("first release: deleted %v, %v", deleted, err)
+	}
+	if _, err := os.Stat(dir.path(replayKey(hash))); err != nil {
+		t.Fatalf("replay deleted with a reference left: %v", err)
+	}
+	deleted, err = s.release(hash)
+	if err != nil || !deleted {
+		t.Fatalf("last release: deleted %v, %v", deleted, err)
+	}
+	if _, err := os.Stat(dir.path(replayKey(hash))); !os.IsNotExist(err) {
+		t.Fatalf("replay not deleted: %v", err)
+	}
+	if s.has(hash) {
+		t.Error("deleted replay still stored")
+	}
+
+	if _, err := s.release(hash); !errors.Is(err, errNotReferenced) {
+		t.Errorf("release without reference: got %v, want %v", err, errNotReferenced)
+	}
+}
+
+func TestReplayStoreSweep(t *testing.T) {
+	s, dir := testReplayStore(t)
+	kept, err := s.add([]byte("referenced"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphan := replayHash([]byte("earlier run"))
+	if err := dir.put(context.Background(), replayKey(orphan), []byte("earlier run")); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.sweep(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("swept %d replays, want 1", n)
+	}
+	if _, err := os.Stat(dir.path(replayKey(orphan))); !os.IsNotExist(err) {
+		t.Errorf("orphan not deleted: %v", err)
+	}
+	if _, err := os.Stat(dir.path(replayKey(kept))); err != nil {
+		t.Errorf("referenced replay deleted: %v", err)
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// reportPalette colors the players in the report charts.
+var reportPalette = []string{"#e6194b", "#4363d8", "#3cb44b", "#f58231", "#911eb4", "#42d4f4", "#f032e6", "#bfef45"}
+
+// Size of the report charts, in SVG units
+const (
+	chartWidth  = 640
+	chartHeight = 200
+)
+
+type reportChart struct {
+	Title  string
+	MaxY   string
+	Series []reportSeries
+}
+
+type reportSeries struct {
+	Name, Color string
+	Points      string // SVG polyline points
+}
+
+type reportBuild struct {
+	Player string
+	Color  string
+	Steps  []Command
+}
+
+type reportData struct {
+	*ReplayResult
+	Matchup  string
+	Duration string
+	Winner   string
+	Colors   []string
+	Charts   []reportChart
+	Builds   []reportBuild
+	Timeline []timelineEntry
+}
+
+// maxReportBuildSteps caps the build order steps listed per player.
+const maxReportBuildSteps = 30
+
+// newReportData prepares the result for the report template. The APM chart
+// needs the actions, it's left out if the result has none.
+func newReportData(res *ReplayResult) *reportData {
+	d := &reportData{
+		ReplayResult: res,
+		Matchup:      overlaySummary(res).Matchup,
+		Duration:     formatVideoTime(float64(res.DurationSeconds)),
+		Timeline:     keyTimeline(res),
+	}
+	for i, p := range res.Players {
+		d.Colors = append(d.Colors, reportPalette[i%len(reportPalette)])
+		if res.WinnerTeam != 0 && p.Team == res.WinnerTeam {
+			if d.Winner != "" {
+				d.Winner += ", "
+			}
+			d.Winner += p.Name
+		}
+	}
+
+	if len(res.Actions) > 0 {
+		series := apmSeries(res, apmOptions{step: 60, smoothing: SmoothingNone})
+		apm := make([][]float64, len(series.Players))
+		for i, p := range series.Players {
+			apm[i] = p.Values
+		}
+		d.Charts = append(d.Charts, d.lineChart("Actions per minute", apm))
+	}
+	aggression := make([][]float64, len(res.Players))
+	for i, p := range res.Players {
+		for _, s := range p.Positioning.Curve {
+			aggression[i] = append(aggression[i], s.AggressionIndex)
+		}
+	}
+	d.Charts = append(d.Charts, d.lineChart("Aggression index", aggression))
+
+	for _, b := range res.BuildOrders {
+		if b.PlayerID >= len(res.Players) {
+			continue
+		}
+		steps := b.Sequence
+		if len(steps) > maxReportBuildSteps {
+			steps = steps[:maxReportBuildSteps]
+		}
+		d.Builds = append(d.Builds, reportBuild{Player: res.Players[b.PlayerID].Name, Color: d.Colors[b.PlayerID], Steps: steps})
+	}
+	return d
+}
+
+// lineChart renders one polyline per player, values per minute.
+func (d *reportData) lineChart(title string, values [][]float64) reportChart {
+	maxY, maxX := 0.0, 1
+	for _, vs := range values {
+		for _, v := range vs {
+			if v > maxY {
+				maxY = v
+			}
+		}
+		if len(vs)-1 > maxX {
+			maxX = len(vs) - 1
+		}
+	}
+	if maxY == 0 {
+		maxY = 1
+	}
+	c := reportChart{Title: title, MaxY: fmt.Sprintf("%g", round(maxY, 2))}
+	for i, vs := range values {
+		var pts []string
+		for x, v := range vs {
+			pts = append(pts, fmt.Sprintf("%.1f,%.1f", float64(x)/float64(maxX)*chartWidth, chartHeight-v/maxY*chartHeight))
+		}
+		c.Series = append(c.Series, reportSeries{Name: d.Players[i].Name, Color: d.Colors[i], Points: strings.Join(pts, " ")})
+	}
+	return c
+}
+
+var reportFuncs = template.FuncMap{
+	"clock": formatVideoTime,
+	"deref": func(v *float64) float64 { return *v },
+	"pct":   percent,
+}
+
+// percent formats a 0..1 share.
+func percent(v float64) string { return fmt.Sprintf("%.0f%%", v*100) }
+
+var reportTemplate = template.Must(template.New("report").Funcs(reportFuncs).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.CanonicalMapName}} {{.Matchup}} replay report</title>
+<style>
+body { font: 14px/1.4 system-ui, sans-serif; margin: 0 auto; max-width: 960px; padding: 16px; color: #222; }
+h1 { margin-bottom: 0; } .sub { color: #666; margin-top: 4px; }
+table { border-collapse: collapse; width: 100%; margin: 8px 0 24px; }
+th, td { text-align: left; padding: 4px 8px; border-bottom: 1px solid #ddd; }
+th { background: #f4f4f4; }
+.builds { display: flex; flex-wrap: wrap; gap: 16px; } .builds table { width: auto; min-width: 280px; }
+.dot { display: inline-block; width: 10px; height: 10px; border-radius: 5px; margin-right: 6px; }
+svg { background: #fafafa; border: 1px solid #ddd; max-width: 100%; height: auto; }
+.late { color: #c00; } .early { color: #080; } .missing { color: #999; }
+</style>
+</head>
+<body>
+<h1>{{.CanonicalMapName}} &middot; {{.Matchup}}</h1>
+<p class="sub">{{.Duration}} game time{{if .Winner}} &middot; {{.Winner}} won{{end}}{{if ne .MapName .CanonicalMapName}} &middot; map title "{{.MapName}}"{{end}}</p>
+
+<h2>Players</h2>
+<table>
+<tr><th>Player</th><th>Race</th><th>Team</th><th>APM</th><th>EAPM</th><th>Hotkey ratio</th><th>Spells</th><th>Static defense</th><th>Aggression</th></tr>
+{{range $i, $p := .Players}}<tr><td><span class="dot" style="background:{{index $.Colors $i}}"></span>{{$p.Name}}</td><td>{{$p.Race}}</td><td>{{$p.Team}}</td><td>{{$p.APM}}</td><td>{{$p.EAPM}}</td><td>{{pct $p.Hotkeys.HotkeySelectRatio}}</td><td>{{$p.Spells.Total}}</td><td>{{$p.StaticDefense.Count}} ({{$p.StaticDefense.Minerals}} minerals)</td><td>{{printf "%.2f" $p.Positioning.AggressionIndex}}</td></tr>
+{{end}}</table>
+
+{{range .Charts}}<h2>{{.Title}}</h2>
+<svg viewBox="0 0 ` + fmt.Sprint(chartWidth) + ` ` + fmt.Sprint(chartHeight) + `" width="` + fmt.Sprint(chartWidth) + `" height="` + fmt.Sprint(chartHeight) + `" role="img">
+<text x="4" y="14" font-size="12" fill="#666">max {{.MaxY}}</text>
+{{range .Series}}<polyline fill="none" stroke="{{.Color}}" stroke-width="2" points="{{.Points}}"><title>{{.Name}}</title></polyline>
+{{end}}</svg>
+{{end}}
+
+<h2>Production</h2>
+<table>
+<tr><th>Player</th><th>Facility</th><th>Count</th><th>Units</th><th>Utilization</th></tr>
+{{range $i, $p := .Players}}{{range $p.Production}}<tr><td><span class="dot" style="background:{{index $.Colors $i}}"></span>{{$p.Name}}</td><td>{{.Facility}}</td><td>{{.Count}}</td><td>{{.UnitsOrdered}}</td><td>{{pct .Utilization}}</td></tr>
+{{end}}{{end}}</table>
+
+<h2>Build orders</h2>
+<div class="builds">
+{{range .Builds}}<table>
+<tr><th colspan="2"><span class="dot" style="background:{{.Color}}"></span>{{.Player}}</th></tr>
+{{range .Steps}}<tr><td>{{clock .Time}}</td><td>{{.Target}}</td></tr>
+{{end}}</table>
+{{end}}</div>
+
+<h2>Upgrade timings</h2>
+<table>
+<tr><th>Player</th><th>Research</th><th>Expected</th><th>Actual</th><th>Status</th></tr>
+{{range $i, $p := .Players}}{{range $p.Upgrades}}<tr><td>{{$p.Name}}</td><td>{{.Name}}</td><td>{{clock .ExpectedTime}}</td><td>{{with .ActualTime}}{{clock (deref .)}}{{else}}-{{end}}</td><td class="{{.Status}}">{{.Status}}</td></tr>
+{{end}}{{end}}</table>
+
+<h2>Key events</h2>
+<table>
+{{range .Timeline}}<tr><td>{{clock .Time}}</td><td>{{.Label}}</td></tr>
+{{end}}</table>
+
+{{if or .Anomalies .Suspicions}}<h2>Integrity findings</h2>
+<table>
+{{range .Anomalies}}<tr><td>{{(index $.Players .PlayerID).Name}}</td><td>{{.Kind}}</td><td>{{.Description}}</td></tr>
+{{end}}{{range .Suspicions}}<tr><td>{{(index $.Players .PlayerID).Name}}</td><td>map hack suspicion at {{clock .Time}}</td><td>{{.Reason}}</td></tr>
+{{end}}</table>{{end}}
+</body>
+</html>
+`))
+
+// reportPDF lays out the report as a PDF for printing and attaching to notes.
+// Charts are left out, the tables carry the same numbers.
+func reportPDF(res *ReplayResult) []byte {
+	d := newReportData(res)
+	p := &pdfWriter{}
+	p.newline(20)
+	p.text(pdfMargin, 18, true, d.CanonicalMapName+" - "+d.Matchup)
+	sub := d.Duration + " game time"
+	if d.Winner != "" {
+		sub += ", " + d.Winner + " won"
+	}
+	p.newline(16)
+	p.text(pdfMargin, 10, false, sub)
+
+	p.heading("Players")
+	var rows [][]string
+	for _, pl := range d.Players {
+		rows = append(rows, []string{pl.Name, pl.Race, fmt.Sprint(pl.Team), fmt.Sprint(pl.APM), fmt.Sprint(pl.EAPM),
+			percent(pl.Hotkeys.HotkeySelectRatio), fmt.Sprint(pl.Spells.Total),
+			fmt.Sprint(pl.StaticDefense.Count), fmt.Sprintf("%.2f", pl.Positioning.AggressionIndex)})
+	}
+	p.table([]pdfCol{{"Player", 120}, {"Race", 55}, {"Team", 35}, {"APM", 40}, {"EAPM", 40}, {"Hotkeys", 50},
+		{"Spells", 40}, {"Defense", 45}, {"Aggression", 70}}, rows)
+
+	rows = nil
+	for _, pl := range d.Players {
+		for _, f := range pl.Production {
+			rows = append(rows, []string{pl.Name, f.Facility, fmt.Sprint(f.Count), fmt.Sprint(f.UnitsOrdered), percent(f.Utilization)})
+		}
+	}
+	if len(rows) > 0 {
+		p.heading("Production")
+		p.table([]pdfCol{{"Player", 140}, {"Facility", 140}, {"Count", 60}, {"Units", 60}, {"Utilization", 80}}, rows)
+	}
+
+	for _, b := range d.Builds {
+		p.heading("Build order: " + b.Player)
+		rows = nil
+		for _, st := range b.Steps {
+			rows = append(rows, []string{formatVideoTime(st.Time), st.Target})
+		}
+		p.table([]pdfCol{{"Time", 60}, {"Step", 300}}, rows)
+	}
+
+	rows = nil
+	for _, pl := range d.Players {
+		for _, u := range pl.Upgrades {
+			actual := "-"
+			if u.ActualTime != nil {
+				actual = formatVideoTime(*u.ActualTime)
+			}
+			rows = append(rows, []string{pl.Name, u.Name, formatVideoTime(u.ExpectedTime), actual, u.Status})
+		}
+	}
+	if len(rows) > 0 {
+		p.heading("Upgrade timings")
+		p.table([]pdfCol{{"Player", 120}, {"Research", 160}, {"Expected", 60}, {"Actual", 60}, {"Status", 80}}, rows)
+	}
+
+	p.heading("Key events")
+	rows = nil
+	for _, e := range d.Timeline {
+		rows = append(rows, []string{formatVideoTime(e.Time), e.Label})
+	}
+	p.table([]pdfCol{{"Time", 60}, {"Event", 439}}, rows)
+
+	if len(d.Anomalies) > 0 || len(d.Suspicions) > 0 {
+		p.heading("Integrity findings")
+		for _, a := range d.Anomalies {
+			p.paragraph(9, d.Players[a.PlayerID].Name+": "+a.Description)
+		}
+		for _, s := range d.Suspicions {
+			p.paragraph(9, fmt.Sprintf("%s: map hack suspicion at %s, %s", d.Players[s.PlayerID].Name, formatVideoTime(s.Time), s.Reason))
+		}
+	}
+	return p.bytes()
+}
+
+// writeReport renders the report of the result as HTML, or as PDF with
+// format=pdf.
+func writeReport(w http.ResponseWriter, format string, res *ReplayResult) {
+	if format == "pdf" {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `attachment; filename="report.pdf"`)
+		w.Write(reportPDF(res))
+		return
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := reportTemplate.Execute(buf, newReportData(res)); err != nil {
+		log.Printf("Error rendering report: %v", err)
+		http.Error(w, "Failed to render report", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// reportFormat returns the requested report format, responding with an
+// error if it's invalid.
+func reportFormat(w http.ResponseWriter, r *http.Request) (string, bool) {
+	format := r.FormValue("format")
+	if format == "" {
+		format = "html"
+	}
+	if format != "html" && format != "pdf" {
+		http.Error(w, "Invalid format, must be html or pdf", http.StatusBadRequest)
+		return "", false
+	}
+	return format, true
+}
+
+// reportHandler parses an uploaded replay and responds with a self-contained
+// report.
+func reportHandler(w http.ResponseWriter, r *http.Request) {
+	format, ok := reportFormat(w, r)
+	if !ok {
+		return
+	}
+	file, _, err := r.FormFile("replay")
+	if err != nil {
+		http.Error(w, "Missing replay file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	res, err := parseReplay(file)
+	if err != nil {
+		http.Error(w, "Parse error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer res.release()
+	writeReport(w, format, res)
+}
+
+// jobReportHandler renders the report of a parsed job, for sharing by link.
+func jobReportHandler(w http.ResponseWriter, r *http.Request) {
+	format, ok := reportFormat(w, r)
+	if !ok {
+		return
+	}
+	job, ok := jobs.get(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if job.Status != JobDone || job.Result == nil {
+		w.Header().Set("Cache-Control", "no-store")
+		http.Error(w, "Job "+job.Status, http.StatusConflict)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	writeReport(w, format, job.Result)
+}
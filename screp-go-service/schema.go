@@ -0,0 +1,178 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// schemaVersion is bumped together with breaking changes of the response types.
+const schemaVersion = "v1"
+
+// schemaTypes lists the response types published at /schemas/{version}.
+var schemaTypes = map[string]reflect.Type{
+	"ReplayResult":     reflect.TypeOf(ReplayResult{}),
+	"PlayerInfo":       reflect.TypeOf(PlayerInfo{}),
+	"Command":          reflect.TypeOf(Command{}),
+	"BuildOrder":       reflect.TypeOf(BuildOrder{}),
+	"Anomaly":          reflect.TypeOf(Anomaly{}),
+	"AttackPath":       reflect.TypeOf(AttackPath{}),
+	"Waypoint":         reflect.TypeOf(Waypoint{}),
+	"BatchItem":        reflect.TypeOf(BatchItem{}),
+	"HeaderResult":     reflect.TypeOf(HeaderResult{}),
+	"DefenseStructure": reflect.TypeOf(DefenseStructure{}),
+	"Engagement":       reflect.TypeOf(Engagement{}),
+	"Event":            reflect.TypeOf(Event{}),
+	"FacilityUsage":    reflect.TypeOf(FacilityUsage{}),
+	"FacilityCount":    reflect.TypeOf(FacilityCount{}),
+	"HotkeyUsage":      reflect.TypeOf(HotkeyUsage{}),
+	"IntegrityReport":  reflect.TypeOf(IntegrityReport{}),
+	"Diagnostic":       reflect.TypeOf(Diagnostic{}),
+	"Job":              reflect.TypeOf(Job{}),
+	"KnownMap":         reflect.TypeOf(KnownMap{}),
+	"OverlaySummary":   reflect.TypeOf(OverlaySummary{}),
+	"EmbedCard":        reflect.TypeOf(EmbedCard{}),
+	"Positioning":      reflect.TypeOf(Positioning{}),
+	"PositionSample":   reflect.TypeOf(PositionSample{}),
+	"SpellCast":        reflect.TypeOf(SpellCast{}),
+	"SpellStats":       reflect.TypeOf(SpellStats{}),
+	"StaticDefense":    reflect.TypeOf(StaticDefense{}),
+	"Suspicion":        reflect.TypeOf(Suspicion{}),
+	"WorkerPull":       reflect.TypeOf(WorkerPull{}),
+	"UpgradeBenchmark": reflect.TypeOf(UpgradeBenchmark{}),
+	"Milestones":       reflect.TypeOf(Milestones{}),
+	"UploadTicket":     reflect.TypeOf(UploadTicket{}),
+	"Share":            reflect.TypeOf(Share{}),
+	"DeletionReceipt":  reflect.TypeOf(DeletionReceipt{}),
+	"AuditEntry":       reflect.TypeOf(AuditEntry{}),
+	"APIKey":           reflect.TypeOf(APIKey{}),
+	"DependencyHealth": reflect.TypeOf(DependencyHealth{}),
+	"OpeningsReport":   reflect.TypeOf(OpeningsReport{}),
+	"FeatureSet":       reflect.TypeOf(FeatureSet{}),
+	"DatasetManifest":  reflect.TypeOf(DatasetManifest{}),
+	"SimilarityReport": reflect.TypeOf(SimilarityReport{}),
+	"SearchResult":     reflect.TypeOf(SearchResult{}),
+	"APMSeries":        reflect.TypeOf(APMSeries{}),
+	"ArchiveIndex":     reflect.TypeOf(ArchiveIndex{}),
+	"SeekIndex":        reflect.TypeOf(SeekIndex{}),
+}
+
+// jsonSchema generates a JSON Schema (draft 2020-12) document for t.
+// t must be a struct type. Nested struct types are emitted once under $defs
+// and referenced.
+func jsonSchema(name string, t reflect.Type) map[string]interface{} {
+	defs := map[string]interface{}{t.Name(): nil}
+	root := structSchema(t, defs)
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["$id"] = "/schemas/" + schemaVersion + "/" + name + ".json"
+	root["title"] = name
+	delete(defs, t.Name())
+	if len(defs) > 0 {
+		root["$defs"] = defs
+	}
+	return root
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func schemaOf(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		return map[string]interface{}{"anyOf": []interface{}{schemaOf(t.Elem(), defs), map[string]interface{}{"type": "null"}}}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		// nil slices and maps are encoded as null
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": []string{"string", "null"}, "contentEncoding": "base64"}
+		}
+		return map[string]interface{}{"type": []string{"array", "null"}, "items": schemaOf(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]interface{}{"type": []string{"object", "null"}, "additionalProperties": schemaOf(t.Elem(), defs)}
+	case reflect.Struct:
+		if t == timeType {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+		if _, ok := defs[t.Name()]; !ok && t.Name() != "" {
+			defs[t.Name()] = nil // Placeholder to stop recursion
+			defs[t.Name()] = structSchema(t, defs)
+		}
+		if t.Name() == "" {
+			return structSchema(t, defs)
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+func structSchema(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	props := map[string]interface{}{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue // Unexported
+		}
+		name, opts := f.Name, ""
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ = strings.Cut(tag, ",")
+			if name == "" {
+				name = f.Name
+			}
+		}
+		props[name] = schemaOf(f.Type, defs)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	s := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		sort.Strings(required)
+		s["required"] = required
+	}
+	return s
+}
+
+func schemaIndexHandler(w http.ResponseWriter, r *http.Request) {
+	if mux.Vars(r)["version"] != schemaVersion {
+		http.Error(w, "Unknown schema version", http.StatusNotFound)
+		return
+	}
+	names := make([]string, 0, len(schemaTypes))
+	for name := range schemaTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	links := make(map[string]string, len(names))
+	for _, name := range names {
+		links[name] = "/schemas/" + schemaVersion + "/" + name + ".json"
+	}
+	writeJSON(w, map[string]interface{}{"version": schemaVersion, "schemas": links})
+}
+
+func schemaHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	t, ok := schemaTypes[vars["name"]]
+	if vars["version"] != schemaVersion || !ok {
+		http.Error(w, "Unknown schema", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	writeJSON(w, jsonSchema(vars["name"], t))
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcmd"
+	"github.com/icza/screp/rep/repcore"
+)
+
+// Scouting measures whether a player adapted to what they scouted: every
+// scout that revealed new enemy building types, and the first new decision
+// of the player afterwards.
+type Scouting struct {
+	Scouts     []Scout  `json:"scouts"`
+	Reacted    int      `json:"reacted"`    // Scouts followed by a reaction
+	AvgLatency *float64 `json:"avgLatency"` // Mean reaction latency in seconds, null if no reaction
+}
+
+// Scout is a command into an enemy base area which revealed enemy building
+// types the player hadn't seen before. The reaction is the first unit,
+// building or research the player had never ordered before, within
+// reactionWindowSeconds of the scout; the latency is the time in between.
+// New decisions may be planned rather than caused by the scout, so the
+// metric is best read over many games.
+type Scout struct {
+	TargetPlayerID int      `json:"targetPlayerId"`
+	Frame          int      `json:"frame"`
+	Time           float64  `json:"time"`
+	X              int      `json:"x"`
+	Y              int      `json:"y"`
+	Revealed       []string `json:"revealed"`
+	Reaction       string   `json:"reaction,omitempty"`
+	ReactionTime   *float64 `json:"reactionTime,omitempty"`
+	Latency        *float64 `json:"latency,omitempty"` // Seconds
+}
+
+// reactionWindowSeconds is how long after a scout a new decision counts as
+// the reaction.
+const reactionWindowSeconds = 90
+
+// firstOrder is the first time a player ordered a unit, building or
+// research type.
+type firstOrder struct {
+	frame repcore.Frame
+	name  string
+}
+
+// enemyBuildingType is a building type of a player, revealed once.
+type enemyBuildingType struct {
+	owner int
+	unit  string
+}
+
+// orderedName returns the unit, building or research the command orders.
+func orderedName(cmd repcmd.Cmd) string {
+	switch c := cmd.(type) {
+	case *repcmd.BuildCmd:
+		if c.Unit != nil {
+			return c.Unit.String()
+		}
+	case *repcmd.TrainCmd:
+		if c.Unit != nil {
+			return c.Unit.String()
+		}
+	case *repcmd.BuildingMorphCmd:
+		if c.Unit != nil {
+			return c.Unit.String()
+		}
+	case *repcmd.TechCmd:
+		if c.Tech != nil {
+			return c.Tech.String()
+		}
+	case *repcmd.UpgradeCmd:
+		if c.Upgrade != nil {
+			return c.Upgrade.String()
+		}
+	}
+	return ""
+}
+
+// scouting detects the scouts of every player and their reactions. A
+// player sees the enemy buildings within scoutedRadius of their commands'
+// targets, the same approximation as the map hack heuristic. A scout reveals
+// the buildings of one enemy, the owner of the first one found.
+func scouting(rp *rep.Replay) []Scouting {
+	n := len(rp.Header.Players)
+	res := make([]Scouting, n)
+
+	var buildings []enemyBuilding
+	firsts := make([][]firstOrder, n)
+	ordered := make([]map[string]bool, n)
+	revealed := make([]map[enemyBuildingType]bool, n)
+	for i := range ordered {
+		ordered[i], revealed[i] = map[string]bool{}, map[enemyBuildingType]bool{}
+	}
+
+	for _, cmd := range rp.Commands {
+		base := cmd.BaseCmd()
+		if base == nil || int(base.PlayerID) >= n {
+			continue
+		}
+		pid := int(base.PlayerID)
+		if name := orderedName(cmd); name != "" && !ordered[pid][name] {
+			ordered[pid][name] = true
+			firsts[pid] = append(firsts[pid], firstOrder{base.Frame, name})
+		}
+		pos, ok := cmdPos(cmd)
+		if !ok {
+			continue
+		}
+		if c, ok := cmd.(*repcmd.BuildCmd); ok && c.Unit != nil {
+			buildings = append(buildings, enemyBuilding{owner: pid, frame: base.Frame, pos: c.Pos, unit: c.Unit.String()})
+			continue // Own buildings aren't scouts
+		}
+
+		scout := Scout{TargetPlayerID: -1, Frame: int(base.Frame), Time: round(frameToSeconds(base.Frame), 1), X: int(pos.X), Y: int(pos.Y), Revealed: []string{}}
+		for _, b := range buildings {
+			key := enemyBuildingType{b.owner, b.unit}
+			if b.frame >= base.Frame || !isEnemy(rp, pid, b.owner) || revealed[pid][key] || dist(pos, b.pos) >= scoutedRadius {
+				continue
+			}
+			if scout.TargetPlayerID < 0 {
+				scout.TargetPlayerID = b.owner
+			}
+			if b.owner == scout.TargetPlayerID {
+				revealed[pid][key] = true
+				scout.Revealed = append(scout.Revealed, b.unit)
+			}
+		}
+		if len(scout.Revealed) > 0 {
+			res[pid].Scouts = append(res[pid].Scouts, scout)
+		}
+	}
+
+	window := secondsToFrames(reactionWindowSeconds)
+	for pid := range res {
+		if res[pid].Scouts == nil {
+			res[pid].Scouts = []Scout{}
+		}
+		total := 0.0
+		for i := range res[pid].Scouts {
+			s := &res[pid].Scouts[i]
+			for _, f := range firsts[pid] {
+				if int(f.frame) <= s.Frame {
+					continue
+				}
+				if f.frame <= repcore.Frame(s.Frame)+window {
+					t := round(frameToSeconds(f.frame), 1)
+					latency := round(t-s.Time, 1)
+					s.Reaction, s.ReactionTime, s.Latency = f.name, &t, &latency
+					res[pid].Reacted++
+					total += latency
+				}
+				break
+			}
+		}
+		if res[pid].Reacted > 0 {
+			avg := round(total/float64(res[pid].Reacted), 1)
+			res[pid].AvgLatency = &avg
+		}
+	}
+	return res
+}
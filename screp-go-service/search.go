@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Limits and defaults of the replay search
+const (
+	maxSearchJobs      = 1000
+	defaultSearchLimit = 50
+	maxSearchLimit     = 500
+)
+
+// SearchRequest filters the replays of JobIDs. Filters are case-insensitive
+// and empty ones match every replay.
+type SearchRequest struct {
+	JobIDs  []string `json:"jobIds"`            // Replays searched
+	Player  string   `json:"player,omitempty"`  // Part of a player's name or slug
+	Race    string   `json:"race,omitempty"`    // Of a player, of the one found by Player if set
+	Map     string   `json:"map,omitempty"`     // Part of the map name
+	Matchup string   `json:"matchup,omitempty"` // E.g. "TvZ" or "PTvZZ", in any order
+	Limit   int      `json:"limit,omitempty"`   // Hits returned, default 50
+}
+
+// SearchResult lists the replays matching a search, in the order of the jobs.
+type SearchResult struct {
+	Searched    int         `json:"searched"` // Replays of the jobs
+	Total       int         `json:"total"`    // Matching replays, Hits is cut at the limit
+	Hits        []SearchHit `json:"hits"`
+	MissingJobs []string    `json:"missingJobs,omitempty"` // Unknown, expired or unfinished jobs
+}
+
+// SearchHit is a replay found. Players lists the players matching the
+// player and race filters, all if there are none.
+type SearchHit struct {
+	JobID           string          `json:"jobId"`
+	Replay          string          `json:"replay,omitempty"` // Name in the batch
+	ReplayHash      string          `json:"replayHash,omitempty"`
+	Map             string          `json:"map"`
+	Matchup         string          `json:"matchup"`
+	DurationSeconds float32         `json:"durationSeconds"`
+	WinnerTeam      int             `json:"winnerTeam,omitempty"`
+	Players         []OverlayPlayer `json:"players"`
+}
+
+// matchupKey normalizes a matchup for comparison: the races of each team
+// sorted, and the teams sorted, so "ZvT" and "TvZ" are the same.
+func matchupKey(matchup string) string {
+	teams := strings.Split(strings.ToUpper(matchup), "V")
+	for i, t := range teams {
+		races := strings.Split(t, "")
+		sort.Strings(races)
+		teams[i] = strings.Join(races, "")
+	}
+	sort.Strings(teams)
+	return strings.Join(teams, "v")
+}
+
+// searchReplay tells if the result matches the request, and returns its
+// hit.
+func searchReplay(req SearchRequest, jobID, name, hash string, res *ReplayResult) (SearchHit, bool) {
+	s := overlaySummary(res)
+	if req.Map != "" && !strings.Contains(strings.ToLower(res.CanonicalMapName), strings.ToLower(req.Map)) &&
+		!strings.Contains(strings.ToLower(res.MapName), strings.ToLower(req.Map)) {
+		return SearchHit{}, false
+	}
+	if req.Matchup != "" && matchupKey(s.Matchup) != matchupKey(req.Matchup) {
+		return SearchHit{}, false
+	}
+
+	hit := SearchHit{JobID: jobID, Replay: name, ReplayHash: hash, Map: res.CanonicalMapName, Matchup: s.Matchup,
+		DurationSeconds: res.DurationSeconds, WinnerTeam: res.WinnerTeam, Players: []OverlayPlayer{}}
+	player, race := strings.ToLower(req.Player), strings.ToUpper(req.Race)
+	for i, p := range res.Players {
+		if player != "" && !strings.Contains(strings.ToLower(p.Name), player) && !strings.Contains(p.Slug, player) {
+			continue
+		}
+		if race != "" && !strings.HasPrefix(strings.ToUpper(p.Race), race) {
+			continue
+		}
+		hit.Players = append(hit.Players, s.Players[i])
+	}
+	if len(hit.Players) == 0 && (player != "" || race != "") {
+		return SearchHit{}, false
+	}
+	return hit, true
+}
+
+// searchReplays filters the replays of the jobs.
+func searchReplays(req SearchRequest) *SearchResult {
+	out := &SearchResult{Hits: []SearchHit{}}
+	add := func(jobID, name, hash string, res *ReplayResult) {
+		out.Searched++
+		if hit, ok := searchReplay(req, jobID, name, hash, res); ok {
+			out.Hits = append(out.Hits, hit)
+		}
+	}
+	for _, id := range req.JobIDs {
+		job, ok := jobs.get(id)
+		if !ok || job.Status != JobDone {
+			out.MissingJobs = append(out.MissingJobs, id)
+			continue
+		}
+		if job.Result != nil {
+			add(job.ID, "", job.ReplayHash, job.Result)
+		}
+		for _, item := range job.Batch {
+			if item.Result != nil {
+				add(job.ID, item.Name, item.ReplayHash, item.Result)
+			}
+		}
+	}
+
+	out.Total = len(out.Hits)
+	if len(out.Hits) > req.Limit {
+		out.Hits = out.Hits[:req.Limit]
+	}
+	return out
+}
+
+// searchHandler searches the replays of a collection of jobs by player, race,
+// map and matchup. Like the other analyses, only jobs whose IDs the client
+// knows are searched.
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	var req SearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.JobIDs) == 0 || len(req.JobIDs) > maxSearchJobs {
+		http.Error(w, "jobIds must list 1 to "+strconv.Itoa(maxSearchJobs)+" jobs", http.StatusBadRequest)
+		return
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultSearchLimit
+	}
+	if req.Limit < 1 || req.Limit > maxSearchLimit {
+		http.Error(w, "Invalid limit, must be 1 to "+strconv.Itoa(maxSearchLimit), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, searchReplays(req))
+}
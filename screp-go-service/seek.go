@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Defaults and limits of the seek index interval, in frames
+const (
+	defaultSeekInterval = 24 // About a second
+	maxSeekInterval     = 24 * 60
+)
+
+// SeekIndex lets replay viewers and scrubbers jump to any frame without
+// walking the actions from the start. Keyframe k is at frame k*Interval:
+// Action[k] is the index of its first action (the first at or after the
+// frame) and Offset[k] the byte offset of that action's line in the action
+// lines of the job (GET /seek/{id}/actions), to fetch with a range request.
+// To seek to frame f, start at keyframe f/Interval and skip the actions
+// before f. Both columns end with an extra entry, the number of actions and
+// the length of the action lines.
+type SeekIndex struct {
+	Clock    string      `json:"clock"`    // Of the action lines the offsets point into
+	Frames   int         `json:"frames"`   // Length of the game
+	Interval int         `json:"interval"` // Frames between keyframes
+	Action   []int       `json:"action"`
+	Offset   []int64     `json:"offset"`
+	Events   []SeekEvent `json:"events"`
+}
+
+// SeekEvent is a key moment of the game to jump to: an event, an
+// engagement or a highlight.
+type SeekEvent struct {
+	Frame  int    `json:"frame"`
+	Kind   string `json:"kind"` // Event or highlight kind, or "engagement"
+	Label  string `json:"label"`
+	Action int    `json:"action"` // Index of the first action at or after the frame
+	Offset int64  `json:"offset"`
+}
+
+// parseSeekInterval reads the interval query parameter.
+func parseSeekInterval(q url.Values) (int, error) {
+	s := q.Get("interval")
+	if s == "" {
+		return defaultSeekInterval, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > maxSeekInterval {
+		return 0, errors.New("interval must be 1 to " + strconv.Itoa(maxSeekInterval) + " frames")
+	}
+	return n, nil
+}
+
+// actionLines encodes the actions of the result as JSON lines with the times
+// in the clock, and returns the byte offsets of the lines, plus the length.
+func actionLines(res *ReplayResult, clock string) ([]byte, []int64) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	offsets := make([]int64, 0, len(res.Actions)+1)
+	for _, c := range res.Actions {
+		offsets = append(offsets, int64(buf.Len()))
+		if clock == ClockGame {
+			c.Time = round(c.Time*gameClockScale, 2)
+		}
+		enc.Encode(c)
+	}
+	return buf.Bytes(), append(offsets, int64(buf.Len()))
+}
+
+// seekIndex builds the seek index of the result, with offsets into the
+// action lines in the clock.
+func seekIndex(res *ReplayResult, clock string, interval int) SeekIndex {
+	_, offsets := actionLines(res, clock)
+	frames := int(math.Round(float64(res.DurationSeconds) * framesPerSecond))
+	if n := len(res.Actions); n > 0 && res.Actions[n-1].Frame > frames {
+		frames = res.Actions[n-1].Frame
+	}
+	idx := SeekIndex{Clock: clock, Frames: frames, Interval: interval, Events: []SeekEvent{}}
+
+	// firstAt returns the index of the first action at or after frame f.
+	// Actions are in frame order.
+	firstAt := func(f int) int {
+		lo, hi := 0, len(res.Actions)
+		for lo < hi {
+			if m := (lo + hi) / 2; res.Actions[m].Frame < f {
+				lo = m + 1
+			} else {
+				hi = m
+			}
+		}
+		return lo
+	}
+	for f := 0; f <= frames; f += interval {
+		i := firstAt(f)
+		idx.Action = append(idx.Action, i)
+		idx.Offset = append(idx.Offset, offsets[i])
+	}
+	idx.Action = append(idx.Action, len(res.Actions))
+	idx.Offset = append(idx.Offset, offsets[len(res.Actions)])
+
+	add := func(frame int, kind, label string) {
+		i := firstAt(frame)
+		idx.Events = append(idx.Events, SeekEvent{Frame: frame, Kind: kind, Label: label, Action: i, Offset: offsets[i]})
+	}
+	for _, e := range res.Events {
+		add(e.Frame, e.Kind, e.Description)
+	}
+	for _, e := range res.Engagements {
+		add(e.Frame, "engagement", e.Name)
+	}
+	for _, e := range res.Highlights {
+		add(e.Frame, e.Kind, e.Description)
+	}
+	sort.SliceStable(idx.Events, func(i, j int) bool { return idx.Events[i].Frame < idx.Events[j].Frame })
+	return idx
+}
+
+// jobReplayResult returns the finished job of the request and its result,
+// or that of the batch item named by the replay query parameter, with the
+// batch position as ETag suffix. If there is none, it responds with an
+// error and returns false.
+func jobReplayResult(w http.ResponseWriter, r *http.Request) (job Job, res *ReplayResult, item string, ok bool) {
+	job, ok = jobs.get(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return job, nil, "", false
+	}
+	if job.Status != JobDone {
+		w.Header().Set("Cache-Control", "no-store")
+		http.Error(w, "Job "+job.Status, http.StatusConflict)
+		return job, nil, "", false
+	}
+	res = job.Result
+	if name := r.URL.Query().Get("replay"); name != "" {
+		res = nil
+		for i, bi := range job.Batch {
+			if bi.Name == name {
+				res, item = bi.Result, "-"+strconv.Itoa(i)
+			}
+		}
+	}
+	if res == nil {
+		http.Error(w, "Replay not found, batch jobs need the replay parameter", http.StatusNotFound)
+		return job, nil, "", false
+	}
+	return job, res, item, true
+}
+
+// seekIndexHandler returns the seek index of a parsed job's replay, with
+// long-lived cache headers like the overlay summary.
+func seekIndexHandler(w http.ResponseWriter, r *http.Request) {
+	interval, err := parseSeekInterval(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid options: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	clock, ok := clockParam(w, r)
+	if !ok {
+		return
+	}
+	job, res, item, ok := jobReplayResult(w, r)
+	if !ok {
+		return
+	}
+
+	etag := `"` + job.ID + item + "-seek-" + clock + "-" + strconv.Itoa(interval) + `"`
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, seekIndex(res, clock, interval))
+}
+
+// seekActionsHandler serves the action lines of a parsed job's replay the
+// seek index points into. Range requests fetch the actions around a
+// keyframe.
+func seekActionsHandler(w http.ResponseWriter, r *http.Request) {
+	clock, ok := clockParam(w, r)
+	if !ok {
+		return
+	}
+	job, res, item, ok := jobReplayResult(w, r)
+	if !ok {
+		return
+	}
+
+	data, _ := actionLines(res, clock)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+job.ID+item+"-actions-"+clock+`"`)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
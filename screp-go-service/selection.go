@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/icza/screp/rep/repcmd"
+)
+
+// selection follows a player's selected units and control groups, so
+// commands can be attributed to the units they were issued to.
+type selection struct {
+	current []repcmd.UnitTag
+	groups  [10][]repcmd.UnitTag
+}
+
+// apply updates the selection by a select or hotkey command, other commands
+// are ignored.
+func (s *selection) apply(cmd repcmd.Cmd) {
+	switch c := cmd.(type) {
+	case *repcmd.SelectCmd:
+		switch c.Type.ID {
+		case repcmd.TypeIDSelectAdd, repcmd.TypeIDSelectAdd121:
+			s.current = unionTags(s.current, c.UnitTags)
+		case repcmd.TypeIDSelectRemove, repcmd.TypeIDSelectRemove121:
+			s.current = removeTags(s.current, c.UnitTags)
+		default:
+			s.current = append([]repcmd.UnitTag(nil), c.UnitTags...)
+		}
+	case *repcmd.HotkeyCmd:
+		if int(c.Group) >= len(s.groups) {
+			return
+		}
+		switch c.HotkeyType.ID {
+		case repcmd.HotkeyTypeIDAssign:
+			s.groups[c.Group] = append([]repcmd.UnitTag(nil), s.current...)
+		case repcmd.HotkeyTypeIDAdd:
+			s.groups[c.Group] = unionTags(s.groups[c.Group], s.current)
+		case repcmd.HotkeyTypeIDSelect:
+			s.current = append([]repcmd.UnitTag(nil), s.groups[c.Group]...)
+		}
+	}
+}
+
+func unionTags(tags, add []repcmd.UnitTag) []repcmd.UnitTag {
+	res := append([]repcmd.UnitTag(nil), tags...)
+	for _, t := range add {
+		if !hasTag(res, t) {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+func removeTags(tags, remove []repcmd.UnitTag) []repcmd.UnitTag {
+	var res []repcmd.UnitTag
+	for _, t := range tags {
+		if !hasTag(remove, t) {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+func hasTag(tags []repcmd.UnitTag, t repcmd.UnitTag) bool {
+	for _, t2 := range tags {
+		if t2 == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxShareTTL caps how long a share link can stay valid.
+const maxShareTTL = 30 * 24 * time.Hour
+
+// defaultShareTTL is the validity of share links created without an expiry.
+// It's SHARE_TTL (a Go duration, e.g. 72h), default 7 days.
+var defaultShareTTL = shareTTL()
+
+func shareTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("SHARE_TTL")); err == nil && d > 0 && d <= maxShareTTL {
+		return d
+	}
+	return 7 * 24 * time.Hour
+}
+
+// Share is a public, read-only link to the result of a job.
+type Share struct {
+	ID        string    `json:"id"`
+	JobID     string    `json:"jobId"`
+	Path      string    `json:"path"` // Relative to the service
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type ShareRequest struct {
+	ExpiresIn int `json:"expiresIn,omitempty"` // Seconds, default SHARE_TTL
+}
+
+type share struct {
+	Share
+	result *ReplayResult
+}
+
+// shareStore keeps the shares in memory. Shares hold on to the result, so
+// they outlive the job they were created from.
+type shareStore struct {
+	mu     sync.Mutex
+	shares map[string]*share
+}
+
+var shares = &shareStore{shares: map[string]*share{}}
+
+// create registers a new share of the result.
+func (s *shareStore) create(jobID string, res *ReplayResult, ttl time.Duration) Share {
+	now := time.Now()
+	id := newShareID()
+	sh := &share{Share: Share{ID: id, JobID: jobID, Path: "/s/" + id, ExpiresAt: now.Add(ttl).UTC()}, result: res}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, old := range s.shares {
+		if now.After(old.ExpiresAt) {
+			delete(s.shares, id)
+		}
+	}
+	s.shares[id] = sh
+	return sh.Share
+}
+
+// get returns the shared result, if the share exists and hasn't expired.
+func (s *shareStore) get(id string) (*share, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sh, ok := s.shares[id]
+	if !ok || time.Now().After(sh.ExpiresAt) {
+		return nil, false
+	}
+	return sh, true
+}
+
+// removeJob deletes the shares of the job and returns their IDs.
+func (s *shareStore) removeJob(jobID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for id, sh := range s.shares {
+		if sh.JobID == jobID {
+			delete(s.shares, id)
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// shareAlphabet is Crockford's base32 in lower case: URL safe and without
+// look-alike characters.
+const shareAlphabet = "0123456789abcdefghjkmnpqrstvwxyz"
+
+// newShareID returns a short, unguessable identifier (80 random bits).
+func newShareID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	for i := range b {
+		b[i] = shareAlphabet[b[i]%32]
+	}
+	return string(b)
+}
+
+// createShareHandler creates a share link for the result of a finished job.
+func createShareHandler(w http.ResponseWriter, r *http.Request) {
+	var req ShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	ttl := defaultShareTTL
+	if req.ExpiresIn < 0 || time.Duration(req.ExpiresIn)*time.Second > maxShareTTL {
+		http.Error(w, "Invalid expiresIn, must be 0 to "+strconv.Itoa(int(maxShareTTL.Seconds()))+" seconds", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresIn > 0 {
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+	}
+
+	job, ok := jobs.get(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if job.Status != JobDone {
+		http.Error(w, "Job "+job.Status, http.StatusConflict)
+		return
+	}
+	if job.Result == nil {
+		http.Error(w, "Batch jobs can't be shared", http.StatusConflict)
+		return
+	}
+	writeJSONStatus(w, http.StatusCreated, shares.create(job.ID, job.Result, ttl))
+}
+
+// sharedResult looks up the share of the request, responding with 404 if
+// it doesn't exist or has expired. The response may be cached until expiry.
+func sharedResult(w http.ResponseWriter, r *http.Request) (*ReplayResult, bool) {
+	sh, ok := shares.get(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "Share not found", http.StatusNotFound)
+		return nil, false
+	}
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(time.Until(sh.ExpiresAt).Seconds())))
+	w.Header().Set("Expires", sh.ExpiresAt.Format(http.TimeFormat))
+	return sh.result, true
+}
+
+// sharedResultHandler serves the result of a share link.
+func sharedResultHandler(w http.ResponseWriter, r *http.Request) {
+	clock, ok := clockParam(w, r)
+	if !ok {
+		return
+	}
+	res, ok := sharedResult(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, res.inClock(clock))
+}
+
+// sharedReportHandler serves the report of a share link.
+func sharedReportHandler(w http.ResponseWriter, r *http.Request) {
+	format, ok := reportFormat(w, r)
+	if !ok {
+		return
+	}
+	res, ok := sharedResult(w, r)
+	if !ok {
+		return
+	}
+	writeReport(w, format, res)
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// Limits and defaults of the similarity search
+const (
+	maxSimilarJobs      = 1000
+	defaultSimilarLimit = 10
+	maxSimilarLimit     = 100
+	defaultSimilarSteps = 15
+)
+
+// Weights of the build order and the timing profile in the similarity
+// score, and the milestone time difference that counts as fully different.
+const (
+	similarBuildWeight  = 0.6
+	similarTimingWeight = 0.4
+	similarTimingScale  = 90.0 // Seconds
+)
+
+// SimilarRequest searches the replays of JobIDs for the ones most similar
+// to the query replay: the result of JobID, or the batch item named Replay.
+type SimilarRequest struct {
+	JobID    string   `json:"jobId"`
+	Replay   string   `json:"replay,omitempty"`   // Name in the batch of JobID
+	PlayerID *int     `json:"playerId,omitempty"` // Compare only this player, default all
+	JobIDs   []string `json:"jobIds"`             // Replays searched
+	Steps    int      `json:"steps,omitempty"`    // Build order steps compared, default 15
+	Limit    int      `json:"limit,omitempty"`    // Matches returned, default 10
+}
+
+// SimilarityReport lists the replays most similar to the query, best first.
+type SimilarityReport struct {
+	Query       SimilarQuery    `json:"query"`
+	Steps       int             `json:"steps"`
+	Searched    int             `json:"searched"` // Replays of the same matchup compared
+	Matches     []SimilarReplay `json:"matches"`
+	MissingJobs []string        `json:"missingJobs,omitempty"` // Unknown, expired or unfinished jobs
+}
+
+// SimilarQuery describes the query replay.
+type SimilarQuery struct {
+	JobID    string   `json:"jobId"`
+	Replay   string   `json:"replay,omitempty"`
+	Map      string   `json:"map"`
+	Matchups []string `json:"matchups"` // Of the compared players
+}
+
+// SimilarReplay is a replay found, with the players matched to the query's.
+// Score is 1 for identical build orders and milestone timings, down to 0.
+type SimilarReplay struct {
+	JobID      string          `json:"jobId"`
+	Replay     string          `json:"replay,omitempty"` // Name in the batch
+	ReplayHash string          `json:"replayHash,omitempty"`
+	Map        string          `json:"map"`
+	Score      float64         `json:"score"`
+	Players    []SimilarPlayer `json:"players"`
+}
+
+// SimilarPlayer pairs a player of the query with the most similar player of
+// the same matchup in the found replay.
+type SimilarPlayer struct {
+	Player        string   `json:"player"`  // Of the query
+	Matched       string   `json:"matched"` // Of the found replay
+	Matchup       string   `json:"matchup"`
+	Score         float64  `json:"score"`
+	BuildDistance int      `json:"buildDistance"`         // Edit distance of the build orders, in steps
+	TimingDelta   *float64 `json:"timingDelta,omitempty"` // Mean milestone difference, in seconds
+	Won           *bool    `json:"won,omitempty"`         // Of the matched player
+}
+
+// similarProfile is what is compared of a player.
+type similarProfile struct {
+	name, matchup string
+	build         []string
+	timings       []*float64
+	won           *bool
+}
+
+func similarProfiles(res *ReplayResult, steps int, playerID *int) []similarProfile {
+	var out []similarProfile
+	for _, p := range res.Players {
+		if playerID != nil && p.ID != *playerID {
+			continue
+		}
+		mu := playerMatchup(res, p)
+		if mu == "" {
+			continue
+		}
+		sp := similarProfile{name: p.Name, matchup: mu}
+		if p.ID < len(res.BuildOrders) {
+			sp.build = normalizeBuild(res.BuildOrders[p.ID], steps)
+		}
+		m := p.Milestones
+		sp.timings = []*float64{m.FirstGas, m.FirstExpansion, m.FirstTechBuilding, m.FirstArmyUnit, m.FirstUpgrade}
+		if res.WinnerTeam != 0 {
+			won := p.Team == res.WinnerTeam
+			sp.won = &won
+		}
+		out = append(out, sp)
+	}
+	return out
+}
+
+// compareProfiles scores two players of the same matchup. The build order
+// distance is normalized by the longer build; each milestone counts fully
+// different if only one player reached it or the times are similarTimingScale
+// apart.
+func compareProfiles(q, c similarProfile) SimilarPlayer {
+	sp := SimilarPlayer{Player: q.name, Matched: c.name, Matchup: q.matchup, Won: c.won}
+	sp.BuildDistance = editDistance(q.build, c.build)
+	build := 0.0
+	if n := len(q.build); n > 0 || len(c.build) > 0 {
+		if len(c.build) > n {
+			n = len(c.build)
+		}
+		build = float64(sp.BuildDistance) / float64(n)
+	}
+
+	timing, compared, delta, both := 0.0, 0, 0.0, 0
+	for i := range q.timings {
+		a, b := q.timings[i], c.timings[i]
+		switch {
+		case a == nil && b == nil:
+			continue
+		case a == nil || b == nil:
+			timing++
+		default:
+			d := math.Abs(*a - *b)
+			timing += math.Min(d/similarTimingScale, 1)
+			delta += d
+			both++
+		}
+		compared++
+	}
+	if compared > 0 {
+		timing /= float64(compared)
+	}
+	if both > 0 {
+		d := round(delta/float64(both), 1)
+		sp.TimingDelta = &d
+	}
+	sp.Score = round(1-similarBuildWeight*build-similarTimingWeight*timing, 3)
+	return sp
+}
+
+// matchProfiles pairs each query player with the best unused candidate
+// player of the same matchup. ok is false if some query player has none,
+// the replay isn't of the same matchup then.
+func matchProfiles(query, cand []similarProfile) (players []SimilarPlayer, score float64, ok bool) {
+	used := make([]bool, len(cand))
+	for _, q := range query {
+		best := -1
+		var bestPlayer SimilarPlayer
+		for i, c := range cand {
+			if used[i] || c.matchup != q.matchup {
+				continue
+			}
+			if sp := compareProfiles(q, c); best < 0 || sp.Score > bestPlayer.Score {
+				best, bestPlayer = i, sp
+			}
+		}
+		if best < 0 {
+			return nil, 0, false
+		}
+		used[best] = true
+		players = append(players, bestPlayer)
+		score += bestPlayer.Score
+	}
+	return players, round(score/float64(len(query)), 3), true
+}
+
+// findSimilar ranks the replays of the jobs by similarity to the query.
+func findSimilar(query *ReplayResult, queryHash string, req SimilarRequest) *SimilarityReport {
+	out := &SimilarityReport{
+		Query:   SimilarQuery{JobID: req.JobID, Replay: req.Replay, Map: query.CanonicalMapName, Matchups: []string{}},
+		Steps:   req.Steps,
+		Matches: []SimilarReplay{},
+	}
+	qp := similarProfiles(query, req.Steps, req.PlayerID)
+	for _, p := range qp {
+		out.Query.Matchups = append(out.Query.Matchups, p.matchup)
+	}
+	if len(qp) == 0 {
+		return out
+	}
+
+	add := func(jobID, name, hash string, res *ReplayResult) {
+		if jobID == req.JobID && name == req.Replay || hash != "" && hash == queryHash {
+			return // The query itself
+		}
+		players, score, ok := matchProfiles(qp, similarProfiles(res, req.Steps, nil))
+		if !ok {
+			return
+		}
+		out.Searched++
+		out.Matches = append(out.Matches, SimilarReplay{JobID: jobID, Replay: name, ReplayHash: hash, Map: res.CanonicalMapName, Score: score, Players: players})
+	}
+	for _, id := range req.JobIDs {
+		job, ok := jobs.get(id)
+		if !ok || job.Status != JobDone {
+			out.MissingJobs = append(out.MissingJobs, id)
+			continue
+		}
+		if job.Result != nil {
+			add(job.ID, "", job.ReplayHash, job.Result)
+		}
+		for _, item := range job.Batch {
+			if item.Result != nil {
+				add(job.ID, item.Name, item.ReplayHash, item.Result)
+			}
+		}
+	}
+
+	sort.SliceStable(out.Matches, func(i, j int) bool { return out.Matches[i].Score > out.Matches[j].Score })
+	if len(out.Matches) > req.Limit {
+		out.Matches = out.Matches[:req.Limit]
+	}
+	return out
+}
+
+// queryReplay returns the result and replay hash of the query of req.
+func queryReplay(req SimilarRequest) (res *ReplayResult, hash string, ok bool) {
+	job, found := jobs.get(req.JobID)
+	if !found || job.Status != JobDone {
+		return nil, "", false
+	}
+	if req.Replay == "" {
+		return job.Result, job.ReplayHash, job.Result != nil
+	}
+	for _, item := range job.Batch {
+		if item.Name == req.Replay && item.Result != nil {
+			return item.Result, item.ReplayHash, true
+		}
+	}
+	return nil, "", false
+}
+
+// similarHandler finds the stored replays most similar to a parsed one:
+// same matchup, closest build orders and milestone timings.
+func similarHandler(w http.ResponseWriter, r *http.Request) {
+	var req SimilarRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.JobIDs) == 0 || len(req.JobIDs) > maxSimilarJobs {
+		http.Error(w, "jobIds must list 1 to "+strconv.Itoa(maxSimilarJobs)+" jobs", http.StatusBadRequest)
+		return
+	}
+	if req.Steps == 0 {
+		req.Steps = defaultSimilarSteps
+	}
+	if req.Steps < 1 || req.Steps > maxOpeningSteps {
+		http.Error(w, "Invalid steps, must be 1 to "+strconv.Itoa(maxOpeningSteps), http.StatusBadRequest)
+		return
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultSimilarLimit
+	}
+	if req.Limit < 1 || req.Limit > maxSimilarLimit {
+		http.Error(w, "Invalid limit, must be 1 to "+strconv.Itoa(maxSimilarLimit), http.StatusBadRequest)
+		return
+	}
+
+	query, hash, ok := queryReplay(req)
+	if !ok {
+		http.Error(w, "Query replay not found", http.StatusNotFound)
+		return
+	}
+	if req.PlayerID != nil && len(similarProfiles(query, 1, req.PlayerID)) == 0 {
+		http.Error(w, "Invalid playerId, no such player with an opponent", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, findSimilar(query, hash, req))
+}
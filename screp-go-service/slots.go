@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/icza/screp/rep"
+)
+
+// Command player IDs of commands whose player isn't in the header, all out
+// of range of every player list. Observers' commands (e.g. chat) carry IDs
+// from observerPlayerID up, one per observer, and keep them. Commands of
+// other slots not in the header get unknownSlotBase plus their slot ID, or
+// noPlayerID if that would reach it.
+const (
+	unknownSlotBase  = 64
+	noPlayerID       = 127
+	observerPlayerID = 128
+)
+
+// remapPlayerIDs rewrites the player IDs of the commands to the indices of
+// the players in rp.Header.Players, which all analyses and the result use.
+//
+// Commands carry the player ID of the slot, while Players is in team order
+// and leaves out empty slots. The two only line up if the players fill the
+// first slots in team order, which often isn't the case, e.g. in replays
+// saved by observers or with open slots between players. screp's own
+// computations (winner detection, observer flags) map IDs themselves, so
+// they are done first.
+func remapPlayerIDs(rp *rep.Replay) {
+	rp.Compute()
+
+	index := map[byte]byte{}
+	identity := true
+	for i, p := range rp.Header.Players {
+		index[p.ID] = byte(i)
+		identity = identity && p.ID == byte(i)
+	}
+	for _, cmd := range rp.Commands {
+		base := cmd.BaseCmd()
+		if base == nil || base.PlayerID >= observerPlayerID {
+			continue
+		}
+		i, ok := index[base.PlayerID]
+		switch {
+		case !ok && base.PlayerID < noPlayerID-unknownSlotBase:
+			base.PlayerID += unknownSlotBase
+		case !ok:
+			base.PlayerID = noPlayerID
+		case !identity:
+			base.PlayerID = i
+		}
+	}
+}
+
+// unknownSlot tells if a remapped command player ID is of a slot not in the
+// header, other than an observer's, and returns the slot ID, -1 if unknown.
+func unknownSlot(pid int) (slot int, ok bool) {
+	switch {
+	case pid == noPlayerID:
+		return -1, true
+	case pid >= unknownSlotBase && pid < noPlayerID:
+		return pid - unknownSlotBase, true
+	}
+	return 0, false
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcmd"
+	"github.com/icza/screp/rep/repcore"
+)
+
+// SpellStats counts a player's spell casts (storms, EMPs, plagues, ...).
+type SpellStats struct {
+	Total   int            `json:"total"`
+	BySpell map[string]int `json:"bySpell"`
+	// CastsPerEngagement is the number of casts in engagements per
+	// engagement the player took part in.
+	CastsPerEngagement float64     `json:"castsPerEngagement"`
+	Casts              []SpellCast `json:"casts"`
+}
+
+// SpellCast is one targeted spell cast.
+type SpellCast struct {
+	Spell        string  `json:"spell"`
+	Frame        int     `json:"frame"`
+	Time         float64 `json:"time"`
+	X            int     `json:"x"`
+	Y            int     `json:"y"`
+	EngagementID int     `json:"engagementId,omitempty"` // If cast in an engagement
+}
+
+// spellName returns the name of the spell the order casts, "" if it isn't a
+// spell. Scanner sweeps don't count, they're no spellcaster ability.
+func spellName(o *repcmd.Order) string {
+	if o == nil || o.ID == repcmd.OrderIDCastScannerSweep {
+		return ""
+	}
+	var name string
+	switch {
+	case strings.HasPrefix(o.Name, "Cast"):
+		name = strings.TrimPrefix(o.Name, "Cast")
+	case o.Name == "FireYamatoGun":
+		name = "YamatoGun"
+	default:
+		return ""
+	}
+	// Split the camel case keeping acronyms: "EMPShockwave" => "EMP Shockwave"
+	rs := []rune(name)
+	var sb strings.Builder
+	for i, r := range rs {
+		if i > 0 && unicode.IsUpper(r) &&
+			(unicode.IsLower(rs[i-1]) || i+1 < len(rs) && unicode.IsLower(rs[i+1])) {
+			sb.WriteByte(' ')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// spellStats collects the spell casts of every player and matches them to
+// the engagements.
+func spellStats(rp *rep.Replay, engagements []Engagement) []SpellStats {
+	res := make([]SpellStats, len(rp.Header.Players))
+	for i := range res {
+		res[i].BySpell = map[string]int{}
+		res[i].Casts = []SpellCast{}
+	}
+	inFights := make([]int, len(res))
+	for _, cmd := range rp.Commands {
+		c, ok := cmd.(*repcmd.TargetedOrderCmd)
+		if !ok || int(c.PlayerID) >= len(res) {
+			continue
+		}
+		spell := spellName(c.Order)
+		if spell == "" {
+			continue
+		}
+		cast := SpellCast{Spell: spell, Frame: int(c.Frame), Time: frameToSeconds(c.Frame), X: int(c.Pos.X), Y: int(c.Pos.Y)}
+		for _, e := range engagements {
+			if cast.Frame >= e.Frame && cast.Frame <= e.EndFrame &&
+				dist(c.Pos, repcore.Point{X: uint16(e.X), Y: uint16(e.Y)}) < engagementRadius {
+				cast.EngagementID = e.ID
+				inFights[c.PlayerID]++
+				break
+			}
+		}
+		s := &res[c.PlayerID]
+		s.Total++
+		s.BySpell[spell]++
+		s.Casts = append(s.Casts, cast)
+	}
+
+	for pid := range res {
+		fights := 0
+		for _, e := range engagements {
+			for _, p := range e.Participants {
+				if p == pid {
+					fights++
+				}
+			}
+		}
+		if fights > 0 {
+			res[pid].CastsPerEngagement = round(float64(inFights[pid])/float64(fights), 2)
+		}
+	}
+	return res
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+
+	"github.com/icza/screp/rep"
+)
+
+// streamReplayResult writes head as JSON with the actions of rp streamed
+// into its "actions" array one by one, keeping memory use constant
+// regardless of the game length. head.Actions must be empty; the action
+// times follow head.Clock.
+func streamReplayResult(w http.ResponseWriter, head *ReplayResult, rp *rep.Replay) error {
+	data, err := json.Marshal(head)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	bw := bufio.NewWriterSize(w, 32<<10)
+	bw.Write(data[:len(data)-1]) // Without the closing brace
+	bw.WriteString(`,"actions":[`)
+
+	enc := json.NewEncoder(bw)
+	first := true
+	for _, cmd := range rp.Commands {
+		if cmd.BaseCmd() == nil {
+			continue
+		}
+		if !first {
+			bw.WriteByte(',')
+		}
+		first = false
+		c := commandOf(cmd)
+		if head.Clock == ClockGame {
+			c.Time = round(c.Time*gameClockScale, 2)
+		}
+		if err := enc.Encode(c); err != nil {
+			return err
+		}
+	}
+
+	bw.WriteString("]}\n")
+	return bw.Flush()
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Resumable uploads via the tus protocol (https://tus.io/protocols/resumable-upload),
+// version 1.0.0 with the creation and termination extensions. The upload ID
+// is the ID of the job that parses it once complete.
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination"
+)
+
+// maxTusUploadSize caps resumable uploads, which may be replay archives.
+const maxTusUploadSize = 512 << 20
+
+// tusDir is where partial uploads are kept: TUS_DIR, default a directory
+// in the system temp dir.
+var tusDir = tusDirectory()
+
+func tusDirectory() string {
+	if dir := os.Getenv("TUS_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "screp-tus")
+}
+
+type tusUpload struct {
+	id       string
+	filename string
+	length   int64
+	offset   int64
+	busy     bool // A PATCH is in progress
+	created  time.Time
+}
+
+func (u *tusUpload) path() string {
+	return filepath.Join(tusDir, u.id)
+}
+
+// complete tells if all bytes arrived. Complete uploads stay in the store
+// while their job exists, so clients can confirm them with a HEAD request,
+// but their file is the parser's.
+func (u *tusUpload) complete() bool {
+	return u.offset == u.length
+}
+
+// tusStore keeps track of the uploads in progress.
+type tusStore struct {
+	mu      sync.Mutex
+	uploads map[string]*tusUpload
+}
+
+var tusUploads = &tusStore{uploads: map[string]*tusUpload{}}
+
+// add registers the upload, removing uploads abandoned for longer than the
+// job TTL and complete ones whose job expired.
+func (s *tusStore) add(u *tusUpload) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, old := range s.uploads {
+		if old.complete() {
+			if _, ok := jobs.get(id); !ok {
+				delete(s.uploads, id)
+			}
+		} else if !old.busy && time.Since(old.created) > jobTTL {
+			os.Remove(old.path())
+			delete(s.uploads, id)
+		}
+	}
+	s.uploads[u.id] = u
+}
+
+// get returns a copy of the upload.
+func (s *tusStore) get(id string) (tusUpload, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.uploads[id]
+	if !ok {
+		return tusUpload{}, false
+	}
+	return *u, true
+}
+
+// Errors of PATCH requests the upload isn't ready for
+var (
+	errTusBusy     = errors.New("upload in progress")
+	errTusComplete = errors.New("upload complete")
+)
+
+// acquire marks the upload busy for a PATCH, failing if one is in progress
+// or it's complete.
+func (s *tusStore) acquire(id string) (tusUpload, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.uploads[id]
+	if !ok {
+		return tusUpload{}, false, nil
+	}
+	if u.busy {
+		return tusUpload{}, true, errTusBusy
+	}
+	if u.complete() {
+		return tusUpload{}, true, errTusComplete
+	}
+	u.busy = true
+	return *u, true, nil
+}
+
+// release records the new offset of a PATCH. Once the upload is complete,
+// its file is the caller's.
+func (s *tusStore) release(id string, offset int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.uploads[id]
+	if u == nil {
+		return // Discarded meanwhile
+	}
+	u.busy, u.offset = false, offset
+}
+
+// remove deletes the upload unless a PATCH is in progress. Complete uploads
+// aren't found, their job is parsing them.
+func (s *tusStore) remove(id string) (found bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.uploads[id]
+	if !ok || u.complete() {
+		return false, nil
+	}
+	if u.busy {
+		return true, errTusBusy
+	}
+	delete(s.uploads, id)
+	return true, os.Remove(u.path())
+}
+
+// discard deletes the upload even if a PATCH is in progress, and tells if
+// there was an incomplete one.
+func (s *tusStore) discard(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.uploads[id]
+	if !ok {
+		return false
+	}
+	delete(s.uploads, id)
+	if u.complete() {
+		return false // Removed by the parser
+	}
+	os.Remove(u.path())
+	return true
+}
+
+// tusOptions writes the tus discovery headers, for OPTIONS requests.
+func tusOptions(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.Itoa(maxTusUploadSize))
+}
+
+// tusRequest wraps a tus handler, checking the protocol version.
+func tusRequest(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		if r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// tusMetadata decodes the Upload-Metadata header: comma separated keys, each
+// followed by its base64 encoded value.
+func tusMetadata(header string) map[string]string {
+	md := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 {
+			continue
+		}
+		var value []byte
+		if len(fields) > 1 {
+			value, _ = base64.StdEncoding.DecodeString(fields[1])
+		}
+		md[fields[0]] = string(value)
+	}
+	return md
+}
+
+// tusCreateHandler creates an upload of Upload-Length bytes and its job. A
+// .zip filename in the metadata makes it a replay archive, parsed as a batch.
+func tusCreateHandler(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		http.Error(w, "Invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if length > maxTusUploadSize {
+		http.Error(w, "Upload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if err := os.MkdirAll(tusDir, 0o700); err != nil {
+		log.Printf("Error creating upload directory: %v", err)
+		http.Error(w, "Upload storage error", http.StatusInternalServerError)
+		return
+	}
+	job := jobs.create(JobAwaitingUpload, r.Header.Get(ownerHeader))
+	setAuditResource(r, job.ID)
+	u := &tusUpload{id: job.ID, filename: tusMetadata(r.Header.Get("Upload-Metadata"))["filename"], length: length, created: time.Now()}
+	f, err := os.OpenFile(u.path(), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Printf("Error creating upload file: %v", err)
+		http.Error(w, "Upload storage error", http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+	tusUploads.add(u)
+
+	w.Header().Set("Location", "/tus/"+job.ID)
+	writeJSONStatus(w, http.StatusCreated, job)
+}
+
+// tusHeadHandler reports the offset of an upload, to resume it, or to
+// confirm a complete one if the response to the last PATCH was lost. The
+// upload ID is the ID of the job.
+func tusHeadHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	u, ok := tusUploads.get(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.length, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+// tusPatchHandler appends a chunk at Upload-Offset. Whatever arrived is kept
+// if the connection drops, so the client can resume from the new offset.
+// Parsing starts once the upload is complete.
+func tusPatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	id := mux.Vars(r)["id"]
+	u, ok, err := tusUploads.acquire(id)
+	if !ok {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errTusComplete) {
+		http.Error(w, "Upload complete", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Upload in progress", http.StatusConflict)
+		return
+	}
+	offset := u.offset
+	defer func() {
+		tusUploads.release(id, offset)
+		if offset == u.length {
+			go parseTusUpload(u)
+		}
+	}()
+
+	if r.Header.Get("Upload-Offset") != strconv.FormatInt(u.offset, 10) {
+		http.Error(w, "Upload-Offset mismatch", http.StatusConflict)
+		return
+	}
+	f, err := os.OpenFile(u.path(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		log.Printf("Error opening upload %s: %v", id, err)
+		http.Error(w, "Upload storage error", http.StatusInternalServerError)
+		return
+	}
+	n, err := io.Copy(f, io.LimitReader(r.Body, u.length-u.offset))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	offset += n
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	if err != nil {
+		log.Printf("Error writing upload %s: %v", id, err)
+		http.Error(w, "Upload interrupted", http.StatusInternalServerError)
+		return
+	}
+	if offset == u.length {
+		jobs.update(id, func(j *Job) bool {
+			j.Status = JobProcessing
+			return true
+		})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tusDeleteHandler terminates an upload and fails its job.
+func tusDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	found, err := tusUploads.remove(id)
+	if !found {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "Upload in progress", http.StatusConflict)
+		return
+	}
+	jobs.update(id, func(j *Job) bool {
+		j.Status, j.Error = JobFailed, "upload terminated"
+		return true
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseTusUpload parses a complete upload like the other upload flows: a
+// single replay into the job result, an archive into the batch results.
+func parseTusUpload(u tusUpload) {
+	defer os.Remove(u.path())
+
+	var hash, warning string
+	res, items, err := func() (*ReplayResult, []BatchItem, error) {
+		f, err := os.Open(u.path())
+		if err != nil {
+			return nil, nil, err
+		}
+		defer f.Close()
+
+		if !strings.EqualFold(path.Ext(u.filename), ".zip") {
+			data, err := io.ReadAll(io.LimitReader(f, maxReplaySize+1))
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(data) > maxReplaySize {
+				return nil, nil, errors.New("replay too large")
+			}
+			hash, warning = storeReplay(data)
+			res, err := parseReplay(bytes.NewReader(data))
+			return res, nil, err
+		}
+		var files []batchFile
+		if err := readArchive(f, u.length, u.filename, batchCollector(&files)); err != nil {
+			return nil, nil, err
+		}
+		if len(files) == 0 {
+			return nil, nil, fmt.Errorf("%s: no replays in archive", u.filename)
+		}
+		return nil, parseBatch(files), nil
+	}()
+
+	_, ok := jobs.update(u.id, func(j *Job) bool {
+		j.ReplayHash = hash
+		j.addWarning(warning)
+		if err != nil {
+			log.Printf("Job %s failed: %v", u.id, err)
+			j.Status, j.Error = JobFailed, err.Error()
+		} else {
+			j.Status, j.Result, j.Batch = JobDone, res, items
+		}
+		return true
+	})
+	if !ok {
+		releaseReplays([]*Job{{ReplayHash: hash, Batch: items}}) // Deleted meanwhile
+	}
+}
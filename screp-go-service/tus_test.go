@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func tusTestRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/tus", tusRequest(tusCreateHandler)).Methods("POST")
+	r.HandleFunc("/tus/{id}", tusRequest(tusHeadHandler)).Methods("HEAD")
+	r.HandleFunc("/tus/{id}", tusRequest(tusPatchHandler)).Methods("PATCH")
+	return r
+}
+
+func tusDo(t *testing.T, h http.Handler, method, target, offset, body string, header map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	if method == http.MethodPatch {
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+		req.Header.Set("Upload-Offset", offset)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestTusUploadOffsets(t *testing.T) {
+	tusDir = t.TempDir()
+	h := tusTestRouter()
+
+	// An archive, so the complete upload fails to unzip instead of parsing
+	rec := tusDo(t, h, http.MethodPost, "/tus", "", "", map[string]string{
+		"Upload-Length":   "10",
+		"Upload-Metadata": "filename cGFjay56aXA=",
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: got %d, want %d", rec.Code, http.StatusCreated)
+	}
+	loc := rec.Header().Get("Location")
+	id := strings.TrimPrefix(loc, "/tus/")
+
+	steps := []struct {
+		method, offset, body string
+		status               int
+		newOffset            string
+	}{
+		{http.MethodHead, "", "", http.StatusOK, "0"},
+		{http.MethodPatch, "3", "abcd", http.StatusConflict, ""},
+		{http.MethodPatch, "0", "abcd", http.StatusNoContent, "4"},
+		{http.MethodHead, "", "", http.StatusOK, "4"},
+		{http.MethodPatch, "0", "abcd", http.StatusConflict, ""},
+		{http.MethodPatch, "4", "efghijKLMN", http.StatusNoContent, "10"}, // Cut at the length
+		{http.MethodHead, "", "", http.StatusOK, "10"},
+		{http.MethodPatch, "10", "x", http.StatusConflict, ""},
+	}
+	for i, st := range steps {
+		rec := tusDo(t, h, st.method, loc, st.offset, st.body, nil)
+		if rec.Code != st.status {
+			t.Fatalf("step %d: %s offset %q: got %d, want %d", i, st.method, st.offset, rec.Code, st.status)
+		}
+		if got := rec.Header().Get("Upload-Offset"); st.newOffset != "" && got != st.newOffset {
+			t.Errorf("step %d: got Upload-Offset %q, want %q", i, got, st.newOffset)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, ok := jobs.get(id)
+		if !ok {
+			t.Fatal("job not found")
+		}
+		if job.Status == JobFailed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job status %q, want %q", job.Status, JobFailed)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTusRequiresVersion(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/tus", nil)
+	rec := httptest.NewRecorder()
+	tusTestRouter().ServeHTTP(rec, req)
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("got %d, want %d", rec.Code, http.StatusPreconditionFailed)
+	}
+}
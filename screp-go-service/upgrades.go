@@ -0,0 +1,117 @@
+package main
+
+import (
+	"github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcmd"
+)
+
+// Benchmark statuses, as in the build order benchmarks of the web app
+const (
+	BenchmarkEarly   = "early"
+	BenchmarkOnTime  = "on-time"
+	BenchmarkLate    = "late"
+	BenchmarkMissing = "missing"
+)
+
+// Tolerances of the benchmark comparison, in seconds
+const (
+	benchmarkEarlyBy = 10
+	benchmarkLateBy  = 15
+)
+
+// UpgradeBenchmark compares when a key upgrade or tech research was started
+// against the matchup's standard timing.
+type UpgradeBenchmark struct {
+	Name         string   `json:"name"`
+	ExpectedTime float64  `json:"expectedTime"`
+	ActualTime   *float64 `json:"actualTime,omitempty"`
+	DeltaSeconds *float64 `json:"deltaSeconds,omitempty"` // Negative if early
+	Status       string   `json:"status"`
+}
+
+type referenceTiming struct {
+	name    string
+	seconds float64
+}
+
+// upgradeReferences are standard research start timings of common builds, by
+// matchup (own race first).
+var upgradeReferences = map[string][]referenceTiming{
+	"TvZ": {{"U-238 Shells (Marine Range)", 270}, {"Stim Packs", 285}, {"Terran Infantry Weapons", 330}, {"Terran Infantry Armor", 390}},
+	"TvP": {{"Tank Siege Mode", 240}, {"Spider Mines", 300}, {"Ion Thrusters (Vulture Speed)", 330}, {"Terran Vehicle Weapons", 450}},
+	"TvT": {{"Tank Siege Mode", 225}, {"Spider Mines", 330}, {"Terran Vehicle Weapons", 480}},
+	"PvZ": {{"Protoss Ground Weapons", 330}, {"Leg Enhancement (Zealot Speed)", 390}, {"Psionic Storm", 450}},
+	"PvT": {{"Singularity Charge (Dragoon Range)", 210}, {"Protoss Ground Weapons", 480}, {"Leg Enhancement (Zealot Speed)", 540}},
+	"PvP": {{"Singularity Charge (Dragoon Range)", 195}},
+	"ZvT": {{"Metabolic Boost (Zergling Speed)", 180}, {"Zerg Carapace", 360}, {"Zerg Melee Attacks", 390}},
+	"ZvP": {{"Metabolic Boost (Zergling Speed)", 240}, {"Muscular Augments (Hydralisk Speed)", 360}, {"Zerg Missile Attacks", 390}, {"Grooved Spines (Hydralisk Range)", 420}},
+	"ZvZ": {{"Metabolic Boost (Zergling Speed)", 150}},
+}
+
+// matchup returns the matchup of the player against the first enemy, e.g.
+// "TvZ", or "" if it can't be told.
+func matchup(rp *rep.Replay, pid int) string {
+	initial := func(p *rep.Player) string {
+		if r := p.Race.String(); r != "" {
+			return r[:1]
+		}
+		return ""
+	}
+	for epid, p := range rp.Header.Players {
+		if isEnemy(rp, pid, epid) {
+			return initial(rp.Header.Players[pid]) + "v" + initial(p)
+		}
+	}
+	return ""
+}
+
+// upgradeBenchmarks compares every player's research timings against the
+// references of their matchup. Only the first research of each counts (the
+// first level of leveled upgrades).
+func upgradeBenchmarks(rp *rep.Replay) [][]UpgradeBenchmark {
+	started := make([]map[string]float64, len(rp.Header.Players))
+	for i := range started {
+		started[i] = map[string]float64{}
+	}
+	for _, cmd := range rp.Commands {
+		var name string
+		switch c := cmd.(type) {
+		case *repcmd.UpgradeCmd:
+			name = c.Upgrade.String()
+		case *repcmd.TechCmd:
+			name = c.Tech.String()
+		default:
+			continue
+		}
+		base := cmd.BaseCmd()
+		if int(base.PlayerID) >= len(started) {
+			continue
+		}
+		if _, ok := started[base.PlayerID][name]; !ok {
+			started[base.PlayerID][name] = frameToSeconds(base.Frame)
+		}
+	}
+
+	res := make([][]UpgradeBenchmark, len(started))
+	for pid := range res {
+		res[pid] = []UpgradeBenchmark{}
+		for _, ref := range upgradeReferences[matchup(rp, pid)] {
+			b := UpgradeBenchmark{Name: ref.name, ExpectedTime: ref.seconds, Status: BenchmarkMissing}
+			if t, ok := started[pid][ref.name]; ok {
+				t = round(t, 1)
+				delta := round(t-ref.seconds, 1)
+				b.ActualTime, b.DeltaSeconds = &t, &delta
+				switch {
+				case delta < -benchmarkEarlyBy:
+					b.Status = BenchmarkEarly
+				case delta > benchmarkLateBy:
+					b.Status = BenchmarkLate
+				default:
+					b.Status = BenchmarkOnTime
+				}
+			}
+			res[pid] = append(res[pid], b)
+		}
+	}
+	return res
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// uploadURLExpiry is how long a presigned upload URL stays valid.
+const uploadURLExpiry = 15 * time.Minute
+
+// maxReplaySize caps replays read from object storage.
+const maxReplaySize = 32 << 20
+
+// objects is the configured object store, nil if uploads are disabled.
+var objects *objectStore
+
+type UploadTicket struct {
+	JobID     string    `json:"jobId"`
+	UploadURL string    `json:"uploadUrl"`
+	Method    string    `json:"method"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func uploadKey(jobID string) string {
+	return "uploads/" + jobID + ".rep"
+}
+
+// createUploadHandler issues a presigned upload URL and a job ticket.
+// The client uploads the replay directly to storage, then calls
+// POST /jobs/{id}/complete.
+func createUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if objects == nil {
+		http.Error(w, "Object storage not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if objects.breaker.isOpen() {
+		unavailable(w, "Object storage unavailable")
+		return
+	}
+
+	job := jobs.create(JobAwaitingUpload, r.Header.Get(ownerHeader))
+	jobs.update(job.ID, func(j *Job) bool {
+		j.upload = uploadKey(j.ID)
+		return false
+	})
+	setAuditResource(r, job.ID)
+	u, err := objects.presign(http.MethodPut, uploadKey(job.ID), uploadURLExpiry)
+	if err != nil {
+		http.Error(w, "Presign error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONStatus(w, http.StatusCreated, UploadTicket{
+		JobID:     job.ID,
+		UploadURL: u,
+		Method:    http.MethodPut,
+		ExpiresAt: time.Now().Add(uploadURLExpiry).UTC(),
+	})
+}
+
+// completeUploadHandler starts parsing an uploaded replay in the background.
+func completeUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if objects == nil {
+		http.Error(w, "Object storage not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	if objects.breaker.isOpen() {
+		unavailable(w, "Object storage unavailable")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	started := false
+	job, ok := jobs.update(id, func(j *Job) bool {
+		if j.Status != JobAwaitingUpload {
+			return false
+		}
+		j.Status, started = JobProcessing, true
+		return true
+	})
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if !started {
+		http.Error(w, "Job already "+job.Status, http.StatusConflict)
+		return
+	}
+
+	go parseUpload(id)
+
+	writeJSONStatus(w, http.StatusAccepted, job)
+}
+
+// parseUpload parses the uploaded replay of the job, keeping it in the
+// replay store, and deletes the upload from object storage.
+func parseUpload(id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var hash, warning string
+	deleted := false
+	res, err := func() (*ReplayResult, error) {
+		body, err := objects.get(ctx, uploadKey(id))
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		data, err := io.ReadAll(io.LimitReader(body, maxReplaySize+1))
+		if err != nil {
+			return nil, err
+		}
+		if err := objects.delete(ctx, uploadKey(id)); err != nil {
+			log.Printf("Error deleting upload %s: %v", id, err) // Retried by a deletion request
+		} else {
+			deleted = true
+		}
+		if len(data) > maxReplaySize {
+			return nil, errors.New("replay too large")
+		}
+		hash, warning = storeReplay(data)
+		return parseReplay(bytes.NewReader(data))
+	}()
+
+	_, ok := jobs.update(id, func(j *Job) bool {
+		j.ReplayHash = hash
+		j.addWarning(warning)
+		if deleted {
+			j.upload = ""
+		}
+		if err != nil {
+			log.Printf("Job %s failed: %v", id, err)
+			j.Status, j.Error = JobFailed, err.Error()
+		} else {
+			j.Status, j.Result = JobDone, res
+		}
+		return true
+	})
+	if !ok {
+		releaseReplays([]*Job{{ReplayHash: hash}}) // Deleted meanwhile
+	}
+}
+
+func getJobHandler(w http.ResponseWriter, r *http.Request) {
+	clock, ok := clockParam(w, r)
+	if !ok {
+		return
+	}
+	job, ok := jobs.get(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, job.inClock(clock))
+}
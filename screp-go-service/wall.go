@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/icza/screp/rep"
+	"github.com/icza/screp/rep/repcmd"
+	"github.com/icza/screp/rep/repcore"
+)
+
+// wallBuildFrames are the build times of the buildings walls are made of.
+var wallBuildFrames = map[uint16]repcore.Frame{
+	repcmd.UnitIDSupplyDepot:      600,
+	repcmd.UnitIDBarracks:         1200,
+	repcmd.UnitIDBunker:           450,
+	repcmd.UnitIDEngineeringBay:   900,
+	repcmd.UnitIDPylon:            450,
+	repcmd.UnitIDGateway:          900,
+	repcmd.UnitIDForge:            600,
+	repcmd.UnitIDPhotonCannon:     750,
+	repcmd.UnitIDCyberneticsCore:  900,
+	repcmd.UnitIDEvolutionChamber: 600,
+	repcmd.UnitIDCreepColony:      300,
+	repcmd.UnitIDHatchery:         1800,
+}
+
+// Thresholds of the wall heuristic
+const (
+	wallMaxSeconds   = 360 // Walls are an early game thing
+	wallMinBuildings = 3
+	wallMaxGap       = 5 * tileSize  // Between the centers of neighbouring buildings
+	wallMinDistance  = 12 * tileSize // From the start location, closer is the base itself
+	wallMaxDistance  = 45 * tileSize
+)
+
+// wallIns detects defensive walls: clusters of wall buildings placed early
+// between the player's main and the enemy, at the main's ramp or the natural.
+// Maps carry no choke data, so a wall is a tight cluster of at least
+// wallMinBuildings facing the enemy's start location.
+func wallIns(rp *rep.Replay) []Event {
+	type building struct {
+		cmd   *repcmd.BuildCmd
+		ready repcore.Frame
+	}
+	starts := startLocations(rp)
+	bases := playerBases(rp)
+	maxFrame := secondsToFrames(wallMaxSeconds)
+
+	// Direction to the nearest enemy start, the side a wall faces
+	enemyStart := map[int]repcore.Point{}
+	for pid, s := range starts {
+		nearest := -1.0
+		for epid, es := range starts {
+			if d := dist(s, es); isEnemy(rp, pid, epid) && (nearest < 0 || d < nearest) {
+				nearest, enemyStart[pid] = d, es
+			}
+		}
+	}
+
+	candidates := map[int][]building{}
+	for _, cmd := range rp.Commands {
+		c, ok := cmd.(*repcmd.BuildCmd)
+		if !ok || c.Unit == nil || c.Frame > maxFrame {
+			continue
+		}
+		frames, ok := wallBuildFrames[c.Unit.ID]
+		pid := int(c.PlayerID)
+		s, hasStart := starts[pid]
+		es, hasEnemy := enemyStart[pid]
+		if !ok || !hasStart || !hasEnemy {
+			continue
+		}
+		if d := dist(c.Pos, s); d < wallMinDistance || d > wallMaxDistance {
+			continue
+		}
+		// Must lie on the enemy's side of the main
+		toWall := [2]float64{float64(c.Pos.X) - float64(s.X), float64(c.Pos.Y) - float64(s.Y)}
+		toEnemy := [2]float64{float64(es.X) - float64(s.X), float64(es.Y) - float64(s.Y)}
+		if toWall[0]*toEnemy[0]+toWall[1]*toEnemy[1] <= 0 {
+			continue
+		}
+		candidates[pid] = append(candidates[pid], building{cmd: c, ready: c.Frame + frames})
+	}
+
+	var events []Event
+	for pid := range rp.Header.Players {
+		bs := candidates[pid]
+		// Group the buildings into clusters of neighbours
+		cluster := make([]int, len(bs))
+		for i := range cluster {
+			cluster[i] = i
+		}
+		var root func(i int) int
+		root = func(i int) int {
+			if cluster[i] != i {
+				cluster[i] = root(cluster[i])
+			}
+			return cluster[i]
+		}
+		for i := range bs {
+			for j := i + 1; j < len(bs); j++ {
+				if dist(bs[i].cmd.Pos, bs[j].cmd.Pos) <= wallMaxGap {
+					cluster[root(j)] = root(i)
+				}
+			}
+		}
+		members := map[int][]building{}
+		var roots []int
+		for i := range bs {
+			r := root(i)
+			if members[r] == nil {
+				roots = append(roots, r)
+			}
+			members[r] = append(members[r], bs[i])
+		}
+
+		for _, r := range roots {
+			wall := members[r]
+			if len(wall) < wallMinBuildings {
+				continue
+			}
+			var names []string
+			var ready repcore.Frame
+			var x, y int
+			for _, b := range wall {
+				names = append(names, b.cmd.Unit.String())
+				if b.ready > ready {
+					ready = b.ready
+				}
+				x += int(b.cmd.Pos.X)
+				y += int(b.cmd.Pos.Y)
+			}
+			center := repcore.Point{X: uint16(x / len(wall)), Y: uint16(y / len(wall))}
+			where := "main"
+			if nearestBase(bases[pid][1:], center, ready) < wallMaxDistance/3 {
+				where = "natural"
+			}
+			events = append(events, Event{
+				PlayerID: pid,
+				Kind:     EventWallIn,
+				Frame:    int(ready),
+				Time:     frameToSeconds(ready),
+				X:        int(center.X),
+				Y:        int(center.Y),
+				Description: fmt.Sprintf("wall at the %s completed (%s), started at %s",
+					where, strings.Join(names, ", "), formatClock(wall[0].cmd.Frame)),
+			})
+		}
+	}
+	return events
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// watcher polls a replay directory (e.g. the game's AutoSave folder) and
+// parses replays as they appear.
+type watcher struct {
+	dir      string
+	postURL  string // If empty, results are written next to the replays
+	interval time.Duration
+
+	// sizes of seen replays; a replay is parsed once its size is stable
+	// between two polls, so replays still being written are skipped.
+	sizes  map[string]int64
+	parsed map[string]bool
+}
+
+// runWatcher watches dir until the process exits. Replays already present at
+// startup are not parsed.
+func runWatcher(dir, postURL string, interval time.Duration) error {
+	w := &watcher{
+		dir:      dir,
+		postURL:  postURL,
+		interval: interval,
+		sizes:    map[string]int64{},
+		parsed:   map[string]bool{},
+	}
+	if err := w.scan(func(path string, _ int64) { w.parsed[path] = true }); err != nil {
+		return err
+	}
+	log.Printf("Watching %s for new replays (%d existing skipped)", dir, len(w.parsed))
+
+	for range time.Tick(interval) {
+		err := w.scan(func(path string, size int64) {
+			if w.parsed[path] {
+				return
+			}
+			if prev, ok := w.sizes[path]; !ok || prev != size {
+				w.sizes[path] = size
+				return
+			}
+			delete(w.sizes, path)
+			w.parsed[path] = true
+			if err := w.process(path); err != nil {
+				log.Printf("Error processing %s: %v", path, err)
+			}
+		})
+		if err != nil {
+			log.Printf("Error scanning %s: %v", dir, err)
+		}
+	}
+	return nil
+}
+
+// scan calls fn for every replay file under the directory.
+func (w *watcher) scan(fn func(path string, size int64)) error {
+	return filepath.WalkDir(w.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.EqualFold(filepath.Ext(path), ".rep") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // Removed in the meantime
+		}
+		fn(path, info.Size())
+		return nil
+	})
+}
+
+func (w *watcher) process(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	res, err := parseReplay(f)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(res)
+	res.release()
+	if err != nil {
+		return err
+	}
+
+	if w.postURL == "" {
+		out := strings.TrimSuffix(path, filepath.Ext(path)) + ".json"
+		log.Printf("Parsed %s, writing %s", path, out)
+		return os.WriteFile(out, data, 0o644)
+	}
+
+	log.Printf("Parsed %s, posting result to %s", path, w.postURL)
+	req, err := http.NewRequest(http.MethodPost, w.postURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Replay-File", filepath.Base(path))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("post result: %s", resp.Status)
+	}
+	return nil
+}